@@ -80,30 +80,35 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-// handleDowntime manages the registration of maintenance windows.
+// handleDowntime manages the registration and cancellation of maintenance windows.
 func handleDowntime(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
 		if !isLeader() {
 			logArbiter("[WARNING] Forbidden: Downtime request rejected, not the Raft Leader")
 			http.Error(w, "Forbidden: Not Raft Leader", http.StatusForbidden)
 			return
 		}
+	}
 
+	switch r.Method {
+	case http.MethodPost:
 		var d models.Downtime
 		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
 			http.Error(w, "[WARNING] Invalid JSON payload", http.StatusBadRequest)
 			return
 		}
+		if err := validateDowntime(d); err != nil {
+			http.Error(w, fmt.Sprintf("[WARNING] Invalid downtime: %v", err), http.StatusBadRequest)
+			return
+		}
 
 		d.ID = fmt.Sprintf("dt-%d", time.Now().UnixNano())
+		d.TriggerTime = time.Time{}
 
-		if appConfig.HAEnabled && raftNode != nil {
-			payload, _ := json.Marshal(LogPayload{Action: "ADD_DT", Data: d})
-			raftNode.Apply(payload, 5*time.Second)
-		} else {
-			configMutex.Lock()
-			downtimes = append(downtimes, d)
-			configMutex.Unlock()
+		if err := submitDowntimeAction(actionAddDowntime, d); err != nil {
+			logArbiter("[ERROR] Failed to replicate downtime %s: %v", d.ID, err)
+			http.Error(w, "Replication failed", http.StatusInternalServerError)
+			return
 		}
 
 		logArbiter("[API] New downtime registered: %s", d.ID)
@@ -111,6 +116,33 @@ func handleDowntime(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(d)
 		go refreshConfig()
 		return
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		configMutex.RLock()
+		found := false
+		for _, d := range downtimes {
+			if d.ID == id {
+				found = true
+				break
+			}
+		}
+		configMutex.RUnlock()
+		if !found {
+			http.Error(w, "Downtime not found", http.StatusNotFound)
+			return
+		}
+
+		if err := submitDowntimeAction(actionDeleteDowntime, id); err != nil {
+			logArbiter("[ERROR] Failed to replicate downtime deletion %s: %v", id, err)
+			http.Error(w, "Replication failed", http.StatusInternalServerError)
+			return
+		}
+
+		logArbiter("[API] Downtime cancelled: %s", id)
+		w.WriteHeader(http.StatusNoContent)
+		go refreshConfig()
+		return
 	}
 
 	configMutex.RLock()
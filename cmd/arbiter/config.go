@@ -15,6 +15,7 @@ import (
 type ArbiterLocalConfig struct {
 	SchedulerURLs           []string `json:"scheduler_urls"`
 	SchedulerCoolOffMinutes int      `json:"scheduler_cool_off_minutes"`
+	SchedulerSpares         map[string]string `json:"scheduler_spares"` // Spare of each Scheduler URL
 	DefinitionsDir          string   `json:"definitions_dir"`
 	APIAddress              string   `json:"api_address"`
 	APIPort                 int      `json:"api_port"`
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/cron"
+	"shinsakuto/pkg/models"
+)
+
+// Raft actions of the downtime lifecycle
+const (
+	actionAddDowntime    = "ADD_DT"
+	actionDeleteDowntime = "DEL_DT"
+	actionPurgeDowntimes = "PURGE_DT"
+)
+
+// validateDowntime checks that a downtime can be evaluated by the Schedulers
+func validateDowntime(d models.Downtime) error {
+	if d.HostName == "" && d.HostGroup == "" && d.ServiceGroup == "" {
+		return fmt.Errorf("host_name, hostgroup_name or servicegroup_name is required")
+	}
+	if d.Schedule != "" {
+		if _, err := cron.Parse(d.Schedule); err != nil {
+			return err
+		}
+		if d.Duration <= 0 {
+			return fmt.Errorf("a scheduled downtime requires a duration")
+		}
+		return nil
+	}
+	if d.TimePeriod != "" {
+		return nil
+	}
+	if !d.EndTime.After(d.StartTime) {
+		return fmt.Errorf("end_time must be after start_time")
+	}
+	if d.Flexible && d.Duration <= 0 {
+		return fmt.Errorf("a flexible downtime requires a duration")
+	}
+	return nil
+}
+
+// applyDowntimeAction mutates the downtime list; shared by the Raft FSM and standalone mode
+func applyDowntimeAction(action string, data json.RawMessage) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	switch action {
+	case actionAddDowntime:
+		var d models.Downtime
+		if json.Unmarshal(data, &d) == nil {
+			downtimes = append(downtimes, d)
+			logArbiter("[DOWNTIME] Downtime added: %s", d.ID)
+		}
+	case actionDeleteDowntime:
+		var id string
+		if json.Unmarshal(data, &id) == nil {
+			downtimes = filterDowntimes(downtimes, func(d models.Downtime) bool { return d.ID != id })
+			logArbiter("[DOWNTIME] Downtime deleted: %s", id)
+		}
+	case actionPurgeDowntimes:
+		// The cutoff travels in the log entry so every node purges the same entries
+		var cutoff time.Time
+		if json.Unmarshal(data, &cutoff) == nil {
+			before := len(downtimes)
+			downtimes = filterDowntimes(downtimes, func(d models.Downtime) bool { return !d.Expired(cutoff) })
+			if n := before - len(downtimes); n > 0 {
+				logArbiter("[DOWNTIME] Purged %d expired downtime(s)", n)
+			}
+		}
+	}
+}
+
+// submitDowntimeAction replicates an action through Raft, or applies it locally without HA
+func submitDowntimeAction(action string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if appConfig.HAEnabled && raftNode != nil {
+		payload, _ := json.Marshal(LogPayload{Action: action, Data: json.RawMessage(raw)})
+		// Wait for the FSM so the following refresh already carries the change
+		return raftNode.Apply(payload, 5*time.Second).Error()
+	}
+	applyDowntimeAction(action, raw)
+	return nil
+}
+
+// purgeExpiredDowntimes drops one-off downtimes that ended, only run by the leader
+func purgeExpiredDowntimes() {
+	now := time.Now()
+	configMutex.RLock()
+	expired := false
+	for _, d := range downtimes {
+		if d.Expired(now) {
+			expired = true
+			break
+		}
+	}
+	configMutex.RUnlock()
+
+	if expired {
+		if err := submitDowntimeAction(actionPurgeDowntimes, now); err != nil {
+			logArbiter("[ERROR] Failed to purge expired downtimes: %v", err)
+		}
+	}
+}
+
+// expandDowntimes turns hostgroup and servicegroup downtimes into one downtime per member.
+// Expanded downtimes get the ID "<downtime>:<member>" so they can be tracked independently.
+// Downtimes without an ID are dropped: the Schedulers track downtimes by ID.
+func expandDowntimes(dts []models.Downtime, hGroups map[string]*models.HostGroup, sGroups map[string]*models.ServiceGroup) []models.Downtime {
+	var out []models.Downtime
+	for _, d := range dts {
+		if d.ID == "" {
+			logArbiter("[WARNING] Downtime on %s ignored: missing its ID", downtimeTarget(d))
+			continue
+		}
+		switch {
+		case d.HostGroup != "":
+			g, ok := hGroups[d.HostGroup]
+			if !ok {
+				logArbiter("[WARNING] Downtime %s references an unknown hostgroup: %s", d.ID, d.HostGroup)
+				continue
+			}
+			for _, m := range g.Members {
+				md := d
+				md.ID, md.HostName, md.ServiceID = d.ID+":"+m, m, ""
+				out = append(out, md)
+			}
+		case d.ServiceGroup != "":
+			g, ok := sGroups[d.ServiceGroup]
+			if !ok {
+				logArbiter("[WARNING] Downtime %s references an unknown servicegroup: %s", d.ID, d.ServiceGroup)
+				continue
+			}
+			// Servicegroup members are "host,service" pairs
+			for _, m := range g.Members {
+				parts := strings.SplitN(m, ",", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				md := d
+				md.HostName, md.ServiceID = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+				md.ID = d.ID + ":" + models.ServiceKey(md.HostName, md.ServiceID)
+				out = append(out, md)
+			}
+		default:
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// downtimeTarget names the object or group a downtime applies to
+func downtimeTarget(d models.Downtime) string {
+	switch {
+	case d.HostGroup != "":
+		return "hostgroup " + d.HostGroup
+	case d.ServiceGroup != "":
+		return "servicegroup " + d.ServiceGroup
+	case d.ServiceID != "":
+		return models.ServiceKey(d.HostName, d.ServiceID)
+	}
+	return d.HostName
+}
+
+// filterDowntimes keeps the downtimes accepted by keep
+func filterDowntimes(dts []models.Downtime, keep func(models.Downtime) bool) []models.Downtime {
+	out := make([]models.Downtime, 0, len(dts))
+	for _, d := range dts {
+		if keep(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -119,17 +119,15 @@ func isLeader() bool {
 type arbiterFSM struct{}
 
 func (f *arbiterFSM) Apply(l *raft.Log) interface{} {
-	var p LogPayload
-	json.Unmarshal(l.Data, &p)
-	if p.Action == "ADD_DT" {
-		var d models.Downtime
-		dataBytes, _ := json.Marshal(p.Data)
-		json.Unmarshal(dataBytes, &d)
-		configMutex.Lock()
-		downtimes = append(downtimes, d)
-		configMutex.Unlock()
-		logArbiter("[FSM] Replicated downtime applied: %s", d.ID)
+	var p struct {
+		Action string          `json:"action"`
+		Data   json.RawMessage `json:"data"`
 	}
+	if err := json.Unmarshal(l.Data, &p); err != nil {
+		return err
+	}
+	applyDowntimeAction(p.Action, p.Data)
+	logArbiter("[FSM] Replicated action applied: %s", p.Action)
 	return nil
 }
 
@@ -3,7 +3,12 @@ package main
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+
+	"shinsakuto/pkg/macros"
 	"shinsakuto/pkg/models"
+	"shinsakuto/pkg/timeperiod"
 )
 
 // ObjectCounts stores statistics of loaded and registered monitoring objects.
@@ -16,6 +21,7 @@ type ObjectCounts struct {
 	Contacts      int
 	HostGroups    int
 	ServiceGroups int
+	Dependencies  int
 }
 
 // LinterResult contains the audit results, including critical errors, 
@@ -38,6 +44,7 @@ func RunLinter(cfg *models.GlobalConfig) LinterResult {
 			Contacts:      len(cfg.Contacts),
 			HostGroups:    len(cfg.HostGroups),
 			ServiceGroups: len(cfg.ServiceGroups),
+			Dependencies:  len(cfg.HostDependencies) + len(cfg.ServiceDependencies),
 		},
 	}
 
@@ -72,6 +79,13 @@ func RunLinter(cfg *models.GlobalConfig) LinterResult {
 		}
 	}
 
+	// Host topology: parents must exist and must not form a cycle
+	res.Errors = append(res.Errors, lintParents(cfg.Hosts, hostMap)...)
+
+	// serviceMap stores host/service identities to detect duplicates on the same host.
+	// The same service ID may legitimately exist on several hosts.
+	serviceMap := make(map[string]bool)
+
 	// 2. Service Validation and Counting
 	for _, s := range cfg.Services {
 		// Ignore service templates
@@ -93,8 +107,300 @@ func RunLinter(cfg *models.GlobalConfig) LinterResult {
 			// A service must point to a host that exists and is NOT a template
 			res.Errors = append(res.Errors, fmt.Sprintf("[ERROR] Service %s references an unknown or unregistered host: %s", s.ID, s.HostName))
 		}
+
+		// Critical: A service ID must be unique on its host
+		if serviceMap[s.Key()] {
+			res.Errors = append(res.Errors, fmt.Sprintf("[ERROR] Duplicate service detected: %s", s.Key()))
+		}
+		serviceMap[s.Key()] = true
+	}
+
+	// Dependencies must link registered objects with valid failure criteria
+	res.Errors = append(res.Errors, lintDependencies(cfg, hostMap, serviceMap)...)
+
+	// Invalid downtimes or downtimes of removed objects are harmless but will never apply
+	tpMap := make(map[string]bool)
+	for _, tp := range cfg.TimePeriods {
+		tpMap[tp.ID] = true
+	}
+	for _, d := range cfg.Downtimes {
+		if d.ID == "" {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("[WARNING] Downtime on %s is missing its ID and is ignored", downtimeTarget(d)))
+		}
+		if err := validateDowntime(d); err != nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("[WARNING] Downtime %s is invalid: %v", d.ID, err))
+		}
+		if d.TimePeriod != "" && !tpMap[d.TimePeriod] {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("[WARNING] Downtime %s references an unknown timeperiod: %s", d.ID, d.TimePeriod))
+		}
+		if d.ServiceID != "" && !serviceMap[models.ServiceKey(d.HostName, d.ServiceID)] {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("[WARNING] Downtime %s references an unknown service: %s", d.ID, models.ServiceKey(d.HostName, d.ServiceID)))
+		} else if d.ServiceID == "" && !hostMap[d.HostName] {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("[WARNING] Downtime %s references an unknown host: %s", d.ID, d.HostName))
+		}
+	}
+
+	// Time periods must compile, and objects must reference defined periods
+	res.Errors = append(res.Errors, lintTimePeriods(cfg)...)
+
+	// Escalations and contact groups must reference defined contacts and periods
+	res.Errors = append(res.Errors, lintEscalations(cfg)...)
+
+	// 3. Command and Macro Validation
+	cmdMap := make(map[string]string)
+	for _, c := range cfg.Commands {
+		cmdMap[c.ID] = c.CommandLine
+		res.Warnings = append(res.Warnings, lintMacros("Command "+c.ID, c.CommandLine, cfg.Resources)...)
+	}
+	for _, h := range cfg.Hosts {
+		if h.Register == nil || *h.Register {
+			res.Warnings = append(res.Warnings, lintCheckCommand("Host "+h.ID, h.CheckCommand, cmdMap, cfg.Resources)...)
+			res.Warnings = append(res.Warnings, lintCheckCommand("Host "+h.ID, h.FreshnessCommand, cmdMap, cfg.Resources)...)
+			res.Warnings = append(res.Warnings, lintCheckCommand("Host "+h.ID, h.EventHandler, cmdMap, cfg.Resources)...)
+		}
+	}
+	for _, s := range cfg.Services {
+		if s.Register == nil || *s.Register {
+			res.Warnings = append(res.Warnings, lintCheckCommand("Service "+s.Key(), s.CheckCommand, cmdMap, cfg.Resources)...)
+			res.Warnings = append(res.Warnings, lintCheckCommand("Service "+s.Key(), s.FreshnessCommand, cmdMap, cfg.Resources)...)
+			res.Warnings = append(res.Warnings, lintCheckCommand("Service "+s.Key(), s.EventHandler, cmdMap, cfg.Resources)...)
+		}
 	}
 
 	logArbiter("[LINTER] Audit complete: %d errors, %d warnings", len(res.Errors), len(res.Warnings))
 	return res
 }
+
+// lintParents reports unknown parents and parent cycles among registered hosts
+func lintParents(hosts []models.Host, hostMap map[string]bool) []string {
+	var errs []string
+	parents := make(map[string][]string)
+	for _, h := range hosts {
+		if h.Register != nil && !*h.Register {
+			continue
+		}
+		for _, p := range h.Parents {
+			if !hostMap[p] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Host %s references an unknown parent: %s", h.ID, p))
+				continue
+			}
+			parents[h.ID] = append(parents[h.ID], p)
+		}
+	}
+
+	// Depth-first search: a host met again while still on the stack closes a cycle
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[string]int)
+	var visit func(id string, path []string)
+	visit = func(id string, path []string) {
+		state[id] = inProgress
+		path = append(path, id)
+		for _, p := range parents[id] {
+			switch state[p] {
+			case inProgress:
+				errs = append(errs, fmt.Sprintf("[ERROR] Parent cycle detected: %s -> %s", strings.Join(path, " -> "), p))
+			case unvisited:
+				visit(p, path)
+			}
+		}
+		state[id] = done
+	}
+	for _, h := range hosts {
+		if state[h.ID] == unvisited && len(parents[h.ID]) > 0 {
+			visit(h.ID, nil)
+		}
+	}
+	return errs
+}
+
+// lintDependencies reports dependencies on unknown objects and invalid failure criteria
+func lintDependencies(cfg *models.GlobalConfig, hostMap, serviceMap map[string]bool) []string {
+	var errs []string
+	for _, d := range cfg.HostDependencies {
+		for _, h := range []string{d.HostName, d.DependentHostName} {
+			if !hostMap[h] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Host dependency references an unknown host: %s", h))
+			}
+		}
+		errs = append(errs, lintCriteria("Host dependency "+d.DependentHostName, d.ExecutionFailureCriteria, "odun")...)
+		errs = append(errs, lintCriteria("Host dependency "+d.DependentHostName, d.NotificationFailureCriteria, "odun")...)
+	}
+	for _, d := range cfg.ServiceDependencies {
+		master := models.ServiceKey(d.HostName, d.ServiceID)
+		dependent := models.ServiceKey(d.DependentHostName, d.DependentServiceID)
+		for _, k := range []string{master, dependent} {
+			if !serviceMap[k] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Service dependency references an unknown service: %s", k))
+			}
+		}
+		errs = append(errs, lintCriteria("Service dependency "+dependent, d.ExecutionFailureCriteria, "owcun")...)
+		errs = append(errs, lintCriteria("Service dependency "+dependent, d.NotificationFailureCriteria, "owcun")...)
+	}
+	return errs
+}
+
+// lintTimePeriods reports invalid time periods and references to unknown periods
+func lintTimePeriods(cfg *models.GlobalConfig) []string {
+	var errs []string
+	set, compileErrs := timeperiod.NewSet(cfg.TimePeriods)
+	for _, err := range compileErrs {
+		errs = append(errs, fmt.Sprintf("[ERROR] %v", err))
+	}
+	check := func(owner, kind, id string) {
+		if id != "" && !set.Has(id) {
+			errs = append(errs, fmt.Sprintf("[ERROR] %s references an unknown %s: %s", owner, kind, id))
+		}
+	}
+	for _, h := range cfg.Hosts {
+		if h.Register == nil || *h.Register {
+			check("Host "+h.ID, "check_period", h.CheckPeriod)
+			check("Host "+h.ID, "notification_period", h.NotificationPeriod)
+		}
+	}
+	for _, s := range cfg.Services {
+		if s.Register == nil || *s.Register {
+			check("Service "+s.Key(), "check_period", s.CheckPeriod)
+			check("Service "+s.Key(), "notification_period", s.NotificationPeriod)
+		}
+	}
+	return errs
+}
+
+// lintEscalations validates contact groups, escalations and the references to them
+func lintEscalations(cfg *models.GlobalConfig) []string {
+	var errs []string
+	contacts := make(map[string]bool)
+	for _, c := range cfg.Contacts {
+		contacts[c.ID] = true
+	}
+	groups := make(map[string]bool)
+	for _, g := range cfg.ContactGroups {
+		groups[g.ID] = true
+		for _, m := range g.Members {
+			if !contacts[m] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Contact group %s references an unknown contact: %s", g.ID, m))
+			}
+		}
+	}
+	periods := make(map[string]bool)
+	for _, tp := range cfg.TimePeriods {
+		periods[tp.ID] = true
+	}
+
+	escalations := make(map[string]bool)
+	for _, e := range cfg.Escalations {
+		escalations[e.ID] = true
+		for _, c := range e.Contacts {
+			if !contacts[c] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s references an unknown contact: %s", e.ID, c))
+			}
+		}
+		for _, g := range e.ContactGroups {
+			if !groups[g] {
+				errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s references an unknown contact group: %s", e.ID, g))
+			}
+		}
+		if e.EscalationPeriod != "" && !periods[e.EscalationPeriod] {
+			errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s references an unknown escalation_period: %s", e.ID, e.EscalationPeriod))
+		}
+		if e.LastNotification > 0 && e.LastNotification < e.FirstNotification {
+			errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s: last_notification is lower than first_notification", e.ID))
+		}
+		if e.LastNotificationTime > 0 && e.LastNotificationTime < e.FirstNotificationTime {
+			errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s: last_notification_time is lower than first_notification_time", e.ID))
+		}
+		if len(e.Contacts) == 0 && len(e.ContactGroups) == 0 {
+			errs = append(errs, fmt.Sprintf("[ERROR] Escalation %s has no contacts", e.ID))
+		}
+	}
+
+	check := func(owner string, groupRefs, escRefs []string) {
+		for _, g := range groupRefs {
+			if !groups[g] {
+				errs = append(errs, fmt.Sprintf("[ERROR] %s references an unknown contact group: %s", owner, g))
+			}
+		}
+		for _, e := range escRefs {
+			if !escalations[e] {
+				errs = append(errs, fmt.Sprintf("[ERROR] %s references an unknown escalation: %s", owner, e))
+			}
+		}
+	}
+	for _, h := range cfg.Hosts {
+		if h.Register == nil || *h.Register {
+			check("Host "+h.ID, h.ContactGroups, h.Escalations)
+		}
+	}
+	for _, s := range cfg.Services {
+		if s.Register == nil || *s.Register {
+			check("Service "+s.Key(), s.ContactGroups, s.Escalations)
+		}
+	}
+	return errs
+}
+
+// lintCriteria validates a comma-separated list of failure criteria letters
+func lintCriteria(owner, criteria, allowed string) []string {
+	var errs []string
+	if criteria == "" {
+		return nil
+	}
+	for _, c := range strings.Split(criteria, ",") {
+		c = strings.TrimSpace(c)
+		if len(c) != 1 || !strings.Contains(allowed, c) {
+			errs = append(errs, fmt.Sprintf("[ERROR] %s has an invalid failure criteria: %q", owner, c))
+		}
+	}
+	return errs
+}
+
+// lintCheckCommand validates a check_command reference against the command definitions.
+// References that are not defined commands are checked as literal command lines.
+func lintCheckCommand(owner, ref string, cmdMap map[string]string, resources map[string]string) []string {
+	if ref == "" {
+		return nil
+	}
+	name, args := macros.SplitCommand(ref)
+	line, ok := cmdMap[name]
+	if !ok {
+		// A bare identifier is most likely a typo in a command name rather than a shell command
+		if !strings.ContainsAny(ref, " /") {
+			return []string{fmt.Sprintf("[WARNING] %s references an undefined command: %s", owner, name)}
+		}
+		return lintMacros(owner, ref, resources)
+	}
+
+	var warnings []string
+	for _, a := range args {
+		warnings = append(warnings, lintMacros(owner, a, resources)...)
+	}
+	// Every $ARGn$ used by the command line must be provided by the reference
+	for _, m := range macros.Find(line) {
+		if !strings.HasPrefix(m, "ARG") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(m, "ARG")); err == nil && n > len(args) {
+			warnings = append(warnings, fmt.Sprintf("[WARNING] %s calls %s without a value for $%s$", owner, name, m))
+		}
+	}
+	return warnings
+}
+
+// lintMacros reports unknown macros and undefined $USERn$ resources in a command string
+func lintMacros(owner, line string, resources map[string]string) []string {
+	var warnings []string
+	for _, m := range macros.Find(line) {
+		if !macros.IsKnown(m) {
+			warnings = append(warnings, fmt.Sprintf("[WARNING] %s uses an unknown macro: $%s$", owner, m))
+		} else if strings.HasPrefix(m, "USER") {
+			if _, ok := resources[m]; !ok {
+				warnings = append(warnings, fmt.Sprintf("[WARNING] %s uses an undefined resource macro: $%s$", owner, m))
+			}
+		}
+	}
+	return warnings
+}
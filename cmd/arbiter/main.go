@@ -42,6 +42,7 @@ func main() {
 		fmt.Printf(" Contacts:              %d\n", audit.Counts.Contacts)
 		fmt.Printf(" Host Groups:           %d\n", audit.Counts.HostGroups)
 		fmt.Printf(" Service Groups:        %d\n", audit.Counts.ServiceGroups)
+		fmt.Printf(" Dependencies:          %d\n", audit.Counts.Dependencies)
 		fmt.Println("-------------------------------------------")
 
 		for _, w := range audit.Warnings {
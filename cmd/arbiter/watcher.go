@@ -65,6 +65,11 @@ func refreshConfig() {
 		isInCoolOff = false 
 	}
 
+	// Expired downtimes are dropped cluster-wide before building the shards
+	if isLeader() {
+		purgeExpiredDowntimes()
+	}
+
 	cfg, err := loadAndProcess()
 	if err != nil {
 		log.Printf("[ERROR] Failed to process configuration: %v", err)
@@ -109,20 +114,31 @@ func partitionConfig(fullCfg *models.GlobalConfig, n int) []models.GlobalConfig
 
 	shards := make([]models.GlobalConfig, n)
 	for i := 0; i < n; i++ {
-		// Commands, Periods, and Contacts are mirrored to all shards for contextual integrity
+		// Commands, Periods, Contacts and Escalations are mirrored to all shards for contextual integrity
 		shards[i] = models.GlobalConfig{
 			Commands:    fullCfg.Commands,
 			TimePeriods: fullCfg.TimePeriods,
 			Contacts:    fullCfg.Contacts,
+			ContactGroups: fullCfg.ContactGroups,
+			Escalations:   fullCfg.Escalations,
+			Resources:   fullCfg.Resources,
 			Hosts:       []models.Host{},
 			Services:    []models.Service{},
 		}
 	}
 
-	// Distribute Hosts using Round-Robin sharding
+	// Distribute Hosts using Round-Robin sharding over topology groups,
+	// so that a host always lives on the same shard as its parents
 	hostToShard := make(map[string]int)
-	for i, host := range fullCfg.Hosts {
-		shardIdx := i % n
+	groupToShard := make(map[string]int)
+	groups := shardGroups(fullCfg)
+	for _, host := range fullCfg.Hosts {
+		root := groups.find(host.ID)
+		shardIdx, ok := groupToShard[root]
+		if !ok {
+			shardIdx = len(groupToShard) % n
+			groupToShard[root] = shardIdx
+		}
 		shards[shardIdx].Hosts = append(shards[shardIdx].Hosts, host)
 		hostToShard[host.ID] = shardIdx
 	}
@@ -134,9 +150,66 @@ func partitionConfig(fullCfg *models.GlobalConfig, n int) []models.GlobalConfig
 		}
 	}
 
+	// Dependencies follow their dependent host, which shares its shard with the master
+	for _, d := range fullCfg.HostDependencies {
+		if idx, ok := hostToShard[d.DependentHostName]; ok {
+			shards[idx].HostDependencies = append(shards[idx].HostDependencies, d)
+		}
+	}
+	for _, d := range fullCfg.ServiceDependencies {
+		if idx, ok := hostToShard[d.DependentHostName]; ok {
+			shards[idx].ServiceDependencies = append(shards[idx].ServiceDependencies, d)
+		}
+	}
+
+	// Downtimes are evaluated by the Scheduler owning their host
+	for _, d := range fullCfg.Downtimes {
+		if idx, ok := hostToShard[d.HostName]; ok {
+			shards[idx].Downtimes = append(shards[idx].Downtimes, d)
+		}
+	}
+
 	return shards
 }
 
+// unionFind groups object IDs that must be scheduled together
+type unionFind map[string]string
+
+func (u unionFind) find(id string) string {
+	parent, ok := u[id]
+	if !ok || parent == id {
+		u[id] = id
+		return id
+	}
+	root := u.find(parent)
+	u[id] = root
+	return root
+}
+
+func (u unionFind) union(a, b string) {
+	ra, rb := u.find(a), u.find(b)
+	if ra != rb {
+		u[ra] = rb
+	}
+}
+
+// shardGroups links every host with its parents and with the masters it depends on
+func shardGroups(cfg *models.GlobalConfig) unionFind {
+	groups := make(unionFind)
+	for _, h := range cfg.Hosts {
+		for _, p := range h.Parents {
+			groups.union(h.ID, p)
+		}
+	}
+	for _, d := range cfg.HostDependencies {
+		groups.union(d.DependentHostName, d.HostName)
+	}
+	for _, d := range cfg.ServiceDependencies {
+		groups.union(d.DependentHostName, d.HostName)
+	}
+	return groups
+}
+
 // syncShardsToSchedulers iterates over scheduler URLs and pushes their respective shards.
 func syncShardsToSchedulers(shards []models.GlobalConfig) {
 	successCount := 0
@@ -150,30 +223,17 @@ func syncShardsToSchedulers(shards []models.GlobalConfig) {
 	for i, rawURL := range appConfig.SchedulerURLs {
 		if i >= len(shards) { break }
 		
-		url := strings.TrimSuffix(rawURL, "/") + "/v1/sync-all"
 		data, _ := json.Marshal(shards[i])
-		
-		// Retry logic: 3 attempts per scheduler
-		for attempt := 1; attempt <= 3; attempt++ {
-			logArbiter("[WATCHER] Sending shard %d to %s (Attempt %d/3)", i, url, attempt)
-			
-			resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(data))
-			if err == nil && resp.StatusCode == http.StatusOK {
-				resp.Body.Close()
-				logArbiter("[WATCHER] Successfully synchronized shard %d with %s", i, url)
-				successCount++
-				break 
-			}
-
-			if err != nil {
-				logArbiter("[WATCHER] Attempt %d failed for %s: %v", attempt, url, err)
-			} else {
-				logArbiter("[WATCHER] Attempt %d failed for %s: Status %d", attempt, url, resp.StatusCode)
-				resp.Body.Close()
-			}
+		if pushShard(i, rawURL, data) {
+			successCount++
+			continue
+		}
 
-			if attempt < 3 {
-				time.Sleep(5 * time.Second) 
+		// A failed primary hands its shard to its spare
+		if spare, ok := appConfig.SchedulerSpares[rawURL]; ok {
+			logArbiter("[WATCHER] Scheduler %s unreachable, sending shard %d to its spare %s", rawURL, i, spare)
+			if pushShard(i, spare, data) {
+				successCount++
 			}
 		}
 	}
@@ -189,9 +249,36 @@ func syncShardsToSchedulers(shards []models.GlobalConfig) {
 	}
 }
 
+// pushShard sends a shard to a Scheduler with retry logic: 3 attempts
+func pushShard(i int, rawURL string, data []byte) bool {
+	url := strings.TrimSuffix(rawURL, "/") + "/v1/sync-all"
+	for attempt := 1; attempt <= 3; attempt++ {
+		logArbiter("[WATCHER] Sending shard %d to %s (Attempt %d/3)", i, url, attempt)
+		
+		resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			logArbiter("[WATCHER] Successfully synchronized shard %d with %s", i, url)
+			return true
+		}
+
+		if err != nil {
+			logArbiter("[WATCHER] Attempt %d failed for %s: %v", attempt, url, err)
+		} else {
+			logArbiter("[WATCHER] Attempt %d failed for %s: Status %d", attempt, url, resp.StatusCode)
+			resp.Body.Close()
+		}
+
+		if attempt < 3 {
+			time.Sleep(5 * time.Second) 
+		}
+	}
+	return false
+}
+
 // loadAndProcess handles recursive inheritance and automatic group assignment.
 func loadAndProcess() (*models.GlobalConfig, error) {
-	raw := &models.GlobalConfig{}
+	raw := &models.GlobalConfig{Resources: make(map[string]string)}
 	
 	err := filepath.Walk(appConfig.DefinitionsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() { return err }
@@ -205,8 +292,14 @@ func loadAndProcess() (*models.GlobalConfig, error) {
 				raw.Commands = append(raw.Commands, tmp.Commands...)
 				raw.TimePeriods = append(raw.TimePeriods, tmp.TimePeriods...)
 				raw.Contacts = append(raw.Contacts, tmp.Contacts...)
+				raw.ContactGroups = append(raw.ContactGroups, tmp.ContactGroups...)
+				raw.Escalations = append(raw.Escalations, tmp.Escalations...)
 				raw.HostGroups = append(raw.HostGroups, tmp.HostGroups...)
 				raw.ServiceGroups = append(raw.ServiceGroups, tmp.ServiceGroups...)
+				raw.ServiceDependencies = append(raw.ServiceDependencies, tmp.ServiceDependencies...)
+				raw.HostDependencies = append(raw.HostDependencies, tmp.HostDependencies...)
+				raw.Downtimes = append(raw.Downtimes, tmp.Downtimes...)
+				for k, v := range tmp.Resources { raw.Resources[k] = v }
 			}
 		}
 		return nil
@@ -217,6 +310,11 @@ func loadAndProcess() (*models.GlobalConfig, error) {
 		Commands:    raw.Commands,
 		TimePeriods: raw.TimePeriods,
 		Contacts:    raw.Contacts,
+		ContactGroups: raw.ContactGroups,
+		Escalations:   raw.Escalations,
+		Resources:   raw.Resources,
+		ServiceDependencies: raw.ServiceDependencies,
+		HostDependencies:    raw.HostDependencies,
 	}
 
 	hTemplates := make(map[string]models.Host)
@@ -265,6 +363,13 @@ func loadAndProcess() (*models.GlobalConfig, error) {
 	for _, g := range hGroups { final.HostGroups = append(final.HostGroups, *g) }
 	for _, g := range sGroups { final.ServiceGroups = append(final.ServiceGroups, *g) }
 
+	// Downtimes from YAML (usually recurring) and from the API (replicated by Raft)
+	// travel with the configuration, group downtimes expanded to their members
+	configMutex.RLock()
+	dts := append(append([]models.Downtime(nil), raw.Downtimes...), downtimes...)
+	configMutex.RUnlock()
+	final.Downtimes = expandDowntimes(dts, hGroups, sGroups)
+
 	return final, nil
 }
 
@@ -275,8 +380,27 @@ func resolveHostInheritance(h models.Host, templates map[string]models.Host, dep
 		if h.Address == "" { h.Address = p.Address }
 		if h.CheckCommand == "" { h.CheckCommand = p.CheckCommand }
 		if h.CheckPeriod == "" { h.CheckPeriod = p.CheckPeriod }
+		if h.NotificationPeriod == "" { h.NotificationPeriod = p.NotificationPeriod }
+		if h.NotificationInterval == 0 { h.NotificationInterval = p.NotificationInterval }
+		if h.EventHandler == "" { h.EventHandler = p.EventHandler }
+		if h.EventHandlerEnabled == nil { h.EventHandlerEnabled = p.EventHandlerEnabled }
 		if len(h.Contacts) == 0 { h.Contacts = p.Contacts }
+		if len(h.ContactGroups) == 0 { h.ContactGroups = p.ContactGroups }
+		if len(h.Escalations) == 0 { h.Escalations = p.Escalations }
 		if len(h.HostGroups) == 0 { h.HostGroups = p.HostGroups }
+		if h.CheckInterval == 0 { h.CheckInterval = p.CheckInterval }
+		if h.NormalInterval == 0 { h.NormalInterval = p.NormalInterval }
+		if h.MaxAttempts == 0 { h.MaxAttempts = p.MaxAttempts }
+		if h.RetryInterval == 0 { h.RetryInterval = p.RetryInterval }
+		if h.FlapDetectionEnabled == nil { h.FlapDetectionEnabled = p.FlapDetectionEnabled }
+		if h.LowFlapThreshold == 0 { h.LowFlapThreshold = p.LowFlapThreshold }
+		if h.HighFlapThreshold == 0 { h.HighFlapThreshold = p.HighFlapThreshold }
+		if h.ActiveChecksEnabled == nil { h.ActiveChecksEnabled = p.ActiveChecksEnabled }
+		if h.PassiveChecksEnabled == nil { h.PassiveChecksEnabled = p.PassiveChecksEnabled }
+		if !h.CheckFreshness { h.CheckFreshness = p.CheckFreshness }
+		if h.FreshnessThreshold == 0 { h.FreshnessThreshold = p.FreshnessThreshold }
+		if h.FreshnessCommand == "" { h.FreshnessCommand = p.FreshnessCommand }
+		if h.StaleState == 0 { h.StaleState = p.StaleState }
 	}
 	return h
 }
@@ -287,8 +411,27 @@ func resolveServiceInheritance(s models.Service, templates map[string]models.Ser
 		p := resolveServiceInheritance(parent, templates, depth+1)
 		if s.CheckCommand == "" { s.CheckCommand = p.CheckCommand }
 		if s.CheckPeriod == "" { s.CheckPeriod = p.CheckPeriod }
+		if s.NotificationPeriod == "" { s.NotificationPeriod = p.NotificationPeriod }
+		if s.NotificationInterval == 0 { s.NotificationInterval = p.NotificationInterval }
+		if s.EventHandler == "" { s.EventHandler = p.EventHandler }
+		if s.EventHandlerEnabled == nil { s.EventHandlerEnabled = p.EventHandlerEnabled }
 		if len(s.Contacts) == 0 { s.Contacts = p.Contacts }
+		if len(s.ContactGroups) == 0 { s.ContactGroups = p.ContactGroups }
+		if len(s.Escalations) == 0 { s.Escalations = p.Escalations }
 		if len(s.ServiceGroups) == 0 { s.ServiceGroups = p.ServiceGroups }
+		if s.CheckInterval == 0 { s.CheckInterval = p.CheckInterval }
+		if s.NormalInterval == 0 { s.NormalInterval = p.NormalInterval }
+		if s.MaxAttempts == 0 { s.MaxAttempts = p.MaxAttempts }
+		if s.RetryInterval == 0 { s.RetryInterval = p.RetryInterval }
+		if s.FlapDetectionEnabled == nil { s.FlapDetectionEnabled = p.FlapDetectionEnabled }
+		if s.LowFlapThreshold == 0 { s.LowFlapThreshold = p.LowFlapThreshold }
+		if s.HighFlapThreshold == 0 { s.HighFlapThreshold = p.HighFlapThreshold }
+		if s.ActiveChecksEnabled == nil { s.ActiveChecksEnabled = p.ActiveChecksEnabled }
+		if s.PassiveChecksEnabled == nil { s.PassiveChecksEnabled = p.PassiveChecksEnabled }
+		if !s.CheckFreshness { s.CheckFreshness = p.CheckFreshness }
+		if s.FreshnessThreshold == 0 { s.FreshnessThreshold = p.FreshnessThreshold }
+		if s.FreshnessCommand == "" { s.FreshnessCommand = p.FreshnessCommand }
+		if s.StaleState == 0 { s.StaleState = p.StaleState }
 	}
 	return s
 }
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"shinsakuto/pkg/models"
 	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/perfdata"
 )
 
 // startWorkers initializes the pool of background database writers
@@ -51,10 +54,26 @@ func flushToTSDB(results []models.CheckResult) {
 	now := time.Now().UnixNano()
 
 	for _, res := range results {
+		// Results carry the end time of their check when the Poller reports it
+		ts := now
+		if !res.EndTime.IsZero() {
+			ts = res.EndTime.UnixNano()
+		}
+		output := res.ShortOutput
+		if output == "" && len(res.Perfdata) == 0 {
+			output = res.Output
+		}
+
 		// Format: measurement,tag=val field=val timestamp (Influx Line Protocol)
-		line := fmt.Sprintf("shinsakuto_check,id=%s status=%di,output=\"%s\" %d\n",
-			res.ID, res.Status, res.Output, now)
+		tags := entityTags(res.ID)
+		line := fmt.Sprintf("shinsakuto_check,%s status=%di,output=\"%s\" %d\n",
+			tags, res.Status, escapeField(output), ts)
 		buffer.WriteString(line)
+
+		// One numeric series per performance metric
+		for _, m := range res.Perfdata {
+			buffer.WriteString(perfdataLine(tags, m, ts))
+		}
 	}
 
 	req, err := http.NewRequest("POST", appConfig.TSDBUrl, &buffer)
@@ -80,3 +99,56 @@ func flushToTSDB(results []models.CheckResult) {
 		logger.Info("[ERROR] TSDB returned unexpected status: %d", resp.StatusCode)
 	}
 }
+
+// perfdataLine formats a metric as "shinsakuto_perfdata,<tags>,metric=<label>[,uom=<uom>] value=..."
+// Thresholds given as plain numbers and the min/max bounds are written as extra fields.
+func perfdataLine(tags string, m models.PerfMetric, ts int64) string {
+	var b strings.Builder
+	b.WriteString("shinsakuto_perfdata," + tags + ",metric=" + escapeTag(m.Label))
+	if m.UOM != "" {
+		b.WriteString(",uom=" + escapeTag(m.UOM))
+	}
+	b.WriteString(" value=" + formatFloat(m.Value))
+	if v, ok := perfdata.Threshold(m.Warn); ok {
+		b.WriteString(",warn=" + formatFloat(v))
+	}
+	if v, ok := perfdata.Threshold(m.Crit); ok {
+		b.WriteString(",crit=" + formatFloat(v))
+	}
+	if m.Min != nil {
+		b.WriteString(",min=" + formatFloat(*m.Min))
+	}
+	if m.Max != nil {
+		b.WriteString(",max=" + formatFloat(*m.Max))
+	}
+	b.WriteString(" " + strconv.FormatInt(ts, 10) + "\n")
+	return b.String()
+}
+
+// formatFloat writes a Line Protocol float field value
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeField escapes the characters reserved in Line Protocol string fields
+func escapeField(v string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(v)
+}
+
+// entityTags builds the id/host/service tag set of a check result.
+// Host checks are prefixed with "HOST:", services use the "host/service" identity.
+func entityTags(id string) string {
+	tags := "id=" + escapeTag(id)
+	if strings.HasPrefix(id, "HOST:") {
+		return tags + ",host=" + escapeTag(strings.TrimPrefix(id, "HOST:"))
+	}
+	if host, service, ok := models.ParseServiceKey(id); ok {
+		return tags + ",host=" + escapeTag(host) + ",service=" + escapeTag(service)
+	}
+	return tags
+}
+
+// escapeTag escapes the characters reserved in Line Protocol tag values
+func escapeTag(v string) string {
+	return strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ").Replace(v)
+}
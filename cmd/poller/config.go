@@ -28,7 +28,18 @@ func loadConfig(path string) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &appConfig)
+	if err := json.Unmarshal(data, &appConfig); err != nil {
+		return err
+	}
+
+	// Sane defaults: the semaphore and the result flusher need positive values
+	if appConfig.MaxConcurrent <= 0 {
+		appConfig.MaxConcurrent = 10
+	}
+	if appConfig.IntervalMS <= 0 {
+		appConfig.IntervalMS = 1000
+	}
+	return nil
 }
 
 // initLogger configures the logging package with the settings from config
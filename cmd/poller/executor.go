@@ -9,6 +9,7 @@ import (
 
 	"shinsakuto/pkg/logger"
 	"shinsakuto/pkg/models"
+	"shinsakuto/pkg/perfdata"
 )
 
 // executeTask runs the provided command and captures Nagios-style exit codes
@@ -22,18 +23,34 @@ func executeTask(task models.CheckTask) models.CheckResult {
 
 	// Execute through /bin/sh to support shell features in the command string
 	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", task.Command)
+	start := time.Now()
 	output, err := cmd.CombinedOutput()
+	end := time.Now()
 
 	result := models.CheckResult{
-		ID:     task.ID,
-		Output: strings.TrimSpace(string(output)),
+		ID:            task.ID,
+		Output:        strings.TrimSpace(string(output)),
+		Seq:           task.Seq,
+		PollerID:      appConfig.PollerID,
+		StartTime:     start,
+		EndTime:       end,
+		ExecutionTime: end.Sub(start).Seconds(),
 	}
 
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			// Extract standard Nagios exit codes: 
 			// 0: OK, 1: WARNING, 2: CRITICAL, 3: UNKNOWN
-			result.Status = exitError.Sys().(syscall.WaitStatus).ExitStatus()
+			status := exitError.Sys().(syscall.WaitStatus)
+			result.Status = status.ExitStatus()
+			if status.Signaled() {
+				// Killed plugins (timeout, OOM...) have no exit code
+				result.ExitSignal = int(status.Signal())
+				result.Status = 3 // UNKNOWN
+				if result.Output == "" {
+					result.Output = "Plugin terminated by signal: " + status.Signal().String()
+				}
+			}
 		} else {
 			// If execution itself failed (e.g. context timeout or binary not found)
 			result.Status = 3 // UNKNOWN
@@ -44,6 +61,9 @@ func executeTask(task models.CheckTask) models.CheckResult {
 		result.Status = 0 // OK
 	}
 
+	// Split short output, long output and performance data
+	perfdata.Split(&result)
+
 	// Trace final result for debugging
 	logger.Info("[RESULT] Task: %s | Status: %d | Output Snippet: %s", 
 		result.ID, result.Status, result.Output)
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -19,6 +20,19 @@ import (
 // Shared HTTP client with a 10s timeout to prevent hanging connections
 var httpClient = &http.Client{Timeout: 10 * time.Second}
 
+// pendingResult is a finished check waiting to be pushed to its scheduler
+type pendingResult struct {
+	res models.CheckResult
+	url string
+}
+
+// resultChan buffers finished checks until the next batch push
+var resultChan = make(chan pendingResult, 1000)
+
+// maxPendingResults caps the results kept per scheduler while it refuses them;
+// the oldest go first, their tasks being re-queued by the scheduler anyway
+const maxPendingResults = 10000
+
 func main() {
 	// Parse command-line flags
 	configPath := flag.String("c", "config.json", "Path to poller configuration")
@@ -61,25 +75,36 @@ func main() {
 	// 4. Concurrency control via semaphore (channel)
 	sem := make(chan struct{}, appConfig.MaxConcurrent)
 
+	// Results are buffered and pushed back to their scheduler in batches
+	go resultFlusher()
+
 	// Start the main polling loop in a goroutine
 	go func() {
 		for {
 			for _, schedulerURL := range appConfig.SchedulerURLs {
-				task, err := pullTaskFromURL(schedulerURL)
+				// Only request as many tasks as there are free execution slots
+				free := cap(sem) - len(sem)
+				if free <= 0 {
+					break
+				}
+
+				tasks, err := pullTasksFromURL(schedulerURL, free)
 				if err != nil {
 					// Silent skip if no tasks or scheduler is unreachable
 					continue 
 				}
 
-				// Acquire semaphore slot
-				sem <- struct{}{}
-				go func(t models.CheckTask, originURL string) {
-					defer func() { <-sem }()
-					
-					// Execute the command and report result back to the specific scheduler
-					result := executeTask(t)
-					pushResultToURL(result, originURL)
-				}(task, schedulerURL)
+				for _, task := range tasks {
+					// Acquire semaphore slot
+					sem <- struct{}{}
+					go func(t models.CheckTask, originURL string) {
+						defer func() { <-sem }()
+
+						// Execute the command and queue the result for its originating scheduler
+						result := executeTask(t)
+						resultChan <- pendingResult{res: result, url: originURL}
+					}(task, schedulerURL)
+				}
 			}
 
 			// Respect the configured polling interval
@@ -93,36 +118,113 @@ func main() {
 	os.Exit(0)
 }
 
-// pullTaskFromURL fetches a task from a Scheduler's pop-task endpoint
-func pullTaskFromURL(baseURL string) (models.CheckTask, error) {
-	url := fmt.Sprintf("%s/v1/pop-task", baseURL)
+// pullTasksFromURL fetches up to 'max' tasks from a Scheduler's pop-tasks endpoint
+func pullTasksFromURL(baseURL string, max int) ([]models.CheckTask, error) {
+	url := fmt.Sprintf("%s/v1/pop-tasks?max=%d&poller=%s", baseURL, max, neturl.QueryEscape(appConfig.PollerID))
 	resp, err := httpClient.Get(url)
 	if err != nil {
-		return models.CheckTask{}, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return models.CheckTask{}, fmt.Errorf("no tasks available")
+		return nil, fmt.Errorf("no tasks available")
+	}
+
+	var tasks []models.CheckTask
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	return tasks, err
+}
+
+// resultBuffer holds the finished results per scheduler until they are accepted
+type resultBuffer struct {
+	batches map[string][]models.CheckResult
+	failing map[string]bool // Schedulers that refused results at the last push
+}
+
+func newResultBuffer() *resultBuffer {
+	return &resultBuffer{batches: make(map[string][]models.CheckResult), failing: make(map[string]bool)}
+}
+
+// add queues a result and reports whether its batch is full and due for a push.
+// A scheduler that refused results at the last push is only retried on the ticker.
+func (b *resultBuffer) add(p pendingResult) bool {
+	b.batches[p.url] = append(b.batches[p.url], p.res)
+	return len(b.batches[p.url]) >= appConfig.MaxConcurrent && !b.failing[p.url]
+}
+
+// flush pushes the batch of a scheduler and keeps the results it refused for a retry
+func (b *resultBuffer) flush(url string) {
+	pending := pushResultsToURL(b.batches[url], url)
+	if len(pending) == 0 {
+		delete(b.batches, url)
+		delete(b.failing, url)
+		return
+	}
+	b.failing[url] = true
+	if n := len(pending) - maxPendingResults; n > 0 {
+		logger.Info("[WARNING] Dropping %d results pending for %s", n, url)
+		pending = pending[n:]
 	}
+	b.batches[url] = pending
+}
 
-	var task models.CheckTask
-	err = json.NewDecoder(resp.Body).Decode(&task)
-	return task, err
+// resultFlusher groups finished results per scheduler and pushes them
+// every poll interval, or as soon as a batch is full. Results the scheduler
+// did not accept are kept and retried at the next interval.
+func resultFlusher() {
+	ticker := time.NewTicker(time.Duration(appConfig.IntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	buf := newResultBuffer()
+	for {
+		select {
+		case p := <-resultChan:
+			if buf.add(p) {
+				buf.flush(p.url)
+			}
+		case <-ticker.C:
+			for url := range buf.batches {
+				buf.flush(url)
+			}
+		}
+	}
 }
 
-// pushResultToURL sends the command execution outcome back to the Scheduler
-func pushResultToURL(res models.CheckResult, baseURL string) {
-	url := fmt.Sprintf("%s/v1/push-result", baseURL)
-	payload, _ := json.Marshal(res)
+// pushResultsToURL sends a batch of command execution outcomes back to the Scheduler.
+// It returns the results the Scheduler did not accept: the whole batch when it
+// could not be delivered, or those the Scheduler listed as rejected.
+func pushResultsToURL(results []models.CheckResult, baseURL string) []models.CheckResult {
+	url := fmt.Sprintf("%s/v1/push-results", baseURL)
+	payload, _ := json.Marshal(results)
 	
 	resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(payload))
-	if err == nil {
-		resp.Body.Close()
-		// Debug level log for successful network operation
-		logger.Info("[NETWORK] Successfully pushed result for task %s to %s", res.ID, baseURL)
-	} else {
+	if err != nil {
 		// Log failures even in non-debug mode to identify network issues
-		logger.Info("[ERROR] Failed to push result for task %s to %s: %v", res.ID, baseURL, err)
+		logger.Info("[ERROR] Failed to push %d results to %s: %v", len(results), baseURL, err)
+		return results
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		// Nothing was queued: the whole batch is kept
+		logger.Info("[ERROR] %s refused %d results (status %d), keeping them for a retry", baseURL, len(results), resp.StatusCode)
+		return results
+	}
+
+	// The results missing from a full result queue are pushed again, not the whole batch
+	var reply models.PushResultsReply
+	json.NewDecoder(resp.Body).Decode(&reply)
+	var pending []models.CheckResult
+	for _, i := range reply.Rejected {
+		if i >= 0 && i < len(results) {
+			pending = append(pending, results[i])
+		}
+	}
+	if len(pending) > 0 {
+		logger.Info("[ERROR] %s rejected %d of %d results (result queue full), keeping them for a retry", baseURL, len(pending), len(results))
+		return pending
 	}
+	// Debug level log for successful network operation
+	logger.Info("[NETWORK] Successfully pushed %d results to %s", len(results), baseURL)
+	return nil
 }
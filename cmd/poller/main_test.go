@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+// resultIDs lists the IDs of results, comma separated
+func resultIDs(results []models.CheckResult) string {
+	ids := make([]string, len(results))
+	for i, res := range results {
+		ids[i] = res.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestPullTasksFromURL(t *testing.T) {
+	appConfig.PollerID = "poller 1"
+	var query string
+	status := http.StatusOK
+	scheduler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.URL.Path != "/v1/pop-tasks" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			json.NewEncoder(w).Encode([]models.CheckTask{{ID: "web1/http", Command: "check_http", Seq: 7}})
+		}
+	}))
+	defer scheduler.Close()
+
+	tasks, err := pullTasksFromURL(scheduler.URL, 3)
+	if err != nil || len(tasks) != 1 || tasks[0].ID != "web1/http" || tasks[0].Seq != 7 {
+		t.Errorf("got %+v, %v, want the task web1/http", tasks, err)
+	}
+	if query != "max=3&poller=poller+1" {
+		t.Errorf("got query %q, want the free slots and the poller ID", query)
+	}
+
+	status = http.StatusNoContent
+	if tasks, err := pullTasksFromURL(scheduler.URL, 3); err == nil {
+		t.Errorf("nothing due: got %+v, want an error", tasks)
+	}
+}
+
+func TestResultBufferRetry(t *testing.T) {
+	appConfig.MaxConcurrent = 3
+	var pushed string
+	var reply func(batch []models.CheckResult, w http.ResponseWriter)
+	scheduler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []models.CheckResult
+		json.NewDecoder(r.Body).Decode(&batch)
+		pushed = resultIDs(batch)
+		reply(batch, w)
+	}))
+	defer scheduler.Close()
+	accept := func(rejected ...int) func([]models.CheckResult, http.ResponseWriter) {
+		return func(batch []models.CheckResult, w http.ResponseWriter) {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(models.PushResultsReply{Accepted: len(batch) - len(rejected), Rejected: rejected})
+		}
+	}
+	refuse := func(_ []models.CheckResult, w http.ResponseWriter) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+
+	buf := newResultBuffer()
+	add := func(ids ...string) bool {
+		full := false
+		for _, id := range ids {
+			full = buf.add(pendingResult{res: models.CheckResult{ID: id}, url: scheduler.URL})
+		}
+		return full
+	}
+	tests := []struct {
+		name    string
+		add     string // Results finished before the push
+		full    bool   // The last result fills the batch
+		reply   func([]models.CheckResult, http.ResponseWriter)
+		pushed  string
+		pending string
+	}{
+		{name: "partly rejected", add: "a,b,c", full: true, reply: accept(0, 2), pushed: "a,b,c", pending: "a,c"},
+		{name: "only the rejected ones pushed again", add: "d", reply: accept(), pushed: "a,c,d", pending: ""},
+		{name: "refused batch kept whole", add: "e,f,g", full: true, reply: refuse, pushed: "e,f,g", pending: "e,f,g"},
+		{name: "failing scheduler waits for the ticker", add: "h", reply: accept(1), pushed: "e,f,g,h", pending: "f"},
+		{name: "out of range positions ignored", add: "i", reply: accept(5, -1), pushed: "f,i", pending: ""},
+	}
+	for _, tt := range tests {
+		if full := add(strings.Split(tt.add, ",")...); full != tt.full {
+			t.Errorf("%s: batch full = %v, want %v", tt.name, full, tt.full)
+		}
+		reply = tt.reply
+		buf.flush(scheduler.URL)
+		if pushed != tt.pushed {
+			t.Errorf("%s: pushed %q, want %q", tt.name, pushed, tt.pushed)
+		}
+		if got := resultIDs(buf.batches[scheduler.URL]); got != tt.pending {
+			t.Errorf("%s: %q pending, want %q", tt.name, got, tt.pending)
+		}
+		if buf.failing[scheduler.URL] != (tt.pending != "") {
+			t.Errorf("%s: failing = %v with %q pending", tt.name, buf.failing[scheduler.URL], tt.pending)
+		}
+	}
+
+	// An unreachable scheduler keeps the results too
+	scheduler.Close()
+	add("j")
+	buf.flush(scheduler.URL)
+	if got := resultIDs(buf.batches[scheduler.URL]); got != "j" {
+		t.Errorf("unreachable scheduler: %q pending, want %q", got, "j")
+	}
+}
+
+func TestResultBufferCap(t *testing.T) {
+	appConfig.MaxConcurrent = 10
+	scheduler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer scheduler.Close()
+
+	buf := newResultBuffer()
+	for i := 0; i < maxPendingResults+5; i++ {
+		buf.add(pendingResult{res: models.CheckResult{ID: fmt.Sprint(i)}, url: scheduler.URL})
+	}
+	buf.flush(scheduler.URL)
+	pending := buf.batches[scheduler.URL]
+	if len(pending) != maxPendingResults {
+		t.Fatalf("%d results pending, want %d", len(pending), maxPendingResults)
+	}
+	// The oldest results are dropped first
+	if pending[0].ID != "5" || pending[len(pending)-1].ID != fmt.Sprint(maxPendingResults+4) {
+		t.Errorf("kept results %s to %s, want the newest", pending[0].ID, pending[len(pending)-1].ID)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net"
 	"net/smtp"
+	"strconv"
+	"strings"
 	"time"
 
 	"shinsakuto/pkg/models"
@@ -28,8 +30,8 @@ func processNotification(req models.NotificationRequest) {
 		}
 	}
 
-	// 2. Acknowledgment Check: Mute if a technician has already claimed the issue
-	if acknowledgments[req.EntityID] {
+	// 2. Acknowledgment Check: Mute problems a technician has already claimed
+	if req.Type == models.NotificationProblem && acknowledgments[req.EntityID] {
 		logger.Info("[ENGINE] Notification skipped: %s is already acknowledged", req.EntityID)
 		mu.RUnlock()
 		return
@@ -37,8 +39,8 @@ func processNotification(req models.NotificationRequest) {
 	mu.RUnlock()
 
 	// 3. Alert Auditing: Write to the dedicated alert history file (AlertsLog)
-	alertLogger.Printf("[%s] %s | State: %d | Output: %s", 
-		req.Type, req.EntityID, req.State, req.Output)
+	alertLogger.Printf("[%s] %s | State: %s (was %s) | Notification: #%d | Output: %s", 
+		req.Type, req.EntityID, req.StateName, req.PreviousStateName, req.NotificationNumber, req.Output)
 	
 	// 4. Trigger Reactions (e.g., Email)
 	go sendEmail(req)
@@ -50,16 +52,21 @@ func sendEmail(req models.NotificationRequest) {
 		return
 	}
 	
-	addr := fmt.Sprintf("%s:%d", appConfig.SMTP.Host, appConfig.SMTP.Port)
+	addr := net.JoinHostPort(appConfig.SMTP.Host, strconv.Itoa(appConfig.SMTP.Port))
+	to := recipients(req)
 	subject := fmt.Sprintf("Subject: [%s] %s\n", req.Type, req.EntityID)
 	
 	// Message Construction
-	body := fmt.Sprintf("To: %s\n%s\n\n--- Shinsakuto Alert ---\nEntity: %s\nType: %s\nState: %d\nOutput: %s\nTime: %s", 
-		appConfig.SMTP.To, subject, req.EntityID, req.Type, req.State, req.Output, time.Now().Format(time.RFC822))
+	body := fmt.Sprintf("To: %s\n%s\n\n--- Shinsakuto Alert ---\nHost: %s\nService: %s\nType: %s\nState: %s (previous: %s)\nNotification: #%d\nOutput: %s\nTime: %s", 
+		strings.Join(to, ", "), subject, req.HostName, req.ServiceID, req.Type, req.StateName, req.PreviousStateName,
+		req.NotificationNumber, req.Output, time.Now().Format(time.RFC822))
+	if req.Author != "" {
+		body += fmt.Sprintf("\nAuthor: %s\nComment: %s", req.Author, req.Comment)
+	}
 
 	auth := smtp.PlainAuth("", appConfig.SMTP.Username, appConfig.SMTP.Password, appConfig.SMTP.Host)
 
-	logger.Info("[SMTP] Sending alert for %s to %s", req.EntityID, appConfig.SMTP.To)
+	logger.Info("[SMTP] Sending alert for %s to %s", req.EntityID, strings.Join(to, ", "))
 
 	// Support for SMTPS (Port 465) or standard SMTP/STARTTLS
 	if appConfig.SMTP.Port == 465 {
@@ -87,16 +94,33 @@ func sendEmail(req models.NotificationRequest) {
 		}
 		
 		client.Mail(appConfig.SMTP.From)
-		client.Rcpt(appConfig.SMTP.To)
+		for _, rcpt := range to {
+			client.Rcpt(rcpt)
+		}
 		
 		w, _ := client.Data()
 		w.Write([]byte(body))
 		w.Close()
 	} else {
 		// Standard SMTP delivery
-		err := smtp.SendMail(addr, auth, appConfig.SMTP.From, []string{appConfig.SMTP.To}, []byte(body))
+		err := smtp.SendMail(addr, auth, appConfig.SMTP.From, to, []byte(body))
 		if err != nil {
 			logger.Info("[ERROR] SMTP SendMail failed: %v", err)
 		}
 	}
 }
+
+// recipients returns the e-mail addresses routed by the Scheduler (escalations
+// applied), or the configured default recipient
+func recipients(req models.NotificationRequest) []string {
+	var to []string
+	for _, c := range req.Contacts {
+		if c.Email != "" {
+			to = append(to, c.Email)
+		}
+	}
+	if len(to) == 0 {
+		to = []string{appConfig.SMTP.To}
+	}
+	return to
+}
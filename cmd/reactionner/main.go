@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"sync"
 	"time"
 
@@ -72,20 +73,46 @@ func notifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// If it's a RECOVERY and HA is enabled, synchronize state across the cluster
-	if req.Type == "RECOVERY" && appConfig.HAEnabled && isLeader() {
-		payload, _ := json.Marshal(RaftPayload{Action: "RECOVERY", ID: req.EntityID})
-		raftNode.Apply(payload, 5*time.Second)
-		logger.Info("[HA] Replicated recovery state for entity: %s", req.EntityID)
+	// A RECOVERY clears the acknowledgment of the problem, cluster-wide in HA mode
+	if req.Type == models.NotificationRecovery {
+		if appConfig.HAEnabled {
+			if isLeader() {
+				payload, _ := json.Marshal(RaftPayload{Action: "RECOVERY", ID: req.EntityID})
+				raftNode.Apply(payload, 5*time.Second).Error()
+				logger.Info("[HA] Replicated recovery state for entity: %s", req.EntityID)
+			}
+		} else {
+			mu.Lock()
+			delete(acknowledgments, req.EntityID)
+			mu.Unlock()
+		}
 	}
 	
 	processNotification(req)
 	w.WriteHeader(http.StatusOK)
 }
 
-// ackHandler manages manual acknowledgments to mute alerts
+// ackHandler manages manual acknowledgments to mute alerts. GET lists the
+// acknowledged entities, which the Schedulers mirror in their status.
 func ackHandler(w http.ResponseWriter, r *http.Request) {
-	var body struct{ EntityID string `json:"entity_id"` }
+	if r.Method == http.MethodGet {
+		mu.RLock()
+		ids := make([]string, 0, len(acknowledgments))
+		for id := range acknowledgments {
+			ids = append(ids, id)
+		}
+		mu.RUnlock()
+		sort.Strings(ids)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ids)
+		return
+	}
+
+	var body struct {
+		EntityID string `json:"entity_id"`
+		Author   string `json:"author"`
+		Comment  string `json:"comment"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
@@ -105,6 +132,16 @@ func ackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Info("[ACK] Entity %s acknowledged by user", body.EntityID)
+
+	// Contacts are told that someone is working on the problem
+	req := models.NotificationRequest{
+		EntityID: body.EntityID, Type: models.NotificationAcknowledgement, HostName: body.EntityID,
+		Output: "Problem acknowledged", Author: body.Author, Comment: body.Comment, Timestamp: time.Now(),
+	}
+	if host, svc, ok := models.ParseServiceKey(body.EntityID); ok {
+		req.HostName, req.ServiceID = host, svc
+	}
+	processNotification(req)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// Acknowledgements are owned by the Reactionner: its /v1/ack mutes the PROBLEM
+// notifications of an object until the next RECOVERY. The scheduler reads them
+// every ack_sync_interval seconds and mirrors them on its hosts and services.
+
+// ackEndEvent is logged when an acknowledgement is cleared, NotificationAcknowledgement when it is set
+const ackEndEvent = "ACKNOWLEDGEMENTEND"
+
+// startAckSync periodically mirrors the acknowledgements of the Reactionner
+func startAckSync() {
+	ticker := time.NewTicker(time.Duration(appConfig.AckSyncInterval) * time.Second)
+	for range ticker.C {
+		syncAcknowledgements()
+	}
+}
+
+// syncAcknowledgements reads the acknowledged objects from the Reactionner and
+// updates the Acknowledged flags. A spare in standby receives them from its primary.
+func syncAcknowledgements() {
+	mu.RLock()
+	active := serving()
+	mu.RUnlock()
+	if !active {
+		return
+	}
+	acked, err := fetchAcknowledgements()
+	if err != nil {
+		logger.Info("[ERROR] Acknowledgements not read from the Reactionner: %v", err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if serving() {
+		applyAcknowledgements(acked)
+	}
+}
+
+// fetchAcknowledgements returns the host IDs and host_name/service_id keys
+// acknowledged on the Reactionner
+func fetchAcknowledgements() (map[string]bool, error) {
+	url := strings.TrimSuffix(appConfig.ReactionnerURL, "/v1/notify") + "/v1/ack"
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var ids []string
+	if err := json.NewDecoder(resp.Body).Decode(&ids); err != nil {
+		return nil, err
+	}
+	acked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+	return acked, nil
+}
+
+// applyAcknowledgements sets the Acknowledged flag of every object, must be called under mu
+func applyAcknowledgements(acked map[string]bool) {
+	for id, h := range hosts {
+		if acked[id] != h.Acknowledged {
+			h.Acknowledged = acked[id]
+			recordAcknowledgement("HOST", id, h.Acknowledged)
+			markHost(id)
+		}
+	}
+	for key, s := range services {
+		if acked[key] != s.Acknowledged {
+			s.Acknowledged = acked[key]
+			recordAcknowledgement("SERVICE", key, s.Acknowledged)
+			markService(key)
+		}
+	}
+}
+
+// recordAcknowledgement logs and streams an acknowledgement set or cleared on the Reactionner
+func recordAcknowledgement(entityType, id string, acked bool) {
+	event, detail := models.NotificationAcknowledgement, "Problem acknowledged"
+	if !acked {
+		event, detail = ackEndEvent, "Acknowledgement cleared"
+	}
+	logEvent(entityType, id, event, detail)
+	publishAcknowledgement(entityType, id, acked)
+}
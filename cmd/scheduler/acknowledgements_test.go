@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+// ackedObjects returns the sorted host IDs and service keys flagged as acknowledged
+func ackedObjects() string {
+	var ids []string
+	for id, h := range hosts {
+		if h.Acknowledged {
+			ids = append(ids, id)
+		}
+	}
+	for key, s := range services {
+		if s.Acknowledged {
+			ids = append(ids, key)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestSyncAcknowledgements(t *testing.T) {
+	eventRing(t, 10)
+	pairState(t)
+	pairInventory("web1", "web2")
+	var acked []string
+	status := http.StatusOK
+	reactionner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/ack" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(acked)
+	}))
+	defer reactionner.Close()
+	appConfig.ReactionnerURL = reactionner.URL + "/v1/notify"
+
+	tests := []struct {
+		name    string
+		acked   []string
+		status  int
+		standby bool
+		want    string
+		changed int // Objects marked for persistence and replication
+	}{
+		{name: "acknowledged on the reactionner", acked: []string{"web1", "web2/http", "gone"}, want: "web1,web2/http", changed: 2},
+		{name: "unchanged", acked: []string{"web1", "web2/http"}, want: "web1,web2/http"},
+		{name: "cleared on recovery", acked: []string{"web1"}, want: "web1", changed: 1},
+		{name: "reactionner failing", status: http.StatusInternalServerError, want: "web1"},
+		{name: "spare in standby", standby: true, want: "web1"},
+		{name: "all cleared", want: "", changed: 1},
+	}
+	for _, tt := range tests {
+		acked, status, standby = tt.acked, http.StatusOK, tt.standby
+		if tt.status != 0 {
+			status = tt.status
+		}
+		dirtyHosts, dirtyServices = make(map[string]bool), make(map[string]bool)
+		syncAcknowledgements()
+		if got := ackedObjects(); got != tt.want {
+			t.Errorf("%s: got %q acknowledged, want %q", tt.name, got, tt.want)
+		}
+		if got := len(dirtyHosts) + len(dirtyServices); got != tt.changed {
+			t.Errorf("%s: got %d objects marked, want %d", tt.name, got, tt.changed)
+		}
+	}
+}
+
+func TestAcknowledgementKeptOverReload(t *testing.T) {
+	// The configuration of the Arbiter carries no acknowledgement
+	h, s := &models.Host{ID: "web1"}, &models.Service{ID: "http", HostName: "web1"}
+	copyHostRuntime(h, &models.Host{ID: "web1", Acknowledged: true})
+	copyServiceRuntime(s, &models.Service{ID: "http", HostName: "web1", Acknowledged: true})
+	if !h.Acknowledged || !s.Acknowledged {
+		t.Errorf("acknowledgement lost over the reload: host %v, service %v", h.Acknowledged, s.Acknowledged)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/macros"
+	"shinsakuto/pkg/models"
+	"shinsakuto/pkg/timeperiod"
+)
+
+// Command definitions, $USERn$ resources and time periods received from the Arbiter, guarded by mu
+var (
+	commands    = make(map[string]string)
+	resources   = make(map[string]string)
+	timePeriods []models.TimePeriod
+	periods     *timeperiod.Set
+)
+
+// loadCommands indexes the command definitions of a sync-all payload
+func loadCommands(cfg models.GlobalConfig) {
+	commands = make(map[string]string, len(cfg.Commands))
+	for _, c := range cfg.Commands {
+		commands[c.ID] = c.CommandLine
+	}
+	resources = make(map[string]string, len(cfg.Resources))
+	for k, v := range cfg.Resources {
+		resources[k] = v
+	}
+	loadTimePeriods(cfg.TimePeriods)
+}
+
+// loadTimePeriods compiles the time period definitions used for check and notification periods
+func loadTimePeriods(tps []models.TimePeriod) {
+	var errs []error
+	timePeriods = tps
+	periods, errs = timeperiod.NewSet(tps)
+	for _, err := range errs {
+		logger.Info("[WARNING] %v", err)
+	}
+}
+
+// alignToPeriod moves a check time to the next moment inside a check_period.
+// Without any valid time in the coming year, the check is retried the next day.
+func alignToPeriod(period string, t time.Time) time.Time {
+	next, ok := periods.NextValid(period, t)
+	if !ok {
+		return t.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// notificationAllowed reports whether a notification_period allows alerts right now
+func notificationAllowed(period string) bool {
+	return periods.In(period, time.Now())
+}
+
+// hostMacros returns the macros describing a host
+func hostMacros(h *models.Host) map[string]string {
+	addr := h.Address
+	if addr == "" {
+		addr = h.ID
+	}
+	return map[string]string{
+		"HOSTNAME":        h.ID,
+		"HOSTADDRESS":     addr,
+		"ADDRESS":         addr,
+		"HOSTSTATE":       hostStateName(h.CurrentState),
+		"HOSTSTATEID":     strconv.Itoa(h.CurrentState),
+		"HOSTSTATETYPE":   h.StateType,
+		"HOSTATTEMPT":     strconv.Itoa(h.Attempts),
+		"MAXHOSTATTEMPTS": strconv.Itoa(maxAttempts(h.MaxAttempts)),
+		"HOSTOUTPUT":      macros.StripOutput(h.Output, appConfig.IllegalMacroOutputChars),
+	}
+}
+
+// serviceMacros returns the macros describing a service and its host
+func serviceMacros(s *models.Service) map[string]string {
+	vars := map[string]string{"HOSTNAME": s.HostName, "HOSTADDRESS": s.HostName, "ADDRESS": s.HostName}
+	if h, ok := hosts[s.HostName]; ok {
+		vars = hostMacros(h)
+	}
+	vars["SERVICEDESC"] = s.ID
+	vars["SERVICESTATE"] = serviceStateName(s.CurrentState)
+	vars["SERVICESTATEID"] = strconv.Itoa(s.CurrentState)
+	vars["SERVICESTATETYPE"] = s.StateType
+	vars["SERVICEATTEMPT"] = strconv.Itoa(s.Attempts)
+	vars["MAXSERVICEATTEMPTS"] = strconv.Itoa(maxAttempts(s.MaxAttempts))
+	vars["SERVICEOUTPUT"] = macros.StripOutput(s.Output, appConfig.IllegalMacroOutputChars)
+	return vars
+}
+
+// resolveCommand turns a "command!arg1!arg2" reference into an executable command line.
+// References that are not defined commands are treated as literal command lines.
+func resolveCommand(ref string, vars map[string]string) string {
+	for k, v := range resources {
+		vars[k] = v
+	}
+
+	name, args := macros.SplitCommand(ref)
+	line, ok := commands[name]
+	if !ok {
+		return macros.Expand(ref, vars)
+	}
+
+	// Arguments may themselves reference object macros
+	for i, a := range args {
+		vars[fmt.Sprintf("ARG%d", i+1)] = macros.Expand(a, vars)
+	}
+	return macros.Expand(line, vars)
+}
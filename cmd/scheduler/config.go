@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/macros"
 )
 
 // SchedConfig defines the runtime parameters for the Scheduler
@@ -15,10 +18,46 @@ type SchedConfig struct {
 	ReactionnerURL string   `json:"reactionner_url"`
 	BrokerEnabled  bool     `json:"broker_enabled"`
 	BrokerURLs     []string `json:"broker_urls"`
-	StateFile      string   `json:"state_file"`
+	StateFile      string   `json:"state_file"` // Legacy JSON state, imported once
+	StateDB        string   `json:"state_db"`
 	LogFile       string   `json:"log_file"`
 	HistoryLog     string   `json:"history_log"`
 	Debug          bool     `json:"debug"`
+	// Scheduling defaults
+	DefaultCheckInterval int    `json:"default_check_interval"` // Used when an object has no interval
+	IntervalUnit         string `json:"interval_unit"`          // "seconds" (default) or "minutes"
+	// Notification policy
+	NotifyUnreachable bool `json:"notify_unreachable"` // Send notifications for UNREACHABLE hosts
+	// Flap detection
+	FlapDetection     bool    `json:"flap_detection"`      // Enable flap detection globally
+	FlapHistorySize   int     `json:"flap_history_size"`   // Number of states kept per object
+	LowFlapThreshold  float64 `json:"low_flap_threshold"`  // Percent change below which flapping stops
+	HighFlapThreshold float64 `json:"high_flap_threshold"` // Percent change above which flapping starts
+	// Freshness checking
+	FreshnessCheckInterval int `json:"freshness_check_interval"` // Seconds between freshness scans
+	// Dispatch tracking
+	TaskTimeout int `json:"task_timeout"` // Seconds before an unanswered task is orphaned
+	// State persistence
+	StateSyncInterval     int `json:"state_sync_interval"`     // Seconds between flushes of modified objects
+	StateSnapshotInterval int `json:"state_snapshot_interval"` // Seconds between snapshots of the database
+	// Hot-standby pair
+	SpareURL        string `json:"spare_url"`        // Primary: spare receiving the state
+	Spare           bool   `json:"spare"`            // Spare: stay in standby while the primary heartbeats
+	FailoverTimeout int    `json:"failover_timeout"` // Seconds without heartbeat before the spare takes over
+	// Livestatus listener, disabled when neither socket nor address is set
+	LivestatusSocket  string `json:"livestatus_socket"`   // Unix socket path
+	LivestatusAddress string `json:"livestatus_address"`  // TCP address, e.g. "127.0.0.1:6557"
+	LivestatusLogSize int    `json:"livestatus_log_size"` // History entries kept for the log table
+	// Event stream
+	EventBufferSize int `json:"event_buffer_size"` // Events kept for clients resuming with Last-Event-ID
+	// Downtimes
+	DowntimeCheckInterval int `json:"downtime_check_interval"` // Seconds between downtime evaluations
+	// Acknowledgements
+	AckSyncInterval int `json:"ack_sync_interval"` // Seconds between reads of the Reactionner acknowledgements
+	// Event handlers
+	GlobalHostEventHandler    string `json:"global_host_event_handler"`    // Runs on every host state change
+	GlobalServiceEventHandler string `json:"global_service_event_handler"` // Runs on every service state change
+	IllegalMacroOutputChars   string `json:"illegal_macro_output_chars"`   // Removed from $HOSTOUTPUT$/$SERVICEOUTPUT$
 }
 
 // loadConfig reads and parses the JSON configuration file
@@ -27,7 +66,78 @@ func loadConfig(path string) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &appConfig)
+	if err := json.Unmarshal(data, &appConfig); err != nil {
+		return err
+	}
+
+	// Interval defaults: one minute between checks, expressed in the configured unit
+	if appConfig.IntervalUnit != "minutes" {
+		appConfig.IntervalUnit = "seconds"
+	}
+	if appConfig.DefaultCheckInterval <= 0 {
+		appConfig.DefaultCheckInterval = 1
+		if appConfig.IntervalUnit == "seconds" {
+			appConfig.DefaultCheckInterval = 60
+		}
+	}
+
+	// Flap detection defaults match Nagios: 21 states, 20%/30% thresholds
+	if appConfig.FlapHistorySize < 2 {
+		appConfig.FlapHistorySize = 21
+	}
+	if appConfig.LowFlapThreshold <= 0 {
+		appConfig.LowFlapThreshold = 20
+	}
+	if appConfig.HighFlapThreshold <= 0 {
+		appConfig.HighFlapThreshold = 30
+	}
+	if appConfig.FreshnessCheckInterval <= 0 {
+		appConfig.FreshnessCheckInterval = 15
+	}
+	if appConfig.TaskTimeout <= 0 {
+		appConfig.TaskTimeout = 60
+	}
+	if appConfig.DowntimeCheckInterval <= 0 {
+		appConfig.DowntimeCheckInterval = 10
+	}
+	if appConfig.AckSyncInterval <= 0 {
+		appConfig.AckSyncInterval = 10
+	}
+
+	// The state database lives next to the legacy state file by default
+	if appConfig.StateDB == "" && appConfig.StateFile != "" {
+		appConfig.StateDB = strings.TrimSuffix(appConfig.StateFile, ".json") + ".db"
+	} else if appConfig.StateDB == "" {
+		appConfig.StateDB = "states.db"
+	}
+	if appConfig.StateSyncInterval <= 0 {
+		appConfig.StateSyncInterval = 1
+	}
+	if appConfig.StateSnapshotInterval <= 0 {
+		appConfig.StateSnapshotInterval = 300
+	}
+	if appConfig.FailoverTimeout <= 0 {
+		appConfig.FailoverTimeout = 10
+	}
+	if appConfig.LivestatusLogSize <= 0 {
+		appConfig.LivestatusLogSize = 10000
+	}
+	if appConfig.EventBufferSize <= 0 {
+		appConfig.EventBufferSize = 1000
+	}
+	// Plugin output can be submitted by anyone and ends up in shell command lines
+	if appConfig.IllegalMacroOutputChars == "" {
+		appConfig.IllegalMacroOutputChars = macros.DefaultIllegalOutputChars
+	}
+	return nil
+}
+
+// intervalDuration converts an object interval into a duration using the configured unit
+func intervalDuration(n int) time.Duration {
+	if appConfig.IntervalUnit == "minutes" {
+		return time.Duration(n) * time.Minute
+	}
+	return time.Duration(n) * time.Second
 }
 
 // initLoggers initializes technical and history logs
@@ -45,8 +155,17 @@ func initLoggers() {
 }
 
 // logStateChange writes state transitions to the history log
-func logStateChange(entityType, id, stateStr, output string) {
+func logStateChange(entityType, id, stateStr, stateType string, attempt, maxAttempt int, output string) {
+	if statusLogger != nil {
+		statusLogger.Printf("%-7s | %-20s | %-8s | %-4s %d/%d | %s", entityType, id, stateStr, stateType, attempt, maxAttempt, output)
+	}
+	recordAlert(entityType, id, stateStr, stateType, attempt, output)
+}
+
+// logEvent writes non-state events (flapping, downtimes...) to the history log
+func logEvent(entityType, id, event, detail string) {
 	if statusLogger != nil {
-		statusLogger.Printf("%-7s | %-20s | %-8s | %s", entityType, id, stateStr, output)
+		statusLogger.Printf("%-7s | %-20s | %-8s | %s", entityType, id, event, detail)
 	}
+	recordEvent(entityType, id, event, detail)
 }
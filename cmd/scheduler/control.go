@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// forcedChecks are checks scheduled with "force", run once whatever the active
+// checks setting, check_period and dependencies. Guarded by mu.
+var forcedChecks = make(map[string]time.Time)
+
+// controlTarget selects the objects of a control request: a host, a service,
+// or the members of a host group or service group
+type controlTarget struct {
+	HostName        string `json:"host_name,omitempty"`
+	ServiceID       string `json:"service_id,omitempty"`
+	HostGroup       string `json:"hostgroup,omitempty"`
+	ServiceGroup    string `json:"servicegroup,omitempty"`
+	IncludeServices bool   `json:"include_services,omitempty"` // A host or host group also selects its services
+}
+
+// resolve returns the host IDs and service keys selected by a target, must be called under mu
+func (t controlTarget) resolve() ([]string, []string, error) {
+	set := 0
+	for _, v := range []string{t.HostName, t.HostGroup, t.ServiceGroup} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 || (t.ServiceID != "" && t.HostName == "") {
+		return nil, nil, fmt.Errorf("one of host_name (with an optional service_id), hostgroup or servicegroup is required")
+	}
+
+	var hostIDs, serviceKeys []string
+	switch {
+	case t.ServiceID != "":
+		if key := models.ServiceKey(t.HostName, t.ServiceID); services[key] != nil {
+			serviceKeys = append(serviceKeys, key)
+		}
+	case t.HostName != "":
+		if hosts[t.HostName] != nil {
+			hostIDs = append(hostIDs, t.HostName)
+		}
+	case t.HostGroup != "":
+		for id, h := range hosts {
+			if contains(h.HostGroups, t.HostGroup) {
+				hostIDs = append(hostIDs, id)
+			}
+		}
+	default:
+		for key, s := range services {
+			if contains(s.ServiceGroups, t.ServiceGroup) {
+				serviceKeys = append(serviceKeys, key)
+			}
+		}
+	}
+	if t.IncludeServices && len(hostIDs) > 0 {
+		selected := make(map[string]bool, len(hostIDs))
+		for _, id := range hostIDs {
+			selected[id] = true
+		}
+		for key, s := range services {
+			if selected[s.HostName] {
+				serviceKeys = append(serviceKeys, key)
+			}
+		}
+	}
+	if len(hostIDs) == 0 && len(serviceKeys) == 0 {
+		return nil, nil, errNoTarget
+	}
+	sort.Strings(hostIDs)
+	sort.Strings(serviceKeys)
+	return hostIDs, serviceKeys, nil
+}
+
+// errNoTarget reports a target matching no object of the shard
+var errNoTarget = fmt.Errorf("no matching host or service")
+
+// decodeControl reads the JSON body of a control request
+func decodeControl(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, "Bad JSON", 400)
+		return false
+	}
+	return true
+}
+
+// targetError answers a request whose target could not be resolved
+func targetError(w http.ResponseWriter, err error) {
+	if err == errNoTarget {
+		http.Error(w, "Unknown host, service or group", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// scheduleCheckHandler schedules an immediate or timed check of the target objects.
+// Without "force", objects whose active checks are disabled are skipped and the
+// check honours check_period and execution dependencies like any other. Objects
+// without check_command nor freshness command have nothing to run and are skipped.
+// As in Nagios, a check already queued earlier is kept: a later time never delays it.
+func scheduleCheckHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		controlTarget
+		Time  *time.Time `json:"time,omitempty"` // Now when omitted
+		Force bool       `json:"force,omitempty"`
+	}
+	if !decodeControl(w, r, &body) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !serving() {
+		http.Error(w, "Scheduler is in standby", http.StatusServiceUnavailable)
+		return
+	}
+	hostIDs, serviceKeys, err := body.resolve()
+	if err != nil {
+		targetError(w, err)
+		return
+	}
+
+	at := time.Now()
+	if body.Time != nil {
+		at = *body.Time
+	}
+	scheduled, skipped := []string{}, []string{}
+	schedule := func(key, ref string, active bool, nextCheck *time.Time) {
+		if ref == "" || (!active && !body.Force) {
+			skipped = append(skipped, key)
+			return
+		}
+		due, queued := checks.due(key)
+		if !queued || at.Before(due) {
+			due, *nextCheck = at, at
+			checks.schedule(key, at)
+		}
+		if body.Force {
+			forcedChecks[key] = due
+		}
+		scheduled = append(scheduled, key)
+	}
+	for _, id := range hostIDs {
+		h := hosts[id]
+		schedule("HOST:"+id, checkRef(h.CheckCommand, h.FreshnessCommand, false), hostActive(h), &h.NextCheck)
+		markHost(id)
+	}
+	for _, key := range serviceKeys {
+		s := services[key]
+		schedule(key, checkRef(s.CheckCommand, s.FreshnessCommand, false), serviceActive(s), &s.NextCheck)
+		markService(key)
+	}
+	logger.Info("Checks scheduled at %s (force: %v): %s", at.Format(time.RFC3339), body.Force, strings.Join(scheduled, ", "))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"time": at, "scheduled": scheduled, "skipped": skipped})
+}
+
+// overridesHandler lists (GET) or changes (POST) the runtime settings of objects.
+// A POST sets the given settings on the target objects; "reset" first drops the
+// previous overrides so the configuration applies again.
+//
+//	{"hostgroup": "web", "include_services": true, "notifications_enabled": false}
+func overridesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		mu.RLock()
+		defer mu.RUnlock()
+		hostOverrides := make(map[string]models.Overrides)
+		for id, h := range hosts {
+			if h.Overrides != (models.Overrides{}) {
+				hostOverrides[id] = h.Overrides
+			}
+		}
+		serviceOverrides := make(map[string]models.Overrides)
+		for key, s := range services {
+			if s.Overrides != (models.Overrides{}) {
+				serviceOverrides[key] = s.Overrides
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"hosts": hostOverrides, "services": serviceOverrides})
+		return
+	}
+
+	var body struct {
+		controlTarget
+		models.Overrides
+		Reset bool `json:"reset,omitempty"`
+	}
+	if !decodeControl(w, r, &body) {
+		return
+	}
+	if !body.Reset && body.Overrides == (models.Overrides{}) {
+		http.Error(w, "No setting to change", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !serving() {
+		http.Error(w, "Scheduler is in standby", http.StatusServiceUnavailable)
+		return
+	}
+	hostIDs, serviceKeys, err := body.resolve()
+	if err != nil {
+		targetError(w, err)
+		return
+	}
+
+	detail := describeOverrides(body.Overrides, body.Reset)
+	result := make(map[string]models.Overrides)
+	for _, id := range hostIDs {
+		h := hosts[id]
+		h.Overrides = applyOverrides(h.Overrides, body.Overrides, body.Reset)
+		scheduleHost(h) // Active checks may have been enabled
+		markHost(id)
+		logEvent("HOST", id, "OVERRIDE", detail)
+		result["HOST:"+id] = h.Overrides
+	}
+	for _, key := range serviceKeys {
+		s := services[key]
+		s.Overrides = applyOverrides(s.Overrides, body.Overrides, body.Reset)
+		scheduleService(s)
+		markService(key)
+		logEvent("SERVICE", key, "OVERRIDE", detail)
+		result[key] = s.Overrides
+	}
+	logger.Info("Overrides changed on %d hosts and %d services: %s", len(hostIDs), len(serviceKeys), detail)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// applyOverrides sets the given settings over the current ones, optionally dropping them first
+func applyOverrides(current, changes models.Overrides, reset bool) models.Overrides {
+	if reset {
+		current = models.Overrides{}
+	}
+	for _, f := range overrideFields(&current, &changes) {
+		if *f.change != nil {
+			v := **f.change
+			*f.current = &v
+		}
+	}
+	return current
+}
+
+// describeOverrides formats a change for the history log
+func describeOverrides(changes models.Overrides, reset bool) string {
+	var parts []string
+	if reset {
+		parts = append(parts, "reset")
+	}
+	for _, f := range overrideFields(&models.Overrides{}, &changes) {
+		if *f.change != nil {
+			parts = append(parts, fmt.Sprintf("%s=%v", f.name, **f.change))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// overrideField pairs a setting of two Overrides values
+type overrideField struct {
+	name            string
+	current, change **bool
+}
+
+func overrideFields(current, changes *models.Overrides) []overrideField {
+	return []overrideField{
+		{"active_checks_enabled", &current.ActiveChecks, &changes.ActiveChecks},
+		{"passive_checks_enabled", &current.PassiveChecks, &changes.PassiveChecks},
+		{"notifications_enabled", &current.Notifications, &changes.Notifications},
+		{"event_handler_enabled", &current.EventHandlers, &changes.EventHandlers},
+		{"flap_detection_enabled", &current.FlapDetection, &changes.FlapDetection},
+	}
+}
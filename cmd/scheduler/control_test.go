@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+func TestControlTargetResolve(t *testing.T) {
+	queryInventory(t)
+	tests := []struct {
+		name      string
+		target    controlTarget
+		hosts     string
+		services  string
+		wantError string
+	}{
+		{name: "host", target: controlTarget{HostName: "web1"}, hosts: "web1"},
+		{name: "service", target: controlTarget{HostName: "web1", ServiceID: "http"}, services: "web1/http"},
+		{name: "host with its services", target: controlTarget{HostName: "web1", IncludeServices: true}, hosts: "web1", services: "web1/disk,web1/http"},
+		{name: "host group", target: controlTarget{HostGroup: "web"}, hosts: "web1,web2"},
+		{name: "host group with services", target: controlTarget{HostGroup: "web", IncludeServices: true}, hosts: "web1,web2", services: "web1/disk,web1/http,web2/http"},
+		{name: "service group", target: controlTarget{ServiceGroup: "storage"}, services: "db1/disk,db1/mysql"},
+		{name: "service group ignores include_services", target: controlTarget{ServiceGroup: "frontend", IncludeServices: true}, services: "web1/http,web2/http"},
+
+		{name: "no target", wantError: "one of host_name"},
+		{name: "two targets", target: controlTarget{HostName: "web1", HostGroup: "web"}, wantError: "one of host_name"},
+		{name: "service without host", target: controlTarget{ServiceID: "http", HostGroup: "web"}, wantError: "one of host_name"},
+		{name: "unknown host", target: controlTarget{HostName: "nope"}, wantError: errNoTarget.Error()},
+		{name: "unknown service", target: controlTarget{HostName: "web2", ServiceID: "disk"}, wantError: errNoTarget.Error()},
+		{name: "unknown group", target: controlTarget{ServiceGroup: "nope"}, wantError: errNoTarget.Error()},
+	}
+	for _, tt := range tests {
+		hostIDs, serviceKeys, err := tt.target.resolve()
+		if tt.wantError != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantError) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(hostIDs, ","); got != tt.hosts {
+			t.Errorf("%s: got hosts %q, want %q", tt.name, got, tt.hosts)
+		}
+		if got := strings.Join(serviceKeys, ","); got != tt.services {
+			t.Errorf("%s: got services %q, want %q", tt.name, got, tt.services)
+		}
+	}
+}
+
+// describe formats overrides as the history log does, "-" for none
+func describe(o models.Overrides) string {
+	if s := describeOverrides(o, false); s != "" {
+		return s
+	}
+	return "-"
+}
+
+func TestApplyOverrides(t *testing.T) {
+	yes, no := true, false
+	current := models.Overrides{ActiveChecks: &no, Notifications: &no}
+	tests := []struct {
+		name    string
+		changes models.Overrides
+		reset   bool
+		want    string
+	}{
+		{"no change", models.Overrides{}, false, "active_checks_enabled=false notifications_enabled=false"},
+		{"set one setting", models.Overrides{Notifications: &yes}, false, "active_checks_enabled=false notifications_enabled=true"},
+		{"add a setting", models.Overrides{FlapDetection: &no}, false, "active_checks_enabled=false notifications_enabled=false flap_detection_enabled=false"},
+		{"reset", models.Overrides{}, true, "-"},
+		{"reset then set", models.Overrides{EventHandlers: &yes}, true, "event_handler_enabled=true"},
+	}
+	for _, tt := range tests {
+		got := applyOverrides(current, tt.changes, tt.reset)
+		if describe(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, describe(got), tt.want)
+		}
+		// The result never shares a setting with the request
+		for _, f := range overrideFields(&got, &tt.changes) {
+			if *f.current != nil && *f.current == *f.change {
+				t.Errorf("%s: %s shared with the request", tt.name, f.name)
+			}
+		}
+	}
+	if *current.ActiveChecks || *current.Notifications || current.FlapDetection != nil {
+		t.Errorf("current overrides modified: %s", describe(current))
+	}
+}
+
+func TestScheduleCheckHandler(t *testing.T) {
+	no := false
+	now := time.Now().Truncate(time.Second)
+	later, earlier := now.Add(10*time.Minute), now.Add(-time.Minute)
+	tests := []struct {
+		name      string
+		host      string
+		at        time.Time // Now when zero
+		force     bool
+		scheduled bool
+		due       time.Time // Queued check time, zero when not queued
+	}{
+		{name: "active check", host: "active", scheduled: true, due: now},
+		{name: "timed check", host: "active", at: later, scheduled: true, due: later},
+		{name: "earlier check kept", host: "queued", at: later, scheduled: true, due: earlier},
+		{name: "earlier check kept when forced", host: "queued", at: later, force: true, scheduled: true, due: earlier},
+		{name: "disabled active checks", host: "disabled"},
+		{name: "disabled active checks forced", host: "disabled", force: true, scheduled: true, due: now},
+		{name: "passive only", host: "passive", force: true},
+		{name: "freshness command only", host: "fresh"},
+		{name: "freshness command only forced", host: "fresh", force: true, scheduled: true, due: now},
+	}
+	for _, tt := range tests {
+		pairState(t)
+		hosts = map[string]*models.Host{
+			"active":   {ID: "active", CheckCommand: "check_ping", NextCheck: now.Add(time.Hour)},
+			"queued":   {ID: "queued", CheckCommand: "check_ping", NextCheck: earlier},
+			"disabled": {ID: "disabled", CheckCommand: "check_ping", Overrides: models.Overrides{ActiveChecks: &no}},
+			"passive":  {ID: "passive"},
+			"fresh":    {ID: "fresh", FreshnessCommand: "check_dummy"},
+		}
+		services = make(map[string]*models.Service)
+		rebuildQueue()
+
+		at := tt.at
+		if at.IsZero() {
+			at = now
+		}
+		body := fmt.Sprintf(`{"host_name": %q, "time": %q, "force": %v}`, tt.host, at.Format(time.RFC3339), tt.force)
+		w := httptest.NewRecorder()
+		scheduleCheckHandler(w, httptest.NewRequest("POST", "/v1/schedule-check", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, http.StatusOK)
+			continue
+		}
+		var resp struct{ Scheduled, Skipped []string }
+		json.NewDecoder(w.Body).Decode(&resp)
+		if got := len(resp.Scheduled) == 1; got != tt.scheduled {
+			t.Errorf("%s: scheduled %v (skipped %v), want %v", tt.name, got, resp.Skipped, tt.scheduled)
+		}
+
+		key := "HOST:" + tt.host
+		due, queued := queuedAt(checks, key)
+		if !due.Equal(tt.due) && (queued || !tt.due.IsZero()) {
+			t.Errorf("%s: queued at %v (%v), want %v", tt.name, due, queued, tt.due)
+		}
+		forcedAt, forced := forcedChecks[key]
+		if forced != (tt.force && tt.scheduled) || (forced && !forcedAt.Equal(tt.due)) {
+			t.Errorf("%s: forced %v at %v, want %v at %v", tt.name, forced, forcedAt, tt.force && tt.scheduled, tt.due)
+		}
+	}
+}
+
+func TestForcedCheckIsDispatched(t *testing.T) {
+	pairState(t)
+	no := false
+	hosts = map[string]*models.Host{
+		"disabled": {ID: "disabled", CheckCommand: "check_ping", Overrides: models.Overrides{ActiveChecks: &no}},
+	}
+	services = make(map[string]*models.Service)
+	rebuildQueue()
+
+	body := `{"host_name": "disabled", "force": true}`
+	scheduleCheckHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/schedule-check", strings.NewReader(body)))
+	task, kind, ok := nextTask(time.Now().Add(time.Second))
+	if !ok || task.ID != "HOST:disabled" || kind != forcedCheck {
+		t.Fatalf("got task %q kind %d (%v), want the forced check", task.ID, kind, ok)
+	}
+	// The check runs once: active checks stay disabled
+	if task, _, ok := nextTask(time.Now().Add(time.Hour)); ok {
+		t.Errorf("got a second task %q", task.ID)
+	}
+	if _, ok := forcedChecks["HOST:disabled"]; ok {
+		t.Errorf("forced check still pending after dispatch")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+
+	"shinsakuto/pkg/models"
+)
+
+// Dependencies indexed by dependent object, guarded by mu
+var (
+	serviceDeps = make(map[string][]models.ServiceDependency)
+	hostDeps    = make(map[string][]models.HostDependency)
+)
+
+// serviceCriteria and hostCriteria map failure criteria letters to object states
+var (
+	serviceCriteria = map[string]int{"o": 0, "w": 1, "c": 2, "u": 3}
+	hostCriteria    = map[string]int{"o": models.HostUp, "d": models.HostDown, "u": models.HostUnreachable}
+)
+
+// loadDependencies indexes the dependency definitions of a sync-all payload
+func loadDependencies(cfg models.GlobalConfig) {
+	serviceDeps = make(map[string][]models.ServiceDependency)
+	for _, d := range cfg.ServiceDependencies {
+		key := models.ServiceKey(d.DependentHostName, d.DependentServiceID)
+		serviceDeps[key] = append(serviceDeps[key], d)
+	}
+	hostDeps = make(map[string][]models.HostDependency)
+	for _, d := range cfg.HostDependencies {
+		hostDeps[d.DependentHostName] = append(hostDeps[d.DependentHostName], d)
+	}
+}
+
+// matchesCriteria reports whether a state is listed in a "w,c,u" style criteria string
+func matchesCriteria(criteria string, state int, letters map[string]int) bool {
+	for _, c := range strings.Split(criteria, ",") {
+		if v, ok := letters[strings.TrimSpace(c)]; ok && v == state {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceMasterFailing checks the masters of a service against the selected criteria.
+// Only HARD master states are considered, so a master retrying a SOFT problem
+// does not block its dependents.
+func serviceMasterFailing(s *models.Service, criteria func(models.ServiceDependency) string) bool {
+	for _, d := range serviceDeps[s.Key()] {
+		master, ok := services[models.ServiceKey(d.HostName, d.ServiceID)]
+		if !ok || master.StateType == models.StateTypeSoft {
+			continue
+		}
+		if matchesCriteria(criteria(d), master.CurrentState, serviceCriteria) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMasterFailing checks the masters of a host against the selected criteria
+func hostMasterFailing(h *models.Host, criteria func(models.HostDependency) string) bool {
+	for _, d := range hostDeps[h.ID] {
+		master, ok := hosts[d.HostName]
+		if !ok || master.StateType == models.StateTypeSoft {
+			continue
+		}
+		if matchesCriteria(criteria(d), master.CurrentState, hostCriteria) {
+			return true
+		}
+	}
+	return false
+}
+
+func serviceExecutionCriteria(d models.ServiceDependency) string    { return d.ExecutionFailureCriteria }
+func serviceNotificationCriteria(d models.ServiceDependency) string { return d.NotificationFailureCriteria }
+func hostExecutionCriteria(d models.HostDependency) string          { return d.ExecutionFailureCriteria }
+func hostNotificationCriteria(d models.HostDependency) string       { return d.NotificationFailureCriteria }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveDependencies restores the dependency indexes after a test
+func saveDependencies(t *testing.T) {
+	sd, hd := serviceDeps, hostDeps
+	t.Cleanup(func() { serviceDeps, hostDeps = sd, hd })
+}
+
+func TestServiceDependencyCriteria(t *testing.T) {
+	saveInventory(t)
+	saveDependencies(t)
+	soft, hard := models.StateTypeSoft, models.StateTypeHard
+	tests := []struct {
+		name          string
+		state         int
+		stateType     string
+		execution     string
+		notification  string
+		masterMissing bool
+		wantExec      bool
+		wantNotify    bool
+	}{
+		{name: "master OK", state: 0, stateType: hard, execution: "w,c", notification: "w,c,u"},
+		{name: "master WARNING", state: 1, stateType: hard, execution: "c", notification: "w,c,u", wantNotify: true},
+		{name: "master CRITICAL", state: 2, stateType: hard, execution: "c", notification: "w,c,u", wantExec: true, wantNotify: true},
+		{name: "master UNKNOWN", state: 3, stateType: hard, execution: "w,c", notification: "u", wantNotify: true},
+		{name: "spaces in the criteria", state: 2, stateType: hard, execution: "w, c", notification: " c ", wantExec: true, wantNotify: true},
+		{name: "o fails on OK", state: 0, stateType: hard, execution: "o", notification: "n", wantExec: true},
+		{name: "n never fails", state: 2, stateType: hard, execution: "n", notification: "n"},
+		{name: "no criteria", state: 2, stateType: hard},
+		{name: "SOFT master ignored", state: 2, stateType: soft, execution: "c", notification: "c"},
+		{name: "unknown master ignored", state: 2, stateType: hard, execution: "c", notification: "c", masterMissing: true},
+	}
+	for _, tt := range tests {
+		master := &models.Service{ID: "mysql", HostName: "db1", CurrentState: tt.state, StateType: tt.stateType}
+		dependent := &models.Service{ID: "app", HostName: "web1"}
+		services = map[string]*models.Service{dependent.Key(): dependent}
+		if !tt.masterMissing {
+			services[master.Key()] = master
+		}
+		loadDependencies(models.GlobalConfig{ServiceDependencies: []models.ServiceDependency{{
+			HostName: "db1", ServiceID: "mysql", DependentHostName: "web1", DependentServiceID: "app",
+			ExecutionFailureCriteria: tt.execution, NotificationFailureCriteria: tt.notification,
+		}}})
+		if got := serviceMasterFailing(dependent, serviceExecutionCriteria); got != tt.wantExec {
+			t.Errorf("%s: execution dependency failing = %v, want %v", tt.name, got, tt.wantExec)
+		}
+		if got := serviceMasterFailing(dependent, serviceNotificationCriteria); got != tt.wantNotify {
+			t.Errorf("%s: notification dependency failing = %v, want %v", tt.name, got, tt.wantNotify)
+		}
+	}
+}
+
+func TestHostDependencyCriteria(t *testing.T) {
+	saveInventory(t)
+	saveDependencies(t)
+	up, down, unreachable := models.HostUp, models.HostDown, models.HostUnreachable
+	tests := []struct {
+		name         string
+		state        int
+		stateType    string
+		execution    string
+		notification string
+		wantExec     bool
+		wantNotify   bool
+	}{
+		{name: "master UP", state: up, stateType: models.StateTypeHard, execution: "d,u", notification: "d,u"},
+		{name: "master DOWN", state: down, stateType: models.StateTypeHard, execution: "d", notification: "d,u", wantExec: true, wantNotify: true},
+		{name: "master UNREACHABLE", state: unreachable, stateType: models.StateTypeHard, execution: "d", notification: "u", wantNotify: true},
+		{name: "o fails on UP", state: up, stateType: models.StateTypeHard, execution: "o", wantExec: true},
+		{name: "n never fails", state: down, stateType: models.StateTypeHard, execution: "n", notification: "n"},
+		{name: "SOFT master ignored", state: down, stateType: models.StateTypeSoft, execution: "d", notification: "d"},
+	}
+	for _, tt := range tests {
+		hosts = map[string]*models.Host{
+			"db1":  {ID: "db1", CurrentState: tt.state, StateType: tt.stateType},
+			"web1": {ID: "web1"},
+		}
+		loadDependencies(models.GlobalConfig{HostDependencies: []models.HostDependency{{
+			HostName: "db1", DependentHostName: "web1",
+			ExecutionFailureCriteria: tt.execution, NotificationFailureCriteria: tt.notification,
+		}}})
+		if got := hostMasterFailing(hosts["web1"], hostExecutionCriteria); got != tt.wantExec {
+			t.Errorf("%s: execution dependency failing = %v, want %v", tt.name, got, tt.wantExec)
+		}
+		if got := hostMasterFailing(hosts["web1"], hostNotificationCriteria); got != tt.wantNotify {
+			t.Errorf("%s: notification dependency failing = %v, want %v", tt.name, got, tt.wantNotify)
+		}
+	}
+}
+
+func TestExecutionDependencySkipsChecks(t *testing.T) {
+	saveInventory(t)
+	saveDependencies(t)
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	for _, id := range []string{"db1", "web1"} {
+		hosts[id] = &models.Host{ID: id, CheckCommand: "check_ping"}
+		s := &models.Service{ID: "http", HostName: id, CheckCommand: "check_http"}
+		services[s.Key()] = s
+	}
+	master, dependent := services["db1/http"], services["web1/http"]
+	master.CurrentState, master.StateType = 2, models.StateTypeHard
+	loadDependencies(models.GlobalConfig{ServiceDependencies: []models.ServiceDependency{{
+		HostName: "db1", ServiceID: "http", DependentHostName: "web1", DependentServiceID: "http",
+		ExecutionFailureCriteria: "c",
+	}}})
+	hosts["db1"].NextCheck, hosts["web1"].NextCheck = time.Now().Add(time.Hour), time.Now().Add(time.Hour)
+	master.CheckInterval, dependent.CheckInterval = 300, 300
+	rebuildQueue()
+
+	dispatched := func() string {
+		var keys []string
+		for {
+			task, _, ok := nextTask(time.Now())
+			if !ok {
+				return fmt.Sprint(keys)
+			}
+			keys = append(keys, task.ID)
+		}
+	}
+	if got := dispatched(); got != "[db1/http]" {
+		t.Errorf("master CRITICAL: dispatched %s, want only the master", got)
+	}
+	// The skipped check stays scheduled at its next interval
+	if _, ok := queuedAt(checks, dependent.Key()); !ok {
+		t.Error("skipped dependent check not rescheduled")
+	}
+
+	master.CurrentState = 0
+	dependent.NextCheck = time.Now().Add(-time.Second)
+	scheduleService(dependent)
+	if got := dispatched(); got != "[web1/http]" {
+		t.Errorf("master OK: dispatched %s, want the dependent", got)
+	}
+}
+
+func TestNotificationDependencySuppressesProblems(t *testing.T) {
+	s := notificationService(t, 600)
+	saveDependencies(t)
+	master := &models.Service{ID: "mysql", HostName: "db1", CurrentState: 2, StateType: models.StateTypeHard}
+	services[master.Key()] = master
+	loadDependencies(models.GlobalConfig{ServiceDependencies: []models.ServiceDependency{{
+		HostName: "db1", ServiceID: "mysql", DependentHostName: "web1", DependentServiceID: "http",
+		NotificationFailureCriteria: "w,c",
+	}}})
+	number := func() int { return s.NotificationNumber }
+
+	since := lastEventID()
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"})
+	if got := sentNotifications(since, number); len(got) != 0 {
+		t.Errorf("master CRITICAL: sent %v, want the PROBLEM suppressed", got)
+	}
+
+	// Once the master is back, the persisting problem is notified at the next result
+	master.CurrentState = 0
+	since = lastEventID()
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"})
+	if got := fmt.Sprint(sentNotifications(since, number)); got != "[PROBLEM#1]" {
+		t.Errorf("master OK: sent %s, want [PROBLEM#1]", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"shinsakuto/pkg/cron"
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// Downtimes received from the Arbiter and their compiled cron schedules, guarded by mu
+var (
+	downtimes []models.Downtime
+	schedules = make(map[string]*cron.Schedule)
+)
+
+// loadDowntimes replaces the downtime list, keeping the triggers of flexible downtimes.
+// Downtimes whose cron schedule does not parse are rejected.
+func loadDowntimes(dts []models.Downtime) {
+	triggers := make(map[string]time.Time)
+	for _, d := range downtimes {
+		if !d.TriggerTime.IsZero() {
+			triggers[d.ID] = d.TriggerTime
+		}
+	}
+	downtimes = make([]models.Downtime, 0, len(dts))
+	schedules = make(map[string]*cron.Schedule)
+	for _, d := range dts {
+		if t, ok := triggers[d.ID]; ok && d.TriggerTime.IsZero() {
+			d.TriggerTime = t
+		}
+		if _, ok := schedules[d.Schedule]; d.Schedule != "" && !ok {
+			sched, err := cron.Parse(d.Schedule)
+			if err != nil {
+				logger.Info("[ERROR] Downtime %s rejected: %v", d.ID, err)
+				continue
+			}
+			schedules[d.Schedule] = sched
+		}
+		downtimes = append(downtimes, d)
+	}
+}
+
+// startDowntimeChecker periodically starts and ends the scheduled downtimes
+func startDowntimeChecker() {
+	ticker := time.NewTicker(time.Duration(appConfig.DowntimeCheckInterval) * time.Second)
+	for range ticker.C {
+		mu.Lock()
+		if serving() {
+			evaluateDowntimes(time.Now())
+		}
+		mu.Unlock()
+	}
+}
+
+// evaluateDowntimes updates the InDowntime flag of every object, must be called under mu
+func evaluateDowntimes(now time.Time) {
+	activeHosts := make(map[string]models.Downtime)
+	activeServices := make(map[string]models.Downtime)
+	for i := range downtimes {
+		d := &downtimes[i]
+		triggerFlexible(d, objectFailing(d), now)
+		if !downtimeActive(d, now) {
+			continue
+		}
+		if d.ServiceID == "" {
+			activeHosts[d.HostName] = *d
+		} else {
+			activeServices[models.ServiceKey(d.HostName, d.ServiceID)] = *d
+		}
+	}
+
+	for _, h := range hosts {
+		d, active := activeHosts[h.ID]
+		if active != h.InDowntime {
+			h.InDowntime = active
+			notifyDowntime("HOST", h.ID, active, d)
+			markHost(h.ID)
+		}
+	}
+	for key, s := range services {
+		d, active := activeServices[key]
+		if active != s.InDowntime {
+			s.InDowntime = active
+			notifyDowntime("SERVICE", key, active, d)
+			markService(key)
+		}
+	}
+}
+
+// startFlexibleDowntimes triggers the flexible downtimes of an object that just went
+// non-OK, so it enters its downtime before any notification. Must be called under mu.
+func startFlexibleDowntimes(hostName, serviceID string, now time.Time) {
+	for i := range downtimes {
+		d := &downtimes[i]
+		if d.HostName != hostName || d.ServiceID != serviceID || !triggerFlexible(d, true, now) {
+			continue
+		}
+		if serviceID == "" {
+			if h, ok := hosts[hostName]; ok && !h.InDowntime {
+				h.InDowntime = true
+				notifyDowntime("HOST", h.ID, true, *d)
+				markHost(h.ID)
+			}
+		} else if s, ok := services[models.ServiceKey(hostName, serviceID)]; ok && !s.InDowntime {
+			s.InDowntime = true
+			notifyDowntime("SERVICE", s.Key(), true, *d)
+			markService(s.Key())
+		}
+	}
+}
+
+// triggerFlexible starts a pending flexible downtime when its object fails within the window
+func triggerFlexible(d *models.Downtime, failing bool, now time.Time) bool {
+	if !d.Flexible || !d.TriggerTime.IsZero() || !failing {
+		return false
+	}
+	if now.Before(d.StartTime) || !now.Before(d.EndTime) {
+		return false
+	}
+	d.TriggerTime = now
+	markConfig()
+	logger.Info("Flexible downtime %s triggered for %d minutes", d.ID, d.Duration)
+	return true
+}
+
+// objectFailing reports whether the object of a downtime is in a non-OK state
+func objectFailing(d *models.Downtime) bool {
+	if d.ServiceID == "" {
+		h, ok := hosts[d.HostName]
+		return ok && h.CurrentState != models.HostUp
+	}
+	s, ok := services[models.ServiceKey(d.HostName, d.ServiceID)]
+	return ok && s.CurrentState != 0
+}
+
+// downtimeActive reports whether a downtime covers the given time
+func downtimeActive(d *models.Downtime, now time.Time) bool {
+	duration := time.Duration(d.Duration) * time.Minute
+	if d.Recurring() {
+		// Optional start and end times bound the recurrence
+		if (!d.StartTime.IsZero() && now.Before(d.StartTime)) || (!d.EndTime.IsZero() && !now.Before(d.EndTime)) {
+			return false
+		}
+		if d.TimePeriod != "" {
+			return periods.Has(d.TimePeriod) && periods.In(d.TimePeriod, now)
+		}
+		sched := schedules[d.Schedule]
+		if sched == nil || duration <= 0 {
+			return false
+		}
+		_, ok := sched.Prev(now, duration-time.Nanosecond)
+		return ok
+	}
+	if d.Flexible {
+		return !d.TriggerTime.IsZero() && now.Before(d.TriggerTime.Add(duration))
+	}
+	return !now.Before(d.StartTime) && now.Before(d.EndTime)
+}
+
+// notifyDowntime records a downtime transition and sends DOWNTIMESTART/DOWNTIMEEND
+func notifyDowntime(entityType, id string, started bool, d models.Downtime) {
+	t, detail := models.NotificationDowntimeEnd, "Scheduled downtime has ended"
+	if started {
+		t = models.NotificationDowntimeStart
+		detail = fmt.Sprintf("Scheduled downtime %s by %s: %s", d.ID, d.Author, d.Comment)
+	}
+	logEvent(entityType, id, t, detail)
+	if started {
+		publishObjectEvent(eventDowntimeStart, entityType, id, d.Author, d.Comment)
+	} else {
+		publishObjectEvent(eventDowntimeEnd, entityType, id, "", "")
+	}
+	if n, ok := objectNotification(entityType, id, t, detail); ok {
+		n.Author, n.Comment = d.Author, d.Comment
+		notifyReactionner(n)
+	}
+}
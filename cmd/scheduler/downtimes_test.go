@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+func TestLoadDowntimesRejectsInvalidSchedules(t *testing.T) {
+	saveInventory(t)
+	triggered := time.Now().Add(-time.Minute)
+	downtimes = []models.Downtime{{ID: "flex", HostName: "web1", Flexible: true, TriggerTime: triggered}}
+
+	loadDowntimes([]models.Downtime{
+		{ID: "flex", HostName: "web1", Flexible: true},
+		{ID: "nightly", HostName: "web1", Schedule: "0 2 * * *", Duration: 60},
+		{ID: "broken", HostName: "web2", Schedule: "0 25 * * *", Duration: 60},
+	})
+
+	if len(downtimes) != 2 || downtimes[0].ID != "flex" || downtimes[1].ID != "nightly" {
+		t.Fatalf("downtimes = %+v, want flex and nightly", downtimes)
+	}
+	if !downtimes[0].TriggerTime.Equal(triggered) {
+		t.Errorf("flexible downtime trigger = %v, want %v", downtimes[0].TriggerTime, triggered)
+	}
+	if schedules["0 2 * * *"] == nil {
+		t.Error("schedule of the nightly downtime is not compiled")
+	}
+	if _, ok := schedules["0 25 * * *"]; ok {
+		t.Error("invalid schedule is stored")
+	}
+}
+
+func TestDowntimeOfSameServiceIDOnOtherHost(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	now := time.Now()
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}, "web2": {ID: "web2"}}
+	services = make(map[string]*models.Service)
+	for _, h := range []string{"web1", "web2"} {
+		s := &models.Service{ID: "http", HostName: h}
+		services[s.Key()] = s
+	}
+	loadDowntimes([]models.Downtime{{
+		ID: "dt1", HostName: "web1", ServiceID: "http", StartTime: now.Add(-time.Minute), EndTime: now.Add(time.Hour),
+	}})
+
+	evaluateDowntimes(now)
+	if !services["web1/http"].InDowntime || services["web2/http"].InDowntime {
+		t.Errorf("web1/http in downtime %v, web2/http %v, want only web1",
+			services["web1/http"].InDowntime, services["web2/http"].InDowntime)
+	}
+}
+
+func TestFlexibleDowntimeTrigger(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	now := time.Now()
+	tests := []struct {
+		name       string
+		state      int
+		start, end time.Time
+		want       bool
+	}{
+		{"object OK in the window", 0, now.Add(-time.Minute), now.Add(time.Hour), false},
+		{"failure in the window", 2, now.Add(-time.Minute), now.Add(time.Hour), true},
+		{"failure before the window", 2, now.Add(time.Minute), now.Add(time.Hour), false},
+		{"failure after the window", 2, now.Add(-time.Hour), now.Add(-time.Minute), false},
+		{"failure at the window end", 2, now.Add(-time.Hour), now, false},
+	}
+	for _, tt := range tests {
+		s := &models.Service{ID: "http", HostName: "web1", CurrentState: tt.state, StateType: models.StateTypeHard}
+		hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+		services = map[string]*models.Service{s.Key(): s}
+		downtimes = nil // loadDowntimes keeps the trigger of a downtime already known
+		loadDowntimes([]models.Downtime{{
+			ID: "flex", HostName: "web1", ServiceID: "http", Flexible: true, Duration: 30, StartTime: tt.start, EndTime: tt.end,
+		}})
+		evaluateDowntimes(now)
+		if s.InDowntime != tt.want || downtimes[0].TriggerTime.IsZero() == tt.want {
+			t.Errorf("%s: in downtime %v, triggered at %v, want %v", tt.name, s.InDowntime, downtimes[0].TriggerTime, tt.want)
+		}
+	}
+}
+
+func TestFlexibleDowntimeDuration(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	now := time.Now()
+	s := &models.Service{
+		ID: "http", HostName: "web1", CheckCommand: "check_http", StateType: models.StateTypeHard, Attempts: 1,
+		MaxAttempts: 1, CheckInterval: 300,
+	}
+	hosts = map[string]*models.Host{"web1": {ID: "web1", CheckInterval: 300}}
+	services = map[string]*models.Service{s.Key(): s}
+	checks = newCheckQueue()
+	loadDowntimes([]models.Downtime{{
+		ID: "flex", HostName: "web1", ServiceID: "http", Flexible: true, Duration: 30,
+		StartTime: now.Add(-time.Minute), EndTime: now.Add(2 * time.Hour),
+	}})
+
+	// The failing result starts the downtime at once, for its duration from the trigger
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"})
+	triggered := downtimes[0].TriggerTime
+	if triggered.IsZero() || !s.InDowntime {
+		t.Fatalf("failing result: in downtime %v, triggered at %v, want triggered", s.InDowntime, triggered)
+	}
+	evaluateDowntimes(triggered.Add(29 * time.Minute))
+	if !s.InDowntime {
+		t.Error("downtime ended before its duration")
+	}
+	evaluateDowntimes(triggered.Add(30 * time.Minute))
+	if s.InDowntime {
+		t.Error("downtime still active after its duration")
+	}
+	// A flexible downtime triggers once, even if the object still fails in the window
+	evaluateDowntimes(triggered.Add(time.Hour))
+	if s.InDowntime || !downtimes[0].TriggerTime.Equal(triggered) {
+		t.Errorf("in downtime %v, triggered at %v, want it over since %v", s.InDowntime, downtimes[0].TriggerTime, triggered)
+	}
+}
+
+func TestFlexibleDowntimeExpires(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	now := time.Now()
+	s := &models.Service{ID: "http", HostName: "web1", StateType: models.StateTypeHard}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = map[string]*models.Service{s.Key(): s}
+	loadDowntimes([]models.Downtime{{
+		ID: "flex", HostName: "web1", ServiceID: "http", Flexible: true, Duration: 30, StartTime: now, EndTime: now.Add(time.Hour),
+	}})
+
+	// The object stays OK for the whole window, then fails once it has passed
+	evaluateDowntimes(now.Add(30 * time.Minute))
+	s.CurrentState = 2
+	evaluateDowntimes(now.Add(time.Hour))
+	evaluateDowntimes(now.Add(90 * time.Minute))
+	if s.InDowntime || !downtimes[0].TriggerTime.IsZero() {
+		t.Errorf("in downtime %v, triggered at %v, want the expired downtime never started", s.InDowntime, downtimes[0].TriggerTime)
+	}
+}
@@ -10,45 +10,195 @@ import (
 	"time"
 
 	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/perfdata"
 )
 
-// handleHostResult updates host state and triggers notifications on change
+// handleHostResult updates host state and triggers notifications on HARD changes
 func handleHostResult(res models.CheckResult) {
 	hID := strings.TrimPrefix(res.ID, "HOST:")
 	h, ok := hosts[hID]
 	if !ok { return }
+	perfdata.Split(&res)
 
-	wasUp := h.IsUp
-	h.IsUp = (res.Status == 0)
-	h.Status, h.Output = res.Status, res.Output
+	oldState, oldType, oldAttempts := h.CurrentState, h.StateType, h.Attempts
+	newState := models.HostUp
+	if res.Status != 0 {
+		// A failing host behind failed parents is UNREACHABLE rather than DOWN
+		newState = hostFailureState(h)
+		checkParentsNow(h)
+	}
+	attempts, stateType, hardChange := evaluateAttempt(oldState, newState, oldType, h.Attempts, h.MaxAttempts)
 
-	if wasUp != h.IsUp {
-		state := "UP"
-		if !h.IsUp { state = "DOWN" }
-		logStateChange("HOST", h.ID, state, res.Output)
-		if !h.InDowntime {
-			notifyReactionner(h.ID, state, res.Status, res.Output)
-		}
+	h.IsUp = (newState == models.HostUp)
+	h.CurrentState, h.Status, h.Output = newState, res.Status, res.ShortOutput
+	h.LongOutput, h.Perfdata = res.LongOutput, res.Perfdata
+	h.Attempts, h.StateType = attempts, stateType
+	h.LastCheck = time.Now()
+	recordExecution(res, &h.ExecutionTime, &h.LastPoller)
+
+	// SOFT problems are re-checked at the retry interval until they become HARD
+	if !h.IsUp && h.StateType == models.StateTypeSoft {
+		h.NextCheck = alignToPeriod(h.CheckPeriod, time.Now().Add(retryDelay(h.RetryInterval, checkDelay(h.CheckInterval, h.NormalInterval))))
+		scheduleHost(h)
+	}
+
+	// A failure starts pending flexible downtimes before any notification
+	if !h.IsUp {
+		startFlexibleDowntimes(h.ID, "", h.LastCheck)
+	}
+
+	if oldState != newState || oldType != stateType {
+		logStateChange("HOST", h.ID, hostStateName(newState), stateType, attempts, maxAttempts(h.MaxAttempts), res.ShortOutput)
+		publishStateChange("HOST", h.ID, hostStateName(oldState))
+	}
+	if eventHandlerDue(oldState, newState, oldType, stateType, oldAttempts, attempts) {
+		runHostEventHandlers(h)
+	}
+
+	flap := updateFlapping(&h.StateHistory, &h.StateRecorded, &h.PercentStateChange, &h.IsFlapping, newState,
+		h.LastCheck, checkDelay(h.CheckInterval, h.NormalInterval),
+		overridden(h.Overrides.FlapDetection, h.FlapDetectionEnabled), h.LowFlapThreshold, h.HighFlapThreshold)
+	notifyFlapping("HOST", h.ID, flap, h.PercentStateChange, res.ShortOutput,
+		h.InDowntime || !notificationAllowed(h.NotificationPeriod))
+
+	if hardChange {
+		// Children must re-evaluate their reachability against the new parent state
+		checkChildrenNow(h)
 	}
+	notifyHostResult(h, oldState, hardChange, res.ShortOutput)
 	forwardToBroker(res)
+	publishResult("HOST", h.ID)
 }
 
-// handleServiceResult updates service state and alerts if necessary
+// handleServiceResult updates service state and alerts on HARD changes
 func handleServiceResult(res models.CheckResult) {
 	s, ok := services[res.ID] 
 	if !ok { return }
+	perfdata.Split(&res)
 
-	oldState := s.CurrentState
-	s.CurrentState, s.Output = res.Status, res.Output
+	oldState, oldType, oldAttempts := s.CurrentState, s.StateType, s.Attempts
+	attempts, stateType, hardChange := evaluateAttempt(oldState, res.Status, oldType, s.Attempts, s.MaxAttempts)
 
-	if oldState != s.CurrentState {
-		logStateChange("SERVICE", s.ID, "CHANGE", res.Output)
-		host, hostExists := hosts[s.HostName]
-		if !s.InDowntime && (!hostExists || !host.InDowntime) {
-			notifyReactionner(s.ID, "ALERT", res.Status, res.Output)
-		}
+	s.CurrentState, s.Output = res.Status, res.ShortOutput
+	s.LongOutput, s.Perfdata = res.LongOutput, res.Perfdata
+	s.Attempts, s.StateType = attempts, stateType
+	s.LastCheck = time.Now()
+	recordExecution(res, &s.ExecutionTime, &s.LastPoller)
+
+	// SOFT problems are re-checked at the retry interval until they become HARD
+	if s.CurrentState != 0 && s.StateType == models.StateTypeSoft {
+		s.NextCheck = alignToPeriod(s.CheckPeriod, time.Now().Add(retryDelay(s.RetryInterval, checkDelay(s.CheckInterval, s.NormalInterval))))
+		scheduleService(s)
+	}
+
+	// A failure starts pending flexible downtimes before any notification
+	if s.CurrentState != 0 {
+		startFlexibleDowntimes(s.HostName, s.ID, s.LastCheck)
+	}
+
+	if oldState != s.CurrentState || oldType != stateType {
+		logStateChange("SERVICE", s.Key(), serviceStateName(s.CurrentState), stateType, attempts, maxAttempts(s.MaxAttempts), res.ShortOutput)
+		publishStateChange("SERVICE", s.Key(), serviceStateName(oldState))
+	}
+	if eventHandlerDue(oldState, s.CurrentState, oldType, stateType, oldAttempts, attempts) {
+		runServiceEventHandlers(s)
 	}
+
+	host, hostExists := hosts[s.HostName]
+	inDowntime := s.InDowntime || (hostExists && host.InDowntime)
+
+	flap := updateFlapping(&s.StateHistory, &s.StateRecorded, &s.PercentStateChange, &s.IsFlapping, s.CurrentState,
+		s.LastCheck, checkDelay(s.CheckInterval, s.NormalInterval),
+		overridden(s.Overrides.FlapDetection, s.FlapDetectionEnabled), s.LowFlapThreshold, s.HighFlapThreshold)
+	notifyFlapping("SERVICE", s.Key(), flap, s.PercentStateChange, res.ShortOutput,
+		inDowntime || !notificationAllowed(s.NotificationPeriod))
+
+	notifyServiceResult(s, oldState, hardChange, inDowntime, res.ShortOutput)
 	forwardToBroker(res)
+	publishResult("SERVICE", s.Key())
+}
+
+// evaluateAttempt applies the soft/hard state rules to a new check result.
+// It returns the new attempt counter, the resulting state type and whether
+// the result is a HARD state change that must be notified.
+func evaluateAttempt(oldState, newState int, oldType string, attempts, max int) (int, string, bool) {
+	max = maxAttempts(max)
+	wasHard := oldType != models.StateTypeSoft
+
+	// Recovery: only a recovery from a HARD problem is notified
+	if newState == 0 {
+		return 1, models.StateTypeHard, oldState != 0 && wasHard
+	}
+
+	// Already in a HARD problem: only a change of problem state is notified
+	if oldState != 0 && wasHard {
+		return max, models.StateTypeHard, oldState != newState
+	}
+
+	if oldState == 0 {
+		attempts = 1
+	} else {
+		attempts++
+	}
+	if attempts >= max {
+		return max, models.StateTypeHard, true
+	}
+	return attempts, models.StateTypeSoft, false
+}
+
+// maxAttempts returns the effective number of attempts before a HARD state
+func maxAttempts(n int) int {
+	if n <= 0 {
+		return 1
+	}
+	return n
+}
+
+// checkDelay returns the regular re-check delay of an object.
+// check_interval takes precedence over normal_interval, then the scheduler default applies.
+func checkDelay(checkInterval, normalInterval int) time.Duration {
+	n := checkInterval
+	if n <= 0 {
+		n = normalInterval
+	}
+	if n <= 0 {
+		n = appConfig.DefaultCheckInterval
+	}
+	return intervalDuration(n)
+}
+
+// retryDelay returns the re-check delay of an object in a SOFT problem state
+func retryDelay(retryInterval int, normal time.Duration) time.Duration {
+	if retryInterval <= 0 {
+		return normal
+	}
+	return intervalDuration(retryInterval)
+}
+
+// hostStateName returns the Nagios name of a host state
+func hostStateName(state int) string {
+	switch state {
+	case models.HostUp:
+		return "UP"
+	case models.HostUnreachable:
+		return "UNREACHABLE"
+	default:
+		return "DOWN"
+	}
+}
+
+// serviceStateName returns the Nagios name of a service state
+func serviceStateName(state int) string {
+	switch state {
+	case 0:
+		return "OK"
+	case 1:
+		return "WARNING"
+	case 2:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
 }
 
 // forwardToBroker sends data to external storage brokers
@@ -73,15 +223,17 @@ func forwardToBroker(res models.CheckResult) {
 }
 
 // notifyReactionner triggers the notification engine
-func notifyReactionner(id, t string, state int, out string) {
-	logger.Info("Triggering notification for %s", id)
-	payload, _ := json.Marshal(models.NotificationRequest{
-		EntityID: id, Type: t, State: state, Output: out, Timestamp: time.Now(),
-	})
+func notifyReactionner(n models.NotificationRequest) {
+	logger.Info("Triggering %s notification for %s", n.Type, n.EntityID)
+	n.Timestamp = time.Now()
+	recordNotification(n)
+	publishNotification(n)
+	payload, _ := json.Marshal(n)
+	url := appConfig.ReactionnerURL
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		req, _ := http.NewRequestWithContext(ctx, "POST", appConfig.ReactionnerURL, bytes.NewBuffer(payload))
+		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
 		req.Header.Set("Content-Type", "application/json")
 		resp, err := httpClient.Do(req)
 		if err == nil {
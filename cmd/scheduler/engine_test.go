@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// defaultConfig loads the defaults of an empty configuration file, with the given
+// interval unit. The configuration is restored by saveInventory.
+func defaultConfig(t *testing.T, unit string) {
+	path := filepath.Join(t.TempDir(), "scheduler.json")
+	if err := os.WriteFile(path, []byte(`{"interval_unit": "`+unit+`"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluateAttempt(t *testing.T) {
+	soft, hard := models.StateTypeSoft, models.StateTypeHard
+	tests := []struct {
+		name               string
+		oldState, newState int
+		oldType            string
+		attempts, max      int
+		wantAttempts       int
+		wantType           string
+		wantHardChange     bool
+	}{
+		{"ok stays ok", 0, 0, hard, 1, 3, 1, hard, false},
+		{"first failure is soft", 0, 2, hard, 1, 3, 1, soft, false},
+		{"second failure stays soft", 2, 2, soft, 1, 3, 2, soft, false},
+		{"soft state change counts attempts", 1, 2, soft, 1, 3, 2, soft, false},
+		{"max_attempts reached is hard", 2, 2, soft, 2, 3, 3, hard, true},
+		{"hard problem persists", 2, 2, hard, 3, 3, 3, hard, false},
+		{"hard problem changes state", 2, 1, hard, 3, 3, 3, hard, true},
+		{"soft recovery is not notified", 2, 0, soft, 2, 3, 1, hard, false},
+		{"hard recovery is notified", 2, 0, hard, 3, 3, 1, hard, true},
+		{"single attempt is hard at once", 0, 2, hard, 1, 1, 1, hard, true},
+		{"unset max_attempts is one attempt", 0, 1, hard, 1, 0, 1, hard, true},
+	}
+	for _, tt := range tests {
+		attempts, stateType, hardChange := evaluateAttempt(tt.oldState, tt.newState, tt.oldType, tt.attempts, tt.max)
+		if attempts != tt.wantAttempts || stateType != tt.wantType || hardChange != tt.wantHardChange {
+			t.Errorf("%s: got %d, %s, %v, want %d, %s, %v", tt.name,
+				attempts, stateType, hardChange, tt.wantAttempts, tt.wantType, tt.wantHardChange)
+		}
+	}
+}
+
+func TestCheckAndRetryDelays(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "minutes")
+	appConfig.DefaultCheckInterval = 5
+	tests := []struct {
+		name                 string
+		check, normal, retry int
+		wantCheck, wantRetry time.Duration
+	}{
+		{"check_interval", 10, 3, 1, 10 * time.Minute, time.Minute},
+		{"normal_interval", 0, 3, 1, 3 * time.Minute, time.Minute},
+		{"scheduler default", 0, 0, 2, 5 * time.Minute, 2 * time.Minute},
+		{"no retry_interval", 10, 0, 0, 10 * time.Minute, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		check := checkDelay(tt.check, tt.normal)
+		if retry := retryDelay(tt.retry, check); check != tt.wantCheck || retry != tt.wantRetry {
+			t.Errorf("%s: delays = %v, %v, want %v, %v", tt.name, check, retry, tt.wantCheck, tt.wantRetry)
+		}
+	}
+}
+
+func TestServiceResultRetriesUntilHard(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	s := &models.Service{
+		ID: "http", HostName: "web1", CheckCommand: "check_http", StateType: models.StateTypeHard, Attempts: 1,
+		MaxAttempts: 3, CheckInterval: 300, RetryInterval: 30,
+	}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = map[string]*models.Service{s.Key(): s}
+	downtimes = nil
+	checks = newCheckQueue()
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"})
+		wantType := models.StateTypeSoft
+		if attempt == 3 {
+			wantType = models.StateTypeHard
+		}
+		if s.Attempts != attempt || s.StateType != wantType {
+			t.Fatalf("after failure %d: attempt %d %s, want %d %s", attempt, s.Attempts, s.StateType, attempt, wantType)
+		}
+		if attempt == 3 {
+			break
+		}
+		// SOFT problems are re-checked at retry_interval, not check_interval
+		if at, ok := queuedAt(checks, s.Key()); !ok || at.Before(before.Add(30*time.Second)) || at.After(time.Now().Add(30*time.Second)) {
+			t.Errorf("after failure %d: queued at %v (%v), want in 30s", attempt, at, ok)
+		}
+	}
+
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 0, Output: "OK"})
+	if s.CurrentState != 0 || s.Attempts != 1 || s.StateType != models.StateTypeHard {
+		t.Errorf("after recovery: state %d attempt %d %s, want 0 1 HARD", s.CurrentState, s.Attempts, s.StateType)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// Contacts, contact groups and escalations received from the Arbiter, guarded by mu
+var (
+	contacts      = make(map[string]models.Contact)
+	contactGroups = make(map[string][]string)
+	escalations   = make(map[string]models.Escalation)
+)
+
+// loadEscalations indexes the notification routing definitions of a sync-all payload
+func loadEscalations(cfg models.GlobalConfig) {
+	contacts = make(map[string]models.Contact, len(cfg.Contacts))
+	for _, c := range cfg.Contacts {
+		contacts[c.ID] = c
+	}
+	contactGroups = make(map[string][]string, len(cfg.ContactGroups))
+	for _, g := range cfg.ContactGroups {
+		contactGroups[g.ID] = g.Members
+	}
+	escalations = make(map[string]models.Escalation, len(cfg.Escalations))
+	for _, e := range cfg.Escalations {
+		escalations[e.ID] = e
+	}
+}
+
+// activeEscalations returns the escalations of an object that apply to a notification
+// number, 'since' being the first notification of the problem. Must be called under mu.
+func activeEscalations(ids []string, number int, since, now time.Time) []models.Escalation {
+	var active []models.Escalation
+	for _, id := range ids {
+		e, ok := escalations[id]
+		if !ok || !periods.In(e.EscalationPeriod, now) {
+			continue
+		}
+		if escalationMatches(e, number, now.Sub(since)) {
+			active = append(active, e)
+		}
+	}
+	return active
+}
+
+// escalationMatches checks the notification number and elapsed time ranges of an
+// escalation; reaching either range is enough. Times are in interval_unit.
+func escalationMatches(e models.Escalation, number int, elapsed time.Duration) bool {
+	if e.FirstNotification > 0 || e.LastNotification > 0 {
+		if number >= e.FirstNotification && (e.LastNotification == 0 || number <= e.LastNotification) {
+			return true
+		}
+	}
+	if e.FirstNotificationTime > 0 || e.LastNotificationTime > 0 {
+		first, last := intervalDuration(e.FirstNotificationTime), intervalDuration(e.LastNotificationTime)
+		if elapsed >= first && (last == 0 || elapsed <= last) {
+			return true
+		}
+	}
+	return false
+}
+
+// escalatedInterval returns the re-notification interval of an object: the smallest
+// interval set by an active escalation, or the object's own interval
+func escalatedInterval(interval int, active []models.Escalation) int {
+	min := 0
+	for _, e := range active {
+		if e.NotificationInterval > 0 && (min == 0 || e.NotificationInterval < min) {
+			min = e.NotificationInterval
+		}
+	}
+	if min > 0 {
+		return min
+	}
+	return interval
+}
+
+// notificationContacts resolves the recipients of a notification. Active escalations
+// replace the contacts of the object.
+func notificationContacts(contactIDs, groupIDs []string, active []models.Escalation) []models.Contact {
+	if len(active) > 0 {
+		contactIDs, groupIDs = nil, nil
+		for _, e := range active {
+			contactIDs = append(contactIDs, e.Contacts...)
+			groupIDs = append(groupIDs, e.ContactGroups...)
+		}
+	}
+	for _, g := range groupIDs {
+		contactIDs = append(contactIDs, contactGroups[g]...)
+	}
+
+	var out []models.Contact
+	seen := make(map[string]bool)
+	for _, id := range contactIDs {
+		c, ok := contacts[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+func TestEscalationMatches(t *testing.T) {
+	saveInventory(t)
+	tests := []struct {
+		name    string
+		unit    string
+		e       models.Escalation
+		number  int
+		elapsed time.Duration
+		want    bool
+	}{
+		{"before the first notification", "seconds", models.Escalation{FirstNotification: 3}, 2, 0, false},
+		{"first notification", "seconds", models.Escalation{FirstNotification: 3}, 3, 0, true},
+		{"open-ended", "seconds", models.Escalation{FirstNotification: 3}, 30, 0, true},
+		{"last notification", "seconds", models.Escalation{FirstNotification: 2, LastNotification: 4}, 4, 0, true},
+		{"after the last notification", "seconds", models.Escalation{FirstNotification: 2, LastNotification: 4}, 5, 0, false},
+		{"before the first time", "minutes", models.Escalation{FirstNotificationTime: 30}, 1, 29 * time.Minute, false},
+		{"first time, in minutes", "minutes", models.Escalation{FirstNotificationTime: 30}, 1, 30 * time.Minute, true},
+		{"after the last time", "minutes", models.Escalation{FirstNotificationTime: 30, LastNotificationTime: 60}, 1, 61 * time.Minute, false},
+		{"first time, in seconds", "seconds", models.Escalation{FirstNotificationTime: 30}, 1, 30 * time.Second, true},
+		{"after the last time, in seconds", "seconds", models.Escalation{FirstNotificationTime: 30, LastNotificationTime: 60}, 1, 61 * time.Second, false},
+		{"either range", "minutes", models.Escalation{FirstNotification: 3, FirstNotificationTime: 30}, 1, 45 * time.Minute, true},
+		{"no range", "seconds", models.Escalation{}, 10, time.Hour, false},
+	}
+	for _, tt := range tests {
+		appConfig.IntervalUnit = tt.unit
+		if got := escalationMatches(tt.e, tt.number, tt.elapsed); got != tt.want {
+			t.Errorf("%s: escalationMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestOnCallPolicy checks the policy escalations are meant for: first alert to the team,
+// after the 3rd notification or 30 minutes to the lead, after 1h to the manager
+func TestOnCallPolicy(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	loadEscalations(models.GlobalConfig{
+		Contacts: []models.Contact{{ID: "team"}, {ID: "lead"}, {ID: "manager"}},
+		Escalations: []models.Escalation{
+			{ID: "to-lead", FirstNotification: 3, FirstNotificationTime: 1800, NotificationInterval: 600, Contacts: []string{"lead"}},
+			{ID: "to-manager", FirstNotificationTime: 3600, NotificationInterval: 300, Contacts: []string{"manager"}},
+		},
+	})
+	ids := []string{"to-lead", "to-manager"}
+	since := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		number       int
+		elapsed      time.Duration
+		want         string
+		wantInterval int
+	}{
+		{1, 0, "[team]", 900},
+		{2, 15 * time.Minute, "[team]", 900},
+		{3, 20 * time.Minute, "[lead]", 600},
+		{2, 30 * time.Minute, "[lead]", 600},
+		{4, 59 * time.Minute, "[lead]", 600},
+		{5, 60 * time.Minute, "[lead manager]", 300},
+		{2, 90 * time.Minute, "[lead manager]", 300},
+	}
+	for _, tt := range tests {
+		active := activeEscalations(ids, tt.number, since, since.Add(tt.elapsed))
+		var got []string
+		for _, c := range notificationContacts([]string{"team"}, nil, active) {
+			got = append(got, c.ID)
+		}
+		if fmt.Sprint(got) != tt.want || escalatedInterval(900, active) != tt.wantInterval {
+			t.Errorf("notification %d after %v: contacts %v every %d, want %s every %d",
+				tt.number, tt.elapsed, got, escalatedInterval(900, active), tt.want, tt.wantInterval)
+		}
+	}
+}
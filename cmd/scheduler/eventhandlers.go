@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/macros"
+	"shinsakuto/pkg/models"
+)
+
+// eventTask remembers which object an event handler task was dispatched for
+type eventTask struct {
+	EntityType string
+	ObjectID   string
+	Command    string
+}
+
+// Event handler tasks waiting for a poller and awaiting their result, guarded by mu
+var (
+	pendingEvents []models.CheckTask
+	eventTasks    = make(map[string]eventTask)
+	eventSeq      uint64
+)
+
+// eventHandlerDue reports whether a result must run event handlers: on every state
+// or state type change, and on every SOFT retry of a problem
+func eventHandlerDue(oldState, newState int, oldType, newType string, oldAttempts, newAttempts int) bool {
+	if oldState != newState || oldType != newType {
+		return true
+	}
+	return newType == models.StateTypeSoft && newState != 0 && oldAttempts != newAttempts
+}
+
+// runHostEventHandlers queues the global and host event handlers, must be called under mu
+func runHostEventHandlers(h *models.Host) {
+	if !enabled(overridden(h.Overrides.EventHandlers, h.EventHandlerEnabled)) {
+		return
+	}
+	for _, ref := range []string{appConfig.GlobalHostEventHandler, h.EventHandler} {
+		if ref != "" {
+			queueEventHandler("HOST", h.ID, ref, resolveCommand(ref, hostMacros(h)))
+		}
+	}
+}
+
+// runServiceEventHandlers queues the global and service event handlers, must be called under mu
+func runServiceEventHandlers(s *models.Service) {
+	if !enabled(overridden(s.Overrides.EventHandlers, s.EventHandlerEnabled)) {
+		return
+	}
+	for _, ref := range []string{appConfig.GlobalServiceEventHandler, s.EventHandler} {
+		if ref != "" {
+			queueEventHandler("SERVICE", s.Key(), ref, resolveCommand(ref, serviceMacros(s)))
+		}
+	}
+}
+
+// queueEventHandler hands an event handler command to the next poller request
+func queueEventHandler(entityType, id, ref, command string) {
+	eventSeq++
+	taskID := "EVENT:" + strconv.FormatUint(eventSeq, 10)
+	name, _ := macros.SplitCommand(ref)
+	eventTasks[taskID] = eventTask{EntityType: entityType, ObjectID: id, Command: name}
+	pendingEvents = append(pendingEvents, models.CheckTask{ID: taskID, Command: command})
+	logger.Info("Event handler %s queued for %s %s", name, strings.ToLower(entityType), id)
+}
+
+// popEvent returns the oldest pending event handler task, must be called under mu
+func popEvent() (models.CheckTask, bool) {
+	if len(pendingEvents) == 0 {
+		return models.CheckTask{}, false
+	}
+	task := pendingEvents[0]
+	pendingEvents = pendingEvents[1:]
+	return task, true
+}
+
+// handleEventResult records the outcome of an event handler in the history log
+func handleEventResult(res models.CheckResult) {
+	ev, ok := eventTasks[res.ID]
+	if !ok {
+		return
+	}
+	delete(eventTasks, res.ID)
+	logEvent(ev.EntityType, ev.ObjectID, "EVENTHANDLER", fmt.Sprintf("%s exited %d: %s", ev.Command, res.Status, res.Output))
+}
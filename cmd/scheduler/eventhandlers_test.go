@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveEvents restores the event handler queue after a test
+func saveEvents(t *testing.T) {
+	pe, et, seq := pendingEvents, eventTasks, eventSeq
+	t.Cleanup(func() { pendingEvents, eventTasks, eventSeq = pe, et, seq })
+	pendingEvents, eventTasks = nil, make(map[string]eventTask)
+}
+
+// queuedEvents pops the pending event handler tasks as "command object"
+func queuedEvents() []string {
+	var queued []string
+	for {
+		task, ok := popEvent()
+		if !ok {
+			return queued
+		}
+		ev := eventTasks[task.ID]
+		queued = append(queued, ev.Command+" "+ev.ObjectID)
+	}
+}
+
+func TestEventHandlerDue(t *testing.T) {
+	soft, hard := models.StateTypeSoft, models.StateTypeHard
+	tests := []struct {
+		name                  string
+		oldState, newState    int
+		oldType, newType      string
+		oldAttempts, attempts int
+		want                  bool
+	}{
+		{"first SOFT failure", 0, 2, hard, soft, 1, 1, true},
+		{"SOFT retry", 2, 2, soft, soft, 1, 2, true},
+		{"SOFT to HARD", 2, 2, soft, hard, 2, 3, true},
+		{"HARD problem persists", 2, 2, hard, hard, 3, 3, false},
+		{"HARD state change", 1, 2, hard, hard, 1, 1, true},
+		{"recovery", 2, 0, hard, hard, 3, 1, true},
+		{"OK persists", 0, 0, hard, hard, 1, 1, false},
+	}
+	for _, tt := range tests {
+		if got := eventHandlerDue(tt.oldState, tt.newState, tt.oldType, tt.newType, tt.oldAttempts, tt.attempts); got != tt.want {
+			t.Errorf("%s: eventHandlerDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServiceEventHandlers(t *testing.T) {
+	saveInventory(t)
+	saveEvents(t)
+	defaultConfig(t, "seconds")
+	appConfig.GlobalServiceEventHandler = "log_change"
+	s := &models.Service{
+		ID: "http", HostName: "web1", CheckCommand: "check_http", StateType: models.StateTypeHard, Attempts: 1,
+		MaxAttempts: 3, EventHandler: "restart_http",
+	}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = map[string]*models.Service{s.Key(): s}
+	downtimes = nil
+	checks = newCheckQueue()
+
+	both := "[log_change web1/http restart_http web1/http]"
+	steps := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{"SOFT failure", 2, both},
+		{"SOFT retry", 2, both},
+		{"HARD failure", 2, both},
+		{"HARD problem persists", 2, "[]"},
+		{"HARD state change", 1, both},
+		{"recovery", 0, both},
+		{"OK persists", 0, "[]"},
+	}
+	for _, st := range steps {
+		handleServiceResult(models.CheckResult{ID: s.Key(), Status: st.status, Output: "output"})
+		if got := fmt.Sprint(queuedEvents()); got != st.want {
+			t.Errorf("%s: queued %s, want %s", st.name, got, st.want)
+		}
+	}
+}
+
+func TestEventHandlerEnabled(t *testing.T) {
+	saveInventory(t)
+	saveEvents(t)
+	defaultConfig(t, "seconds")
+	appConfig.GlobalHostEventHandler = "log_change"
+	no, yes := false, true
+	tests := []struct {
+		name       string
+		configured *bool
+		override   *bool
+		want       string
+	}{
+		{name: "enabled by default", want: "[log_change web1 reboot web1]"},
+		{name: "event_handler_enabled false", configured: &no, want: "[]"},
+		{name: "enabled by an override", configured: &no, override: &yes, want: "[log_change web1 reboot web1]"},
+		{name: "disabled by an override", configured: &yes, override: &no, want: "[]"},
+	}
+	for _, tt := range tests {
+		h := &models.Host{
+			ID: "web1", CheckCommand: "check_ping", IsUp: true, StateType: models.StateTypeHard, Attempts: 1, MaxAttempts: 1,
+			EventHandler: "reboot", EventHandlerEnabled: tt.configured,
+		}
+		h.Overrides.EventHandlers = tt.override
+		hosts = map[string]*models.Host{"web1": h}
+		services = make(map[string]*models.Service)
+		checks = newCheckQueue()
+		handleHostResult(models.CheckResult{ID: "HOST:web1", Status: 2, Output: "DOWN"})
+		if got := fmt.Sprint(queuedEvents()); got != tt.want {
+			t.Errorf("%s: queued %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEventHandlersGoFirst(t *testing.T) {
+	saveInventory(t)
+	saveEvents(t)
+	s := &models.Service{ID: "http", HostName: "web1", CheckCommand: "check_http", EventHandler: "restart_http $HOSTNAME$"}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = map[string]*models.Service{s.Key(): s}
+	rebuildQueue()
+	runServiceEventHandlers(s)
+
+	// Event handlers are dispatched before due checks and are answered once
+	event, _, ok := nextTask(time.Now())
+	if !ok || event.Command != "restart_http web1" {
+		t.Fatalf("got task %+v, want the event handler first", event)
+	}
+	if task, _, _ := nextTask(time.Now()); task.ID != s.Key() {
+		t.Errorf("got task %+v after the event handler, want the due check", task)
+	}
+	handleEventResult(models.CheckResult{ID: "EVENT:unknown", Status: 0})
+	handleEventResult(models.CheckResult{ID: event.ID, Status: 0, Output: "restarted"})
+	if len(eventTasks) != 0 {
+		t.Errorf("%d event handlers still awaited, want 0", len(eventTasks))
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// Event types of the /v1/events stream
+const (
+	eventResult          = "result"
+	eventStateChange     = "state_change"
+	eventNotification    = "notification"
+	eventDowntimeStart   = "downtime_start"
+	eventDowntimeEnd     = "downtime_end"
+	eventAcknowledgement = "acknowledgement"
+	eventFlappingStart   = "flapping_start"
+	eventFlappingStop    = "flapping_stop"
+)
+
+// streamEvent is an event sent to the /v1/events subscribers
+type streamEvent struct {
+	ID                uint64    `json:"id"`
+	Type              string    `json:"type"`
+	Time              time.Time `json:"time"`
+	EntityType        string    `json:"entity_type"` // HOST or SERVICE
+	HostName          string    `json:"host_name"`
+	ServiceID         string    `json:"service_id,omitempty"`
+	State             int       `json:"state"`
+	StateName         string    `json:"state_name"`
+	StateType         string    `json:"state_type"`
+	Attempt           int       `json:"attempt"`
+	PreviousStateName string    `json:"previous_state_name,omitempty"` // state_change only
+	Output            string    `json:"output,omitempty"`
+	NotificationType  string    `json:"notification_type,omitempty"` // notification only
+	Contacts          []string  `json:"contacts,omitempty"`
+	Acknowledged      *bool     `json:"acknowledged,omitempty"` // acknowledgement only: set or cleared
+	Author            string    `json:"author,omitempty"`
+	Comment           string    `json:"comment,omitempty"`
+	// Groups of the object, used by the subscriber filters
+	hostGroups    []string
+	serviceGroups []string
+}
+
+// eventFilter selects the events of a subscriber; empty sets match everything
+type eventFilter struct {
+	types         map[string]bool
+	hosts         map[string]bool
+	hostGroups    map[string]bool
+	serviceGroups map[string]bool
+}
+
+// subscriber is a connected /v1/events client
+type subscriber struct {
+	filter eventFilter
+	ch     chan streamEvent
+	closed bool // Set when the subscriber is dropped, guarded by eventsMu
+}
+
+// The most recent events, kept for clients resuming with Last-Event-ID, and the subscribers
+var (
+	streamRing  []streamEvent
+	streamNext  int                              // Slot of the next event once the ring is full
+	streamSeq   = uint64(time.Now().UnixMicro()) // IDs of a new process start above those of the previous one
+	subscribers = make(map[*subscriber]bool)
+	eventsMu    sync.Mutex
+)
+
+// publishEvent numbers an event, stores it in the ring and hands it to the matching subscribers.
+// A subscriber too slow to keep up is disconnected; it resumes from the ring when it reconnects.
+func publishEvent(e streamEvent) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	streamSeq++
+	e.ID, e.Time = streamSeq, time.Now()
+	if len(streamRing) < appConfig.EventBufferSize {
+		streamRing = append(streamRing, e)
+	} else {
+		streamRing[streamNext] = e
+		streamNext = (streamNext + 1) % len(streamRing)
+	}
+
+	for sub := range subscribers {
+		if !sub.filter.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			logger.Info("[WARNING] Event subscriber too slow, disconnecting it at event %d", e.ID)
+			dropSubscriber(sub)
+		}
+	}
+}
+
+// dropSubscriber ends the stream of a subscriber, must be called under eventsMu
+func dropSubscriber(sub *subscriber) {
+	if !sub.closed {
+		sub.closed = true
+		delete(subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// closeEventStreams ends every stream so the HTTP server can shut down
+func closeEventStreams() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for sub := range subscribers {
+		dropSubscriber(sub)
+	}
+}
+
+// objectEvent describes the current state of a host or service, must be called under mu
+func objectEvent(t, entityType, id string) (streamEvent, bool) {
+	e := streamEvent{Type: t, EntityType: entityType}
+	if entityType == "HOST" {
+		h, ok := hosts[id]
+		if !ok {
+			return e, false
+		}
+		e.HostName, e.hostGroups = h.ID, h.HostGroups
+		e.State, e.StateName, e.StateType, e.Attempt, e.Output = h.CurrentState, hostStateName(h.CurrentState), h.StateType, h.Attempts, h.Output
+		return e, true
+	}
+	s, ok := services[id]
+	if !ok {
+		return e, false
+	}
+	e.HostName, e.ServiceID, e.serviceGroups = s.HostName, s.ID, s.ServiceGroups
+	if h, ok := hosts[s.HostName]; ok {
+		e.hostGroups = h.HostGroups
+	}
+	e.State, e.StateName, e.StateType, e.Attempt, e.Output = s.CurrentState, serviceStateName(s.CurrentState), s.StateType, s.Attempts, s.Output
+	return e, true
+}
+
+// publishResult publishes the state of an object after a check result, must be called under mu
+func publishResult(entityType, id string) {
+	if e, ok := objectEvent(eventResult, entityType, id); ok {
+		publishEvent(e)
+	}
+}
+
+// publishStateChange publishes a state or state type change, must be called under mu
+func publishStateChange(entityType, id, previousState string) {
+	if e, ok := objectEvent(eventStateChange, entityType, id); ok {
+		e.PreviousStateName = previousState
+		publishEvent(e)
+	}
+}
+
+// publishObjectEvent publishes a downtime or flapping event, must be called under mu
+func publishObjectEvent(t, entityType, id, author, comment string) {
+	if e, ok := objectEvent(t, entityType, id); ok {
+		e.Author, e.Comment = author, comment
+		publishEvent(e)
+	}
+}
+
+// publishAcknowledgement publishes an acknowledgement set or cleared, must be called under mu
+func publishAcknowledgement(entityType, id string, acked bool) {
+	if e, ok := objectEvent(eventAcknowledgement, entityType, id); ok {
+		e.Acknowledged = &acked
+		publishEvent(e)
+	}
+}
+
+// publishNotification publishes a notification sent to the Reactionner, must be called under mu
+func publishNotification(n models.NotificationRequest) {
+	entityType, id := "HOST", n.HostName
+	if n.ServiceID != "" {
+		entityType, id = "SERVICE", models.ServiceKey(n.HostName, n.ServiceID)
+	}
+	e, ok := objectEvent(eventNotification, entityType, id)
+	if !ok {
+		return
+	}
+	e.NotificationType, e.Output, e.Author, e.Comment = n.Type, n.Output, n.Author, n.Comment
+	for _, c := range n.Contacts {
+		e.Contacts = append(e.Contacts, c.ID)
+	}
+	publishEvent(e)
+}
+
+// match reports whether an event passes the filter
+func (f eventFilter) match(e streamEvent) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	if len(f.hosts) > 0 && !f.hosts[e.HostName] {
+		return false
+	}
+	if len(f.hostGroups) > 0 && !anyIn(e.hostGroups, f.hostGroups) {
+		return false
+	}
+	if len(f.serviceGroups) > 0 && !anyIn(e.serviceGroups, f.serviceGroups) {
+		return false
+	}
+	return true
+}
+
+// anyIn reports whether one of the items belongs to the set
+func anyIn(items []string, set map[string]bool) bool {
+	for _, item := range items {
+		if set[item] {
+			return true
+		}
+	}
+	return false
+}
+
+// listParam reads a comma separated query parameter as a set
+func listParam(r *http.Request, name string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(r.URL.Query().Get(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// eventsHandler streams events as Server-Sent Events.
+//
+//	GET /v1/events?type=state_change,notification&host=srv1&hostgroup=web&servicegroup=db
+//
+// A client reconnecting with a Last-Event-ID header (or last_event_id parameter)
+// first receives the buffered events it missed. When they are no longer in the
+// ring, or the ID comes from another process, a "reset" event tells it to reload
+// the current state from /v1/status before following the live events.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	filter := eventFilter{
+		types:         listParam(r, "type"),
+		hosts:         listParam(r, "host"),
+		hostGroups:    listParam(r, "hostgroup"),
+		serviceGroups: listParam(r, "servicegroup"),
+	}
+	for t := range filter.types {
+		switch t {
+		case eventResult, eventStateChange, eventNotification, eventDowntimeStart, eventDowntimeEnd,
+			eventAcknowledgement, eventFlappingStart, eventFlappingStop:
+		default:
+			http.Error(w, "Unknown event type: "+t, http.StatusBadRequest)
+			return
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var resume uint64
+	if lastID != "" {
+		var err error
+		if resume, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, "Invalid last event ID: "+lastID, http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// The backlog is taken with the registration so no event falls in between
+	sub := &subscriber{filter: filter, ch: make(chan streamEvent, 256)}
+	eventsMu.Lock()
+	var backlog []streamEvent
+	reset := false
+	if lastID != "" {
+		ring := append(append([]streamEvent(nil), streamRing[streamNext:]...), streamRing[:streamNext]...)
+		missed := resume < streamSeq && (len(ring) == 0 || resume+1 < ring[0].ID)
+		reset = missed || resume > streamSeq
+		for _, e := range ring {
+			if !reset && e.ID > resume && filter.match(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+	subscribers[sub] = true
+	eventsMu.Unlock()
+	defer func() {
+		eventsMu.Lock()
+		dropSubscriber(sub)
+		eventsMu.Unlock()
+	}()
+
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range backlog {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes an event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, e streamEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// eventRing replaces the event ring by one of the given size and restores it once the test ends.
+// It returns the sequence number the IDs of the published events start after.
+func eventRing(t *testing.T, size int) uint64 {
+	saveInventory(t)
+	ring, next, seq, subs := streamRing, streamNext, streamSeq, subscribers
+	t.Cleanup(func() {
+		eventsMu.Lock()
+		streamRing, streamNext, streamSeq, subscribers = ring, next, seq, subs
+		eventsMu.Unlock()
+	})
+	appConfig.EventBufferSize = size
+	streamRing, streamNext, subscribers = nil, 0, make(map[*subscriber]bool)
+	return streamSeq
+}
+
+// streamEvents connects to /v1/events and returns what was sent before the client
+// went away: "reset" and the IDs of the events, relative to base
+func streamEvents(target, lastID string, base uint64) (int, string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // The backlog is written before the handler notices the client is gone
+	r := httptest.NewRequest("GET", target, nil).WithContext(ctx)
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	w := httptest.NewRecorder()
+	eventsHandler(w, r)
+
+	var sent []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		switch {
+		case line == "event: reset":
+			sent = append(sent, "reset")
+		case strings.HasPrefix(line, "id: "):
+			id, _ := strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			sent = append(sent, strconv.FormatUint(id-base, 10))
+		}
+	}
+	return w.Code, strings.Join(sent, ",")
+}
+
+func TestEventsResume(t *testing.T) {
+	base := eventRing(t, 3)
+	// Events 1 to 5, alternately on web1 and web2: the ring keeps 3 to 5
+	for i := 1; i <= 5; i++ {
+		publishEvent(streamEvent{Type: eventResult, EntityType: "HOST", HostName: fmt.Sprintf("web%d", 2-i%2)})
+	}
+	id := func(n int) string { return strconv.FormatUint(base+uint64(n), 10) }
+
+	tests := []struct {
+		name   string
+		target string
+		lastID string
+		want   string
+	}{
+		{"no last event ID", "/v1/events", "", ""},
+		{"events after the last one", "/v1/events", id(3), "4,5"},
+		{"last event just before the ring", "/v1/events", id(2), "3,4,5"},
+		{"up to date", "/v1/events", id(5), ""},
+		{"missed events", "/v1/events", id(1), "reset"},
+		{"ID of a previous process", "/v1/events", "1", "reset"},
+		{"ID from the future", "/v1/events", id(9), "reset"},
+		{"filtered backlog", "/v1/events?host=web1", id(2), "3,5"},
+		{"filtered by type", "/v1/events?type=state_change", id(2), ""},
+		{"query parameter", "/v1/events?last_event_id=" + id(4), "", "5"},
+		{"header wins over the parameter", "/v1/events?last_event_id=" + id(4), id(3), "4,5"},
+	}
+	for _, tt := range tests {
+		code, got := streamEvents(tt.target, tt.lastID, base)
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, code, http.StatusOK)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if len(subscribers) != 0 {
+		t.Errorf("%d subscribers left after the clients went away", len(subscribers))
+	}
+}
+
+func TestEventsResumeEmptyRing(t *testing.T) {
+	base := eventRing(t, 3)
+	tests := []struct {
+		lastID string
+		want   string
+	}{
+		{strconv.FormatUint(base, 10), ""},
+		{strconv.FormatUint(base-1, 10), "reset"},
+		{strconv.FormatUint(base+1, 10), "reset"},
+	}
+	for _, tt := range tests {
+		if _, got := streamEvents("/v1/events", tt.lastID, base); got != tt.want {
+			t.Errorf("Last-Event-ID %s: got %q, want %q", tt.lastID, got, tt.want)
+		}
+	}
+}
+
+func TestEventsBadRequests(t *testing.T) {
+	base := eventRing(t, 3)
+	tests := []struct {
+		target string
+		lastID string
+	}{
+		{"/v1/events", "abc"},
+		{"/v1/events", "-1"},
+		{"/v1/events?last_event_id=1.5", ""},
+		{"/v1/events?type=ack", ""},
+	}
+	for _, tt := range tests {
+		if code, _ := streamEvents(tt.target, tt.lastID, base); code != http.StatusBadRequest {
+			t.Errorf("%s %q: got status %d, want %d", tt.target, tt.lastID, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAcknowledgementEvents(t *testing.T) {
+	base := eventRing(t, 10)
+	pairState(t)
+	pairInventory("web1")
+	publishEvent(streamEvent{Type: eventResult, EntityType: "HOST", HostName: "web1"})
+	applyAcknowledgements(map[string]bool{"web1/http": true})
+	applyAcknowledgements(map[string]bool{})
+
+	if _, got := streamEvents("/v1/events?type=acknowledgement", strconv.FormatUint(base, 10), base); got != "2,3" {
+		t.Fatalf("got acknowledgement events %q, want %q", got, "2,3")
+	}
+	for i, want := range []bool{true, false} {
+		e := streamRing[i+1]
+		if e.Type != eventAcknowledgement || e.ServiceID != "http" || e.Acknowledged == nil || *e.Acknowledged != want {
+			t.Errorf("event %d: got %s %s/%s acknowledged %v, want acknowledgement web1/http %v",
+				e.ID-base, e.Type, e.HostName, e.ServiceID, e.Acknowledged, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// flapResult describes the outcome of a flap detection update
+type flapResult int
+
+const (
+	flapUnchanged flapResult = iota
+	flapStarted
+	flapStopped
+)
+
+// updateFlapping records a new state in the rolling history of an object and
+// re-evaluates its flapping status using the Nagios weighted percent state change:
+// recent transitions weigh 1.2, the oldest 0.8. A low/high threshold pair provides
+// hysteresis so that an object does not toggle in and out of flapping.
+//
+// The history holds one state per regular check, as in Nagios: a result is recorded
+// when its state differs from the last recorded one, or once 'every' (the check
+// interval of the object) has passed since the last record. SOFT retries, forced
+// and passive results arriving in between only count when they change the state,
+// so short retry intervals do not dilute the percent state change.
+func updateFlapping(history *[]int, recorded *time.Time, pct *float64, flapping *bool, state int,
+	now time.Time, every time.Duration, enabled *bool, low, high float64) flapResult {
+	if !appConfig.FlapDetection || (enabled != nil && !*enabled) {
+		if *flapping {
+			*flapping = false
+			return flapStopped
+		}
+		return flapUnchanged
+	}
+
+	h := *history
+	if len(h) > 0 && h[len(h)-1] == state && now.Sub(*recorded) < every {
+		return flapUnchanged
+	}
+	h = append(h, state)
+	if len(h) > appConfig.FlapHistorySize {
+		h = h[len(h)-appConfig.FlapHistorySize:]
+	}
+	*history, *recorded = h, now
+	*pct = percentStateChange(h)
+
+	if low <= 0 {
+		low = appConfig.LowFlapThreshold
+	}
+	if high <= 0 {
+		high = appConfig.HighFlapThreshold
+	}
+
+	switch {
+	case !*flapping && *pct >= high:
+		*flapping = true
+		return flapStarted
+	case *flapping && *pct < low:
+		*flapping = false
+		return flapStopped
+	}
+	return flapUnchanged
+}
+
+// percentStateChange computes the weighted share of transitions in a state history
+func percentStateChange(history []int) float64 {
+	transitions := len(history) - 1
+	if transitions < 1 {
+		return 0
+	}
+	changes := 0.0
+	for i := 1; i < len(history); i++ {
+		if history[i] != history[i-1] {
+			weight := 0.8
+			if transitions > 1 {
+				weight += 0.4 * float64(i-1) / float64(transitions-1)
+			}
+			changes += weight
+		}
+	}
+	return changes / float64(transitions) * 100
+}
+
+// notifyFlapping records the start or end of a flapping period and sends
+// FLAPPINGSTART/FLAPPINGSTOP instead of the individual state change alerts
+func notifyFlapping(entityType, id string, res flapResult, pct float64, output string, muted bool) {
+	if res == flapUnchanged {
+		return
+	}
+	t := models.NotificationFlappingStart
+	if res == flapStopped {
+		t = models.NotificationFlappingStop
+	}
+	logEvent(entityType, id, t, fmt.Sprintf("%.1f%% state change", pct))
+	if res == flapStopped {
+		publishObjectEvent(eventFlappingStop, entityType, id, "", "")
+	} else {
+		publishObjectEvent(eventFlappingStart, entityType, id, "", "")
+	}
+	if n, ok := objectNotification(entityType, id, t, output); ok && !muted {
+		notifyReactionner(n)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// states returns a history made of the given runs of states
+func states(runs ...[]int) []int {
+	var h []int
+	for _, r := range runs {
+		h = append(h, r...)
+	}
+	return h
+}
+
+// repeat returns n times the given state
+func repeat(state, n int) []int {
+	h := make([]int, n)
+	for i := range h {
+		h[i] = state
+	}
+	return h
+}
+
+func TestPercentStateChange(t *testing.T) {
+	tests := []struct {
+		history []int
+		want    float64
+	}{
+		{nil, 0},
+		{[]int{2}, 0},
+		{[]int{0, 0, 0}, 0},
+		{[]int{0, 2}, 80},     // A single transition weighs 0.8
+		{[]int{0, 0, 2}, 60},  // The most recent transition weighs 1.2
+		{[]int{0, 2, 2}, 40},  // The oldest transition weighs 0.8
+		{[]int{0, 2, 0}, 100}, // 0.8 + 1.2 over 2 transitions
+		{states(repeat(0, 20), []int{2}), 6},
+		{states([]int{2, 0, 2, 0, 2, 0, 2}, repeat(0, 14)), 30.21},
+		{states(repeat(0, 17), []int{2, 0, 2, 0}), 23.37},
+		{[]int{0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0}, 100},
+	}
+	for _, tt := range tests {
+		if got := percentStateChange(tt.history); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("percentStateChange(%v) = %.2f, want %.2f", tt.history, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateFlappingThresholds(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	appConfig.FlapDetection = true
+	off := false
+	tests := []struct {
+		name         string
+		history      []int // 20 states, the result adds the 21st
+		flapping     bool
+		state        int
+		enabled      *bool
+		low, high    float64
+		want         flapResult
+		wantFlapping bool
+	}{
+		{"starts above the high threshold", states([]int{2, 0, 2, 0, 2, 0, 2}, repeat(0, 13)), false, 0, nil, 0, 0, flapStarted, true},
+		{"keeps flapping between thresholds", states(repeat(0, 17), []int{2, 0, 2}), true, 0, nil, 0, 0, flapUnchanged, true},
+		{"does not start between thresholds", states(repeat(0, 17), []int{2, 0, 2}), false, 0, nil, 0, 0, flapUnchanged, false},
+		{"stops below the low threshold", repeat(0, 20), true, 0, nil, 0, 0, flapStopped, false},
+		{"object thresholds", states([]int{2, 0, 2, 0, 2, 0, 2}, repeat(0, 13)), false, 0, nil, 50, 90, flapUnchanged, false},
+		{"object thresholds start", states(repeat(0, 10), []int{2, 0, 2, 0, 2, 0, 2, 0, 2, 0}), false, 2, nil, 50, 60, flapStarted, true},
+		{"disabled object stops flapping", states(repeat(0, 10), []int{2, 0, 2, 0, 2, 0, 2, 0, 2, 0}), true, 2, &off, 0, 0, flapStopped, false},
+	}
+	for _, tt := range tests {
+		history, flapping := append([]int(nil), tt.history...), tt.flapping
+		var recorded time.Time
+		var pct float64
+		got := updateFlapping(&history, &recorded, &pct, &flapping, tt.state, time.Now(), 0, tt.enabled, tt.low, tt.high)
+		if got != tt.want || flapping != tt.wantFlapping {
+			t.Errorf("%s: got %v flapping=%v (%.1f%%), want %v flapping=%v", tt.name, got, flapping, pct, tt.want, tt.wantFlapping)
+		}
+	}
+}
+
+func TestUpdateFlappingRecordsOneStatePerInterval(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	appConfig.FlapDetection = true
+
+	var history []int
+	var recorded time.Time
+	var pct float64
+	var flapping bool
+	t0 := time.Now()
+	steps := []struct {
+		after time.Duration
+		state int
+		want  string
+	}{
+		{0, 0, "[0]"},
+		{10 * time.Second, 0, "[0]"},   // Same state within the check interval
+		{20 * time.Second, 2, "[0 2]"}, // A state change is always recorded
+		{30 * time.Second, 2, "[0 2]"}, // SOFT retry confirming the state
+		{50 * time.Second, 2, "[0 2]"},
+		{80 * time.Second, 2, "[0 2 2]"}, // One check interval after the last record
+		{85 * time.Second, 0, "[0 2 2 0]"},
+	}
+	for _, st := range steps {
+		updateFlapping(&history, &recorded, &pct, &flapping, st.state, t0.Add(st.after), time.Minute, nil, 0, 0)
+		if got := fmt.Sprint(history); got != st.want {
+			t.Errorf("after %v: history = %s, want %s", st.after, got, st.want)
+		}
+	}
+	if !recorded.Equal(t0.Add(85 * time.Second)) {
+		t.Errorf("last record at %v, want %v", recorded.Sub(t0), 85*time.Second)
+	}
+}
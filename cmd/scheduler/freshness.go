@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+var (
+	// startTime is the reference for objects that never received a result
+	startTime = time.Now()
+	// freshnessPending marks queued task IDs that must run their freshness command, guarded by mu
+	freshnessPending = make(map[string]bool)
+)
+
+// enabled resolves an optional boolean setting that defaults to true
+func enabled(b *bool) bool {
+	return b == nil || *b
+}
+
+// overridden returns the runtime override of a setting when set, else its configured value
+func overridden(override, configured *bool) *bool {
+	if override != nil {
+		return override
+	}
+	return configured
+}
+
+// hostActive reports whether a host is actively checked by pollers
+func hostActive(h *models.Host) bool {
+	return h.CheckCommand != "" && enabled(overridden(h.Overrides.ActiveChecks, h.ActiveChecksEnabled))
+}
+
+// serviceActive reports whether a service is actively checked by pollers
+func serviceActive(s *models.Service) bool {
+	return s.CheckCommand != "" && enabled(overridden(s.Overrides.ActiveChecks, s.ActiveChecksEnabled))
+}
+
+// startFreshnessChecker periodically looks for objects whose last result is too old
+func startFreshnessChecker() {
+	ticker := time.NewTicker(time.Duration(appConfig.FreshnessCheckInterval) * time.Second)
+	for range ticker.C {
+		mu.Lock()
+		if serving() {
+			checkFreshness(time.Now())
+		}
+		mu.Unlock()
+	}
+}
+
+// checkFreshness handles every stale object, must be called under mu.
+// A stale object runs its freshness command (or its check command) on the next
+// poller request; without any command it is forced into its stale state.
+func checkFreshness(now time.Time) {
+	for _, h := range hosts {
+		if !h.CheckFreshness || freshnessPending["HOST:"+h.ID] || !periods.In(h.CheckPeriod, now) {
+			continue
+		}
+		age, stale := isStale(h.LastCheck, h.FreshnessThreshold, checkDelay(h.CheckInterval, h.NormalInterval), now)
+		if !stale {
+			continue
+		}
+		key := "HOST:" + h.ID
+		if h.FreshnessCommand != "" || h.CheckCommand != "" {
+			logger.Info("Host %s is stale (%s), forcing a freshness check", h.ID, age)
+			freshnessPending[key] = true
+			checks.schedule(key, now.Add(-time.Millisecond))
+			continue
+		}
+		handleHostResult(staleResult(key, h.StaleState, age))
+		markHost(h.ID)
+	}
+	for _, s := range services {
+		if !s.CheckFreshness || freshnessPending[s.Key()] || !periods.In(s.CheckPeriod, now) {
+			continue
+		}
+		age, stale := isStale(s.LastCheck, s.FreshnessThreshold, checkDelay(s.CheckInterval, s.NormalInterval), now)
+		if !stale {
+			continue
+		}
+		if s.FreshnessCommand != "" || s.CheckCommand != "" {
+			logger.Info("Service %s is stale (%s), forcing a freshness check", s.Key(), age)
+			freshnessPending[s.Key()] = true
+			checks.schedule(s.Key(), now.Add(-time.Millisecond))
+			continue
+		}
+		handleServiceResult(staleResult(s.Key(), s.StaleState, age))
+		markService(s.Key())
+	}
+}
+
+// isStale compares the age of the last result with the freshness threshold.
+// Without an explicit threshold, a result older than twice the check interval is stale.
+func isStale(lastCheck time.Time, threshold int, interval time.Duration, now time.Time) (time.Duration, bool) {
+	if lastCheck.Before(startTime) {
+		lastCheck = startTime
+	}
+	limit := 2 * interval
+	if threshold > 0 {
+		limit = intervalDuration(threshold)
+	}
+	age := now.Sub(lastCheck).Truncate(time.Second)
+	return age, age > limit
+}
+
+// staleResult builds the result forced on an object without a freshness command
+func staleResult(id string, state int, age time.Duration) models.CheckResult {
+	if state == 0 {
+		state = 3 // UNKNOWN
+	}
+	return models.CheckResult{
+		ID:     id,
+		Status: state,
+		Output: fmt.Sprintf("Result is stale: no result received for %s", age),
+	}
+}
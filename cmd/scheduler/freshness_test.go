@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+func TestPassiveResultHandler(t *testing.T) {
+	pairState(t)
+	q := resultQueue
+	t.Cleanup(func() { resultQueue = q })
+	pairInventory("web1", "web2")
+	no, yes := false, true
+	services["web2/http"].PassiveChecksEnabled = &no
+	hosts["web2"].PassiveChecksEnabled = &no
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		setup  func()
+		status int
+		queued string // ID of the queued result
+	}{
+		{name: "service", body: `{"host_name":"web1","service_id":"http","status":2,"output":"CRITICAL"}`, status: http.StatusAccepted, queued: "web1/http"},
+		{name: "host", body: `{"host_name":"web1","status":1,"output":"DOWN"}`, status: http.StatusAccepted, queued: "HOST:web1"},
+		{name: "passive checks disabled", body: `{"host_name":"web2","service_id":"http","status":0}`, status: http.StatusForbidden},
+		{name: "passive host checks disabled", body: `{"host_name":"web2","status":0}`, status: http.StatusForbidden},
+		{name: "enabled by an override", body: `{"host_name":"web2","service_id":"http","status":0}`, status: http.StatusAccepted, queued: "web2/http",
+			setup: func() { services["web2/http"].Overrides.PassiveChecks = &yes }},
+		{name: "unknown service", body: `{"host_name":"web1","service_id":"nope","status":0}`, status: http.StatusNotFound},
+		{name: "unknown host", body: `{"host_name":"nope","status":0}`, status: http.StatusNotFound},
+		{name: "bad json", body: `{`, status: http.StatusBadRequest},
+		{name: "not a POST", method: "GET", status: http.StatusMethodNotAllowed},
+		{name: "full result queue", body: `{"host_name":"web1","status":0}`, status: http.StatusServiceUnavailable,
+			setup: func() { resultQueue = make(chan models.CheckResult) }},
+		{name: "spare in standby", body: `{"host_name":"web1","status":0}`, status: http.StatusServiceUnavailable,
+			setup: func() { standby = true }},
+	}
+	for _, tt := range tests {
+		resultQueue = make(chan models.CheckResult, 1)
+		if tt.setup != nil {
+			tt.setup()
+		}
+		method := tt.method
+		if method == "" {
+			method = "POST"
+		}
+		w := httptest.NewRecorder()
+		passiveResultHandler(w, httptest.NewRequest(method, "/v1/passive-result", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		select {
+		case res := <-resultQueue:
+			if res.ID != tt.queued || !res.Passive || res.Seq != 0 {
+				t.Errorf("%s: queued %+v, want a passive result for %q", tt.name, res, tt.queued)
+			}
+		default:
+			if tt.queued != "" {
+				t.Errorf("%s: nothing queued, want a result for %s", tt.name, tt.queued)
+			}
+		}
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	pairState(t)
+	defaultConfig(t, "seconds")
+	now := time.Now().Add(time.Hour)
+	services = make(map[string]*models.Service)
+	for _, s := range []*models.Service{
+		{ID: "fresh", LastCheck: now.Add(-30 * time.Second)},
+		{ID: "stale", LastCheck: now.Add(-90 * time.Second)},
+		{ID: "stale-command", LastCheck: now.Add(-90 * time.Second), CheckCommand: "check_http"},
+		{ID: "never-checked"},
+		{ID: "not-checked", LastCheck: now.Add(-90 * time.Second), CheckFreshness: false},
+		{ID: "passive-only", LastCheck: now.Add(-90 * time.Second), StaleState: 2},
+		{ID: "default-threshold", LastCheck: now.Add(-90 * time.Second), CheckInterval: 60},
+	} {
+		s.HostName = "web1"
+		s.CheckFreshness = s.ID != "not-checked"
+		if s.CheckInterval == 0 {
+			s.FreshnessThreshold = 60
+		}
+		if s.ID == "stale" || s.ID == "never-checked" {
+			s.FreshnessCommand = "check_dummy"
+		}
+		services[s.Key()] = s
+	}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	rebuildQueue()
+
+	checkFreshness(now)
+
+	// Stale objects with a command run it on the next poller request
+	for key, want := range map[string]bool{
+		"web1/fresh": false, "web1/stale": true, "web1/stale-command": true, "web1/never-checked": true,
+		"web1/not-checked": false, "web1/passive-only": false, "web1/default-threshold": false,
+	} {
+		if freshnessPending[key] != want {
+			t.Errorf("%s: freshness check pending = %v, want %v", key, freshnessPending[key], want)
+		}
+		if at, ok := queuedAt(checks, key); want && (!ok || at.After(now)) {
+			t.Errorf("%s: due at %v (%v), want at once", key, at, ok)
+		}
+	}
+	task, kind, ok := nextTask(now)
+	if !ok || kind != freshnessCheck || !strings.HasPrefix(task.ID, "web1/") {
+		t.Errorf("got task %+v (kind %d), want a freshness check", task, kind)
+	}
+
+	// Without a command the object is forced into its stale state
+	if s := services["web1/passive-only"]; s.CurrentState != 2 || !strings.HasPrefix(s.Output, "Result is stale") {
+		t.Errorf("passive-only: state %d %q, want the CRITICAL stale state", s.CurrentState, s.Output)
+	}
+	// Twice the 60s check interval is not reached yet
+	if s := services["web1/default-threshold"]; s.CurrentState != 0 {
+		t.Errorf("default-threshold: state %d, want untouched", s.CurrentState)
+	}
+}
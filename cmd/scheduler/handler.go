@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"shinsakuto/pkg/logger"
@@ -22,14 +24,19 @@ func syncAllHandler(w http.ResponseWriter, r *http.Request) {
 	mu.Lock()
 	defer mu.Unlock()
 
+	loadCommands(cfg)
+	loadDependencies(cfg)
+	loadEscalations(cfg)
+
 	// Rebuild Hosts while preserving state
 	newHosts := make(map[string]*models.Host)
 	for _, h := range cfg.Hosts {
 		hCopy := h
 		if old, exists := hosts[h.ID]; exists {
-			hCopy.IsUp, hCopy.Status, hCopy.NextCheck = old.IsUp, old.Status, old.NextCheck
+			copyHostRuntime(&hCopy, old)
 		} else {
 			hCopy.IsUp, hCopy.NextCheck = true, time.Now()
+			hCopy.StateType, hCopy.Attempts = models.StateTypeHard, 1
 		}
 		newHosts[h.ID] = &hCopy
 	}
@@ -39,43 +46,211 @@ func syncAllHandler(w http.ResponseWriter, r *http.Request) {
 	newServices := make(map[string]*models.Service)
 	for _, s := range cfg.Services {
 		sCopy := s
-		if old, exists := services[s.ID]; exists {
-			sCopy.NextCheck, sCopy.CurrentState = old.NextCheck, old.CurrentState
+		if old, exists := services[s.Key()]; exists {
+			copyServiceRuntime(&sCopy, old)
 		} else {
 			sCopy.NextCheck = time.Now()
+			sCopy.StateType, sCopy.Attempts = models.StateTypeHard, 1
 		}
-		newServices[s.ID] = &sCopy
+		newServices[s.Key()] = &sCopy
 	}
 	services = newServices
+	rebuildQueue()
+
+	// New or cancelled downtimes apply immediately
+	loadDowntimes(cfg.Downtimes)
+	evaluateDowntimes(time.Now())
 
-	stateChanged = true
+	markAll()
 	logger.Info("SyncAll successful: %d hosts, %d services", len(hosts), len(services))
 	w.WriteHeader(http.StatusOK)
 }
 
-// popTaskHandler serves the next task reaching its check interval
+// copyHostRuntime carries the runtime state of a host over a configuration reload
+func copyHostRuntime(dst, old *models.Host) {
+	dst.IsUp, dst.Status, dst.CurrentState, dst.NextCheck = old.IsUp, old.Status, old.CurrentState, old.NextCheck
+	dst.StateType, dst.Attempts, dst.Output = old.StateType, old.Attempts, old.Output
+	dst.StateHistory, dst.IsFlapping, dst.PercentStateChange = old.StateHistory, old.IsFlapping, old.PercentStateChange
+	dst.StateRecorded = old.StateRecorded
+	dst.LastCheck, dst.InDowntime = old.LastCheck, old.InDowntime
+	dst.NotificationNumber, dst.LastNotification, dst.NotifiedSince = old.NotificationNumber, old.LastNotification, old.NotifiedSince
+	dst.Latency, dst.ExecutionTime, dst.LastPoller = old.Latency, old.ExecutionTime, old.LastPoller
+	dst.LongOutput, dst.Perfdata, dst.Acknowledged = old.LongOutput, old.Perfdata, old.Acknowledged
+	dst.Overrides = old.Overrides
+}
+
+// copyServiceRuntime carries the runtime state of a service over a configuration reload
+func copyServiceRuntime(dst, old *models.Service) {
+	dst.CurrentState, dst.NextCheck = old.CurrentState, old.NextCheck
+	dst.StateType, dst.Attempts, dst.Output = old.StateType, old.Attempts, old.Output
+	dst.StateHistory, dst.IsFlapping, dst.PercentStateChange = old.StateHistory, old.IsFlapping, old.PercentStateChange
+	dst.StateRecorded = old.StateRecorded
+	dst.LastCheck, dst.InDowntime = old.LastCheck, old.InDowntime
+	dst.NotificationNumber, dst.LastNotification, dst.NotifiedSince = old.NotificationNumber, old.LastNotification, old.NotifiedSince
+	dst.Latency, dst.ExecutionTime, dst.LastPoller = old.Latency, old.ExecutionTime, old.LastPoller
+	dst.LongOutput, dst.Perfdata, dst.Acknowledged = old.LongOutput, old.Perfdata, old.Acknowledged
+	dst.Overrides = old.Overrides
+}
+
+// popTaskHandler serves the most overdue task from the check queue
 func popTaskHandler(w http.ResponseWriter, r *http.Request) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	now := time.Now()
-	// Prioritize Host checks
-	for _, h := range hosts {
-		if h.CheckCommand != "" && now.After(h.NextCheck) {
-			h.NextCheck = now.Add(2 * time.Minute) 
-			json.NewEncoder(w).Encode(models.CheckTask{ID: "HOST:" + h.ID, Command: h.CheckCommand})
-			return
+	task, kind, ok := nextTask(now)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	trackTask(&task, kind, r.URL.Query().Get("poller"), now)
+	json.NewEncoder(w).Encode(task)
+}
+
+// popTasksHandler serves up to 'max' due tasks in a single round trip
+func popTasksHandler(w http.ResponseWriter, r *http.Request) {
+	max, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil || max < 1 {
+		max = 1
+	}
+	if max > maxBatchSize {
+		max = maxBatchSize
+	}
+
+	mu.Lock()
+	now := time.Now()
+	tasks := make([]models.CheckTask, 0, max)
+	for len(tasks) < max {
+		task, kind, ok := nextTask(now)
+		if !ok {
+			break
 		}
+		trackTask(&task, kind, r.URL.Query().Get("poller"), now)
+		tasks = append(tasks, task)
 	}
-	// Service checks
-	for _, s := range services {
-		if s.CheckCommand != "" && now.After(s.NextCheck) {
-			s.NextCheck = now.Add(1 * time.Minute)
-			json.NewEncoder(w).Encode(models.CheckTask{ID: s.ID, Command: s.CheckCommand})
-			return
+	mu.Unlock()
+
+	if len(tasks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// nextTask pops the next event handler or due check and reschedules it. Must be called under mu.
+// Freshness checks run the freshness command (or the check command) once.
+func nextTask(now time.Time) (models.CheckTask, taskKind, bool) {
+	// A spare in standby leaves the shard to its primary
+	if !serving() {
+		return models.CheckTask{}, regularCheck, false
+	}
+	// Event handlers react to state changes and go before regular checks
+	if task, ok := popEvent(); ok {
+		return task, regularCheck, true
+	}
+	for {
+		key, ok := checks.popDue(now)
+		if !ok {
+			return models.CheckTask{}, regularCheck, false
+		}
+		freshness := freshnessPending[key]
+		delete(freshnessPending, key)
+		_, forced := forcedChecks[key]
+		delete(forcedChecks, key)
+		kind := regularCheck
+		if freshness {
+			kind = freshnessCheck
+		} else if forced {
+			kind = forcedCheck
+		}
+
+		if strings.HasPrefix(key, "HOST:") {
+			h, exists := hosts[strings.TrimPrefix(key, "HOST:")]
+			if !exists {
+				continue
+			}
+			inPeriod := periods.In(h.CheckPeriod, now)
+			due := h.NextCheck
+			if hostActive(h) {
+				h.NextCheck = alignToPeriod(h.CheckPeriod, now.Add(checkDelay(h.CheckInterval, h.NormalInterval)))
+				if !inPeriod {
+					h.NextCheck = alignToPeriod(h.CheckPeriod, now)
+				}
+				scheduleHost(h)
+			}
+			ref := checkRef(h.CheckCommand, h.FreshnessCommand, freshness)
+			if ref == "" {
+				// Passive-only object: an empty command would "succeed" and fake an UP result
+				logger.Info("Check of host %s skipped: no check_command", h.ID)
+				continue
+			}
+			switch {
+			case freshness: // Stale objects are checked whatever their settings
+			case forced:
+				logger.Info("Forced check of host %s", h.ID)
+			case !hostActive(h):
+				continue
+			case !inPeriod:
+				logger.Info("Check of host %s postponed to %s: outside check_period", h.ID, h.NextCheck.Format(time.RFC3339))
+				continue
+			case hostMasterFailing(h, hostExecutionCriteria):
+				// Execution dependency: skip this run while the master is failing
+				logger.Info("Check of host %s skipped: execution dependency failing", h.ID)
+				continue
+			}
+			if kind == regularCheck {
+				// Freshness and forced checks are not due at NextCheck
+				recordLatency(&h.Latency, due, now)
+			}
+			return models.CheckTask{ID: key, Command: resolveCommand(ref, hostMacros(h))}, kind, true
+		}
+
+		s, exists := services[key]
+		if !exists {
+			continue
 		}
+		inPeriod := periods.In(s.CheckPeriod, now)
+		due := s.NextCheck
+		if serviceActive(s) {
+			s.NextCheck = alignToPeriod(s.CheckPeriod, now.Add(checkDelay(s.CheckInterval, s.NormalInterval)))
+			if !inPeriod {
+				s.NextCheck = alignToPeriod(s.CheckPeriod, now)
+			}
+			scheduleService(s)
+		}
+		ref := checkRef(s.CheckCommand, s.FreshnessCommand, freshness)
+		if ref == "" {
+			logger.Info("Check of service %s skipped: no check_command", key)
+			continue
+		}
+		switch {
+		case freshness: // Stale objects are checked whatever their settings
+		case forced:
+			logger.Info("Forced check of service %s", key)
+		case !serviceActive(s):
+			continue
+		case !inPeriod:
+			logger.Info("Check of service %s postponed to %s: outside check_period", key, s.NextCheck.Format(time.RFC3339))
+			continue
+		case serviceMasterFailing(s, serviceExecutionCriteria):
+			logger.Info("Check of service %s skipped: execution dependency failing", key)
+			continue
+		}
+		if kind == regularCheck {
+			recordLatency(&s.Latency, due, now)
+		}
+		return models.CheckTask{ID: key, Command: resolveCommand(ref, serviceMacros(s))}, kind, true
 	}
-	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkRef returns the command run by a check, empty for an object with nothing to run.
+// Freshness checks prefer the freshness command, other checks fall back to it.
+func checkRef(checkCommand, freshnessCommand string, freshness bool) string {
+	if checkCommand == "" || (freshness && freshnessCommand != "") {
+		return freshnessCommand
+	}
+	return checkCommand
 }
 
 // pushResultHandler queues results asynchronously to prevent lock contention
@@ -85,12 +260,96 @@ func pushResultHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !enqueueResult(res) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted) // 202 Accepted: queued for processing
+}
+
+// pushResultsHandler queues a batch of results sent by a poller. The results the
+// full queue could not take are listed in the reply: the poller pushes only those
+// again, the others being already queued.
+func pushResultsHandler(w http.ResponseWriter, r *http.Request) {
+	var results []models.CheckResult
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		http.Error(w, "Bad JSON", 400)
+		return
+	}
+
+	var reply models.PushResultsReply
+	for i, res := range results {
+		if enqueueResult(res) {
+			reply.Accepted++
+		} else {
+			reply.Rejected = append(reply.Rejected, i)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(reply)
+}
+
+// passiveResultHandler accepts results pushed by external systems for objects
+// that allow passive checks. A missing service_id targets the host itself.
+func passiveResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var p models.PassiveResult
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Bad JSON", 400)
+		return
+	}
+
+	res := models.CheckResult{Status: p.Status, Output: p.Output, Passive: true}
+	mu.RLock()
+	active := serving()
+	var allowed, exists bool
+	if p.ServiceID == "" {
+		res.ID = "HOST:" + p.HostName
+		var h *models.Host
+		if h, exists = hosts[p.HostName]; exists {
+			allowed = enabled(overridden(h.Overrides.PassiveChecks, h.PassiveChecksEnabled))
+		}
+	} else {
+		res.ID = models.ServiceKey(p.HostName, p.ServiceID)
+		var s *models.Service
+		if s, exists = services[res.ID]; exists {
+			allowed = enabled(overridden(s.Overrides.PassiveChecks, s.PassiveChecksEnabled))
+		}
+	}
+	mu.RUnlock()
+
+	if !active {
+		http.Error(w, "Scheduler is in standby", http.StatusServiceUnavailable)
+		return
+	}
+	if !exists {
+		http.Error(w, "Unknown host or service", http.StatusNotFound)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Passive checks are disabled for "+res.ID, http.StatusForbidden)
+		return
+	}
+	if !enqueueResult(res) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	logger.Info("Passive result accepted for %s (status %d)", res.ID, res.Status)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueueResult hands a result to the worker pool, or reports that the queue is full
+func enqueueResult(res models.CheckResult) bool {
 	select {
 	case resultQueue <- res:
-		w.WriteHeader(http.StatusAccepted) // 202 Accepted: queued for processing
+		return true
 	default:
 		logger.Info("[WARNING] resultQueue full, dropping result for %s", res.ID)
-		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
 	}
 }
 
@@ -99,5 +358,14 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
 	defer mu.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"hosts": hosts, "services": services})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hosts":    hosts,
+		"services": services,
+		"tasks": map[string]interface{}{
+			"in_flight":    inFlight,
+			"orphans":      orphans,
+			"late_results": lateResults,
+		},
+		"replication": replicationStatus(),
+	})
 }
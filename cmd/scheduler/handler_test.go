@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveDispatch restores the in-flight tasks and the result queue after a test
+func saveDispatch(tb testing.TB) {
+	f, q := inFlight, resultQueue
+	tb.Cleanup(func() { inFlight, resultQueue = f, q })
+	inFlight = make(map[string]*inFlightTask)
+}
+
+func TestPopTasksHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		due    int // Overdue services, next to the 10 overdue hosts
+		query  string
+		status int
+		want   int
+	}{
+		{name: "max missing", due: 20, query: "", status: http.StatusOK, want: 1},
+		{name: "max invalid", due: 20, query: "max=ten", status: http.StatusOK, want: 1},
+		{name: "batch", due: 20, query: "max=5", status: http.StatusOK, want: 5},
+		{name: "fewer due than max", due: 20, query: "max=100", status: http.StatusOK, want: 30},
+		{name: "capped at maxBatchSize", due: 1000, query: "max=100000", status: http.StatusOK, want: maxBatchSize},
+	}
+	for _, tt := range tests {
+		populate(t, tt.due)
+		saveDispatch(t)
+		w := httptest.NewRecorder()
+		popTasksHandler(w, httptest.NewRequest("GET", "/v1/pop-tasks?poller=p1&"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		var tasks []models.CheckTask
+		if err := json.NewDecoder(w.Body).Decode(&tasks); err != nil {
+			t.Errorf("%s: bad reply: %v", tt.name, err)
+			continue
+		}
+		if len(tasks) != tt.want {
+			t.Errorf("%s: got %d tasks, want %d", tt.name, len(tasks), tt.want)
+		}
+		// Every task served is tracked for its poller
+		for _, task := range tasks {
+			if f := inFlight[task.ID]; f == nil || f.Seq != task.Seq || f.PollerID != "p1" {
+				t.Errorf("%s: task %s (seq %d) not tracked for p1: %+v", tt.name, task.ID, task.Seq, f)
+				break
+			}
+		}
+	}
+
+	// Nothing due
+	populate(t, 0)
+	saveDispatch(t)
+	hosts = make(map[string]*models.Host)
+	rebuildQueue()
+	w := httptest.NewRecorder()
+	popTasksHandler(w, httptest.NewRequest("GET", "/v1/pop-tasks?max=10", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("empty queue: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestPushResultsHandler(t *testing.T) {
+	batch := func(n int) string {
+		results := make([]models.CheckResult, n)
+		for i := range results {
+			results[i] = models.CheckResult{ID: fmt.Sprintf("web1/svc-%d", i), Seq: uint64(i + 1)}
+		}
+		body, _ := json.Marshal(results)
+		return string(body)
+	}
+	tests := []struct {
+		name     string
+		body     string
+		queued   int // Results already waiting in the queue of 5
+		status   int
+		accepted int
+		rejected []int
+	}{
+		{name: "all queued", body: batch(3), status: http.StatusAccepted, accepted: 3},
+		{name: "queue filling up", body: batch(4), queued: 3, status: http.StatusAccepted, accepted: 2, rejected: []int{2, 3}},
+		{name: "queue full", body: batch(2), queued: 5, status: http.StatusAccepted, accepted: 0, rejected: []int{0, 1}},
+		{name: "empty batch", body: "[]", status: http.StatusAccepted},
+		{name: "bad json", body: "{", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		saveDispatch(t)
+		resultQueue = make(chan models.CheckResult, 5)
+		for i := 0; i < tt.queued; i++ {
+			resultQueue <- models.CheckResult{}
+		}
+		w := httptest.NewRecorder()
+		pushResultsHandler(w, httptest.NewRequest("POST", "/v1/push-results", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusAccepted {
+			continue
+		}
+		var reply models.PushResultsReply
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Errorf("%s: bad reply: %v", tt.name, err)
+			continue
+		}
+		if reply.Accepted != tt.accepted || fmt.Sprint(reply.Rejected) != fmt.Sprint(tt.rejected) {
+			t.Errorf("%s: got %+v, want %d accepted, %v rejected", tt.name, reply, tt.accepted, tt.rejected)
+		}
+		if got := len(resultQueue); got != tt.queued+tt.accepted {
+			t.Errorf("%s: %d results queued, want %d", tt.name, got, tt.queued+tt.accepted)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// syncConfig posts a configuration to the sync handler
+func syncConfig(t *testing.T, cfg models.GlobalConfig) {
+	body, _ := json.Marshal(cfg)
+	w := httptest.NewRecorder()
+	syncAllHandler(w, httptest.NewRequest("POST", "/v1/sync-all", strings.NewReader(string(body))))
+	if w.Code != 200 {
+		t.Fatalf("sync: got status %d, want 200", w.Code)
+	}
+}
+
+// sharedServiceConfig has the same service ID on two hosts
+func sharedServiceConfig() models.GlobalConfig {
+	return models.GlobalConfig{
+		Hosts: []models.Host{{ID: "localhost", CheckCommand: "check_ping", CheckInterval: 300}, {ID: "srv1", CheckCommand: "check_ping", CheckInterval: 300}},
+		Services: []models.Service{
+			{ID: "HTTP_Check", HostName: "localhost", CheckCommand: "check_http -H $HOSTNAME$", MaxAttempts: 1, CheckInterval: 300},
+			{ID: "HTTP_Check", HostName: "srv1", CheckCommand: "check_http -H $HOSTNAME$", MaxAttempts: 1, CheckInterval: 300},
+		},
+	}
+}
+
+func TestSameServiceIDOnTwoHosts(t *testing.T) {
+	saveInventory(t)
+	saveDependencies(t)
+	defaultConfig(t, "seconds")
+	cmds, res := commands, resources
+	t.Cleanup(func() { commands, resources = cmds, res })
+	hosts, services = make(map[string]*models.Host), make(map[string]*models.Service)
+
+	// Both services survive the sync under their own key
+	syncConfig(t, sharedServiceConfig())
+	var keys []string
+	for key, s := range services {
+		if key != s.Key() {
+			t.Errorf("service %s stored as %s", s.Key(), key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if got := fmt.Sprint(keys); got != "[localhost/HTTP_Check srv1/HTTP_Check]" {
+		t.Fatalf("services after the sync: %s, want both hosts", got)
+	}
+
+	// Each task runs against its own host, and a result only changes the service it was run for
+	dispatched := make(map[string]string)
+	for {
+		task, _, ok := nextTask(time.Now())
+		if !ok {
+			break
+		}
+		dispatched[task.ID] = task.Command
+	}
+	for _, key := range keys {
+		if want := "check_http -H " + services[key].HostName; dispatched[key] != want {
+			t.Errorf("task %s: command %q, want %q", key, dispatched[key], want)
+		}
+	}
+	handleServiceResult(models.CheckResult{ID: "srv1/HTTP_Check", Status: 2, Output: "CRITICAL"})
+	if s := services["srv1/HTTP_Check"]; s.CurrentState != 2 {
+		t.Errorf("srv1/HTTP_Check: state %d, want CRITICAL", s.CurrentState)
+	}
+	if s := services["localhost/HTTP_Check"]; s.CurrentState != 0 || s.Output != "" {
+		t.Errorf("localhost/HTTP_Check: state %d %q, want untouched", s.CurrentState, s.Output)
+	}
+
+	// A new sync keeps the runtime state of each service apart
+	syncConfig(t, sharedServiceConfig())
+	if services["srv1/HTTP_Check"].CurrentState != 2 || services["localhost/HTTP_Check"].CurrentState != 0 {
+		t.Errorf("after a resync: srv1 %d, localhost %d, want CRITICAL and OK",
+			services["srv1/HTTP_Check"].CurrentState, services["localhost/HTTP_Check"].CurrentState)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// taskKind tells how a check was dispatched, so an orphan is re-queued the same way
+type taskKind int
+
+const (
+	regularCheck taskKind = iota
+	freshnessCheck
+	forcedCheck
+)
+
+// inFlightTask records a task handed to a poller and not yet answered
+type inFlightTask struct {
+	Seq        uint64    `json:"seq"`
+	PollerID   string    `json:"poller_id"`
+	Dispatched time.Time `json:"dispatched"`
+	Deadline   time.Time `json:"deadline"`
+	Kind       taskKind  `json:"kind,omitempty"`
+}
+
+// Dispatch tracking, guarded by mu
+var (
+	inFlight    = make(map[string]*inFlightTask)
+	orphans     = make(map[string]int)
+	lateResults int
+	// taskSeq starts from the clock so sequences never repeat across restarts
+	taskSeq = uint64(time.Now().UnixNano())
+	// firstSeq is the first sequence of this run: lower ones were dispatched before a restart
+	firstSeq = taskSeq + 1
+)
+
+// trackTask assigns a sequence number to a dispatched task and records its deadline.
+// A newer dispatch of the same check supersedes any previous one.
+func trackTask(task *models.CheckTask, kind taskKind, pollerID string, now time.Time) {
+	if pollerID == "" {
+		pollerID = "unknown"
+	}
+	taskSeq++
+	task.Seq = taskSeq
+	inFlight[task.ID] = &inFlightTask{
+		Seq:        task.Seq,
+		PollerID:   pollerID,
+		Dispatched: now,
+		Deadline:   now.Add(time.Duration(appConfig.TaskTimeout) * time.Second),
+		Kind:       kind,
+	}
+}
+
+// acceptResult matches a poller result with its in-flight task, must be called under mu.
+// Results without sequence (passive, stale) are always accepted; results of unknown
+// or superseded tasks are discarded. The in-flight tasks are not persisted: a result
+// dispatched before a restart is accepted unless its object has a newer result.
+func acceptResult(res models.CheckResult) bool {
+	if res.Seq == 0 {
+		return true
+	}
+	t, ok := inFlight[res.ID]
+	if !ok && res.Seq < firstSeq && !hasNewerResult(res) {
+		logger.Info("Accepting result for %s dispatched before the restart (seq %d)", res.ID, res.Seq)
+		return true
+	}
+	if !ok || t.Seq != res.Seq {
+		lateResults++
+		logger.Info("[WARNING] Discarding late result for %s (seq %d)", res.ID, res.Seq)
+		return false
+	}
+	delete(inFlight, res.ID)
+	return true
+}
+
+// hasNewerResult reports whether the object of a result was checked after the
+// result's check started. Event handlers and unknown objects have no result to compare.
+func hasNewerResult(res models.CheckResult) bool {
+	var last time.Time
+	if strings.HasPrefix(res.ID, "HOST:") {
+		h, ok := hosts[strings.TrimPrefix(res.ID, "HOST:")]
+		if !ok {
+			return true
+		}
+		last = h.LastCheck
+	} else if s, ok := services[res.ID]; ok {
+		last = s.LastCheck
+	} else {
+		return true
+	}
+	return last.After(res.StartTime)
+}
+
+// startOrphanReaper periodically re-queues tasks whose results never arrived
+func startOrphanReaper() {
+	ticker := time.NewTicker(5 * time.Second)
+	for range ticker.C {
+		mu.Lock()
+		reapOrphans(time.Now())
+		mu.Unlock()
+	}
+}
+
+// reapOrphans re-queues expired in-flight tasks and counts them per poller, must be called under mu
+func reapOrphans(now time.Time) {
+	for id, t := range inFlight {
+		if now.Before(t.Deadline) {
+			continue
+		}
+		delete(inFlight, id)
+		orphans[t.PollerID]++
+
+		// Event handlers are not retried: running a corrective action twice is worse than missing it
+		if ev, ok := eventTasks[id]; ok {
+			delete(eventTasks, id)
+			logEvent(ev.EntityType, ev.ObjectID, "EVENTHANDLER", ev.Command+" orphaned by poller "+t.PollerID)
+			continue
+		}
+		logger.Always("Task %s (seq %d) orphaned by poller %s, re-queuing", id, t.Seq, t.PollerID)
+		logEvent("TASK", id, "ORPHAN", "no result from poller "+t.PollerID)
+
+		// Freshness and forced checks run again as such, whatever the object settings
+		switch t.Kind {
+		case freshnessCheck:
+			freshnessPending[id] = true
+			checks.schedule(id, now)
+			continue
+		case forcedCheck:
+			forcedChecks[id] = now
+			checks.schedule(id, now)
+			continue
+		}
+		if strings.HasPrefix(id, "HOST:") {
+			if h, ok := hosts[strings.TrimPrefix(id, "HOST:")]; ok {
+				h.NextCheck = now
+				scheduleHost(h)
+			}
+		} else if s, ok := services[id]; ok {
+			s.NextCheck = now
+			scheduleService(s)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveCounters restores the orphan and late result counters after a test
+func saveCounters(t *testing.T) {
+	o, l := orphans, lateResults
+	t.Cleanup(func() { orphans, lateResults = o, l })
+	orphans, lateResults = make(map[string]int), 0
+}
+
+func TestReapOrphans(t *testing.T) {
+	pairState(t)
+	saveCounters(t)
+	pairInventory("web1", "web2", "web3", "web4")
+	now := time.Now()
+	later := now.Add(time.Hour)
+	for _, h := range hosts {
+		h.NextCheck = later
+	}
+	for _, s := range services {
+		s.NextCheck = later
+	}
+	rebuildQueue()
+	appConfig.TaskTimeout = 60
+
+	track := func(id string, kind taskKind, poller string, dispatched time.Time) {
+		task := models.CheckTask{ID: id}
+		trackTask(&task, kind, poller, dispatched)
+	}
+	track("HOST:web1", regularCheck, "p1", now.Add(-2*time.Minute))
+	track("web1/http", regularCheck, "p1", now.Add(-time.Minute)) // Deadline reached
+	track("web2/http", freshnessCheck, "p2", now.Add(-2*time.Minute))
+	track("web3/http", forcedCheck, "p2", now.Add(-2*time.Minute))
+	track("web4/http", regularCheck, "p1", now.Add(-30*time.Second)) // Still running
+	track("EVENT:1", regularCheck, "", now.Add(-2*time.Minute))
+	eventTasks = map[string]eventTask{"EVENT:1": {EntityType: "HOST", ObjectID: "web4", Command: "restart"}}
+
+	reapOrphans(now)
+
+	if len(inFlight) != 1 || inFlight["web4/http"] == nil {
+		t.Errorf("got %d tasks in flight, want only web4/http", len(inFlight))
+	}
+	if orphans["p1"] != 2 || orphans["p2"] != 2 || orphans["unknown"] != 1 {
+		t.Errorf("got orphans %v, want p1:2 p2:2 unknown:1", orphans)
+	}
+	// Regular checks are due again
+	for _, key := range []string{"HOST:web1", "web1/http"} {
+		if at, ok := checks.due(key); !ok || at.After(now) {
+			t.Errorf("%s: due at %v, want re-queued now", key, at)
+		}
+	}
+	if h := hosts["web1"]; !h.NextCheck.Equal(now) {
+		t.Errorf("web1: NextCheck %v, want now", h.NextCheck)
+	}
+	// Freshness and forced checks run again as such
+	if !freshnessPending["web2/http"] {
+		t.Error("web2/http: freshness check not pending again")
+	}
+	if _, ok := forcedChecks["web3/http"]; !ok {
+		t.Error("web3/http: forced check not pending again")
+	}
+	if at, _ := checks.due("web4/http"); !at.Equal(later) {
+		t.Errorf("web4/http: due at %v, want unchanged", at)
+	}
+	// Event handlers are not retried
+	if _, ok := eventTasks["EVENT:1"]; ok {
+		t.Error("orphaned event handler still pending")
+	}
+}
+
+func TestAcceptResult(t *testing.T) {
+	pairState(t)
+	saveCounters(t)
+	pairInventory("web1")
+	now := time.Now()
+	services["web1/http"].LastCheck = now.Add(-time.Minute)
+	hosts["web1"].LastCheck = now
+
+	task := models.CheckTask{ID: "web1/http"}
+	trackTask(&task, regularCheck, "p1", now)
+	superseded := task.Seq
+	trackTask(&task, regularCheck, "p1", now)
+
+	tests := []struct {
+		name string
+		res  models.CheckResult
+		want bool
+	}{
+		{"passive result", models.CheckResult{ID: "web1/http"}, true},
+		{"superseded dispatch", models.CheckResult{ID: "web1/http", Seq: superseded}, false},
+		{"matching dispatch", models.CheckResult{ID: "web1/http", Seq: task.Seq}, true},
+		{"pushed twice", models.CheckResult{ID: "web1/http", Seq: task.Seq}, false},
+		{"unknown dispatch of this run", models.CheckResult{ID: "web1/http", Seq: task.Seq + 1}, false},
+		{"dispatched before a restart", models.CheckResult{ID: "web1/http", Seq: firstSeq - 1, StartTime: now.Add(-30 * time.Second)}, true},
+		{"before a restart, newer result known", models.CheckResult{ID: "HOST:web1", Seq: firstSeq - 1, StartTime: now.Add(-30 * time.Second)}, false},
+		{"before a restart, unknown object", models.CheckResult{ID: "web9/http", Seq: firstSeq - 1, StartTime: now}, false},
+		{"before a restart, event handler", models.CheckResult{ID: "EVENT:1", Seq: firstSeq - 1, StartTime: now}, false},
+	}
+	late := 0
+	for _, tt := range tests {
+		if got := acceptResult(tt.res); got != tt.want {
+			t.Errorf("%s: accepted = %v, want %v", tt.name, got, tt.want)
+		}
+		if !tt.want {
+			late++
+		}
+		if lateResults != late {
+			t.Errorf("%s: %d late results, want %d", tt.name, lateResults, late)
+			late = lateResults
+		}
+	}
+	if len(inFlight) != 0 {
+		t.Errorf("%d tasks still in flight, want 0", len(inFlight))
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/logger"
+)
+
+// Livestatus response codes, sent with "ResponseHeader: fixed16"
+const (
+	lsOK         = 200
+	lsBadRequest = 400
+	lsNotFound   = 404
+)
+
+// lsRequest is a parsed LQL query
+type lsRequest struct {
+	table   *lsTable
+	columns []string
+	filters []lsPredicate // Stack combined by And:, Or: and Negate:
+	stats   []*lsStat
+	format  string // "csv" (default) or "json"
+	headers bool
+	limit   int
+}
+
+// lsPredicate tests a row of a table
+type lsPredicate func(row interface{}) bool
+
+// lsStat is a "Stats:" line: a count of matching rows or an aggregate of a column
+type lsStat struct {
+	match     lsPredicate
+	aggregate string // sum, min, max, avg or std
+	column    *lsColumn
+}
+
+// lsError is a query error reported to the client with its response code
+type lsError struct {
+	code int
+	msg  string
+}
+
+func (e *lsError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &lsError{lsBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// livestatusEnabled reports whether a Livestatus listener is configured
+func livestatusEnabled() bool {
+	return appConfig.LivestatusSocket != "" || appConfig.LivestatusAddress != ""
+}
+
+// startLivestatus opens the configured Unix socket and TCP address
+func startLivestatus() {
+	if appConfig.LivestatusSocket != "" {
+		removeStaleSocket(appConfig.LivestatusSocket)
+		l, err := net.Listen("unix", appConfig.LivestatusSocket)
+		if err != nil {
+			logger.Fatal("Livestatus socket %s: %v", appConfig.LivestatusSocket, err)
+		}
+		logger.Always("Livestatus listening on %s", appConfig.LivestatusSocket)
+		go acceptLivestatus(l)
+	}
+	if appConfig.LivestatusAddress != "" {
+		l, err := net.Listen("tcp", appConfig.LivestatusAddress)
+		if err != nil {
+			logger.Fatal("Livestatus address %s: %v", appConfig.LivestatusAddress, err)
+		}
+		logger.Always("Livestatus listening on %s", appConfig.LivestatusAddress)
+		go acceptLivestatus(l)
+	}
+}
+
+// removeStaleSocket removes the socket left over by a previous run. Any other
+// kind of file at the path is kept, and the listener fails to start.
+func removeStaleSocket(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+// acceptLivestatus serves every connection of a listener
+func acceptLivestatus(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logger.Info("[ERROR] Livestatus accept: %v", err)
+			return
+		}
+		go serveLivestatus(conn)
+	}
+}
+
+// serveLivestatus answers the queries of a connection. A query is a block of
+// header lines ended by an empty line or the end of the input; the connection
+// is closed after the answer unless the query asked for "KeepAlive: on".
+func serveLivestatus(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		lines, err := readQuery(r)
+		if len(lines) == 0 {
+			return
+		}
+		if strings.HasPrefix(lines[0], "COMMAND ") {
+			logger.Info("[WARNING] Livestatus command ignored: %s", lines[0])
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		req, qerr := parseLQL(lines)
+		var body []byte
+		code := lsOK
+		if qerr == nil {
+			body, qerr = req.execute()
+		}
+		if qerr != nil {
+			code, body = lsBadRequest, []byte(qerr.Error()+"\n")
+			if e, ok := qerr.(*lsError); ok {
+				code = e.code
+			}
+		}
+
+		// These headers apply even when the rest of the query is invalid
+		fixed16 := headerValue(lines, "ResponseHeader") == "fixed16"
+		if fixed16 {
+			fmt.Fprintf(conn, "%03d %11d\n", code, len(body))
+		}
+		if _, werr := conn.Write(body); werr != nil {
+			return
+		}
+		// Without a response header the client cannot tell an error from data
+		if headerValue(lines, "KeepAlive") != "on" || err != nil || (qerr != nil && !fixed16) {
+			return
+		}
+	}
+}
+
+// readQuery reads the lines of a query up to the empty line that ends it
+func readQuery(r *bufio.Reader) ([]string, error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if err != nil || len(lines) > 0 {
+				return lines, err
+			}
+			continue // Blank lines between queries
+		}
+		lines = append(lines, line)
+		if err != nil {
+			if err == io.EOF {
+				return lines, err
+			}
+			return nil, err
+		}
+	}
+}
+
+// headerValue returns the value of a header of a query
+func headerValue(lines []string, name string) string {
+	for _, line := range lines[1:] {
+		if header, value, ok := strings.Cut(line, ":"); ok && header == name {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// parseLQL parses a "GET <table>" query and its headers
+func parseLQL(lines []string) (*lsRequest, error) {
+	if !strings.HasPrefix(lines[0], "GET ") {
+		return nil, badRequest("Invalid request method: %s", lines[0])
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(lines[0], "GET "))
+	table, ok := lsTables[name]
+	if !ok {
+		return nil, &lsError{lsNotFound, "Invalid GET request, no such table '" + name + "'"}
+	}
+	req := &lsRequest{table: table, format: "csv"}
+
+	columnHeaders := ""
+	for _, line := range lines[1:] {
+		header, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return req, badRequest("Invalid header line: %s", line)
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch header {
+		case "Columns":
+			for _, c := range strings.Fields(value) {
+				if _, ok := table.columns[c]; !ok {
+					return req, badRequest("Table '%s' has no column '%s'", name, c)
+				}
+				req.columns = append(req.columns, c)
+			}
+		case "Filter":
+			var p lsPredicate
+			if p, err = table.predicate(value); err == nil {
+				req.filters = append(req.filters, p)
+			}
+		case "And", "Or":
+			req.filters, err = combine(req.filters, value, header == "And")
+		case "Negate":
+			req.filters, err = negate(req.filters)
+		case "Stats":
+			var st *lsStat
+			if st, err = table.stat(value); err == nil {
+				req.stats = append(req.stats, st)
+			}
+		case "StatsAnd", "StatsOr", "StatsNegate":
+			err = req.combineStats(header, value)
+		case "OutputFormat":
+			switch value {
+			case "csv":
+				req.format = "csv"
+			case "json", "python", "python3":
+				req.format = "json" // Python literals of these values are valid JSON
+			default:
+				err = badRequest("Unsupported output format: %s", value)
+			}
+		case "ColumnHeaders":
+			columnHeaders = value
+		case "Limit":
+			if req.limit, err = strconv.Atoi(value); err != nil || req.limit < 0 {
+				err = badRequest("Invalid limit: %s", value)
+			}
+		case "ResponseHeader", "KeepAlive":
+			// Handled by serveLivestatus
+		case "AuthUser", "Localtime", "Timelimit", "WaitTimeout":
+			// Accepted for compatibility, all objects are visible
+		default:
+			err = badRequest("Undefined request header: %s", header)
+		}
+		if err != nil {
+			return req, err
+		}
+	}
+
+	// Livestatus prints the column names when it chooses the columns itself
+	req.headers = columnHeaders == "on" || (columnHeaders == "" && len(req.columns) == 0 && len(req.stats) == 0)
+	return req, nil
+}
+
+// combine replaces the last n predicates of a stack by their conjunction or disjunction
+func combine(stack []lsPredicate, value string, and bool) ([]lsPredicate, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > len(stack) {
+		return stack, badRequest("Cannot combine %s filters, %d on the stack", value, len(stack))
+	}
+	if n == 0 {
+		// An empty conjunction is true, an empty disjunction false
+		return append(stack, func(interface{}) bool { return and }), nil
+	}
+	operands := append([]lsPredicate(nil), stack[len(stack)-n:]...)
+	return append(stack[:len(stack)-n], func(row interface{}) bool {
+		for _, p := range operands {
+			if p(row) != and {
+				return !and
+			}
+		}
+		return and
+	}), nil
+}
+
+// negate inverts the last predicate of a stack
+func negate(stack []lsPredicate) ([]lsPredicate, error) {
+	if len(stack) == 0 {
+		return stack, badRequest("Nothing to negate")
+	}
+	p := stack[len(stack)-1]
+	stack[len(stack)-1] = func(row interface{}) bool { return !p(row) }
+	return stack, nil
+}
+
+// combineStats applies StatsAnd:, StatsOr: or StatsNegate: to the trailing counting stats
+func (req *lsRequest) combineStats(header, value string) error {
+	var stack []lsPredicate
+	i := len(req.stats)
+	for i > 0 && req.stats[i-1].match != nil {
+		i--
+		stack = append([]lsPredicate{req.stats[i].match}, stack...)
+	}
+	var err error
+	switch header {
+	case "StatsNegate":
+		stack, err = negate(stack)
+	default:
+		stack, err = combine(stack, value, header == "StatsAnd")
+	}
+	if err != nil {
+		return err
+	}
+	req.stats = req.stats[:i]
+	for _, p := range stack {
+		req.stats = append(req.stats, &lsStat{match: p})
+	}
+	return nil
+}
+
+// match applies the filters left on the stack, which are implicitly and-ed
+func (req *lsRequest) match(row interface{}) bool {
+	for _, p := range req.filters {
+		if !p(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// execute runs the query. The rows of the table are taken under the read lock and
+// filtered, grouped and formatted after releasing it, so a large query does not
+// hold up result processing.
+func (req *lsRequest) execute() ([]byte, error) {
+	mu.RLock()
+	all := req.table.rows(&lsView{})
+	mu.RUnlock()
+
+	var rows []interface{}
+	for _, row := range all {
+		if req.match(row) {
+			rows = append(rows, row)
+		}
+	}
+
+	columns := req.columns
+	if len(columns) == 0 && len(req.stats) == 0 {
+		columns = req.table.order
+	}
+
+	var out [][]interface{}
+	if len(req.stats) > 0 {
+		out = req.computeStats(rows, columns)
+	} else {
+		for _, row := range rows {
+			if req.limit > 0 && len(out) == req.limit {
+				break
+			}
+			values := make([]interface{}, len(columns))
+			for i, c := range columns {
+				values[i] = req.table.columns[c].value(row)
+			}
+			out = append(out, values)
+		}
+	}
+
+	if req.headers {
+		names := make([]interface{}, 0, len(columns)+len(req.stats))
+		for _, c := range columns {
+			names = append(names, c)
+		}
+		for i := range req.stats {
+			names = append(names, fmt.Sprintf("stats_%d", i+1))
+		}
+		out = append([][]interface{}{names}, out...)
+	}
+	if req.format == "json" {
+		data, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return formatCSV(out), nil
+}
+
+// computeStats evaluates the stats over the rows, grouped by the values of the columns
+func (req *lsRequest) computeStats(rows []interface{}, columns []string) [][]interface{} {
+	type group struct {
+		values []interface{}
+		rows   []interface{}
+	}
+	var groups []*group
+	index := make(map[string]*group)
+	for _, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i, c := range columns {
+			values[i] = req.table.columns[c].value(row)
+		}
+		key, _ := json.Marshal(values)
+		g, ok := index[string(key)]
+		if !ok {
+			g = &group{values: values}
+			index[string(key)] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+	// Without grouping there is always one line, even when nothing matched
+	if len(columns) == 0 && len(groups) == 0 {
+		groups = append(groups, &group{})
+	}
+
+	out := make([][]interface{}, 0, len(groups))
+	for _, g := range groups {
+		line := append([]interface{}(nil), g.values...)
+		for _, st := range req.stats {
+			line = append(line, st.compute(g.rows))
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// compute evaluates a stat over a group of rows
+func (st *lsStat) compute(rows []interface{}) interface{} {
+	if st.match != nil {
+		n := 0
+		for _, row := range rows {
+			if st.match(row) {
+				n++
+			}
+		}
+		return n
+	}
+
+	var sum, sumSq float64
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, row := range rows {
+		v := toFloat(st.column.value(row))
+		sum += v
+		sumSq += v * v
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+	n := float64(len(rows))
+	if n == 0 {
+		return 0
+	}
+	switch st.aggregate {
+	case "sum":
+		return sum
+	case "min":
+		return min
+	case "max":
+		return max
+	case "avg":
+		return sum / n
+	default: // std
+		return math.Sqrt(math.Max(sumSq/n-(sum/n)*(sum/n), 0))
+	}
+}
+
+// formatCSV writes rows with ";" between fields and "," between list items
+func formatCSV(rows [][]interface{}) []byte {
+	var b bytes.Buffer
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(csvValue(v))
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// csvValue formats a column value for the CSV output
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	case [][]string:
+		pairs := make([]string, len(v))
+		for i, p := range v {
+			pairs[i] = strings.Join(p, "|")
+		}
+		return strings.Join(pairs, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// toFloat converts a numeric column value for comparisons and aggregates
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// compileRegexp compiles a Livestatus regular expression, optionally case-insensitive
+func compileRegexp(expr string, fold bool) (*regexp.Regexp, error) {
+	if fold {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, badRequest("Invalid regular expression '%s': %v", expr, err)
+	}
+	return re, nil
+}
+
+// unixTime returns a time as a Unix timestamp, 0 for the zero time
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// Livestatus log classes
+const (
+	logClassInfo         = 0
+	logClassAlert        = 1
+	logClassNotification = 3
+)
+
+// logEntry is a history line kept in memory for the Livestatus log table
+type logEntry struct {
+	Time        time.Time
+	Class       int
+	Type        string // "HOST ALERT", "SERVICE NOTIFICATION"...
+	Options     string // Message after the type, fields separated by ";"
+	HostName    string
+	ServiceID   string
+	ContactName string
+	State       int
+	StateType   string
+	Attempt     int
+	Output      string
+	Comment     string
+}
+
+// message returns the entry in the Nagios log format
+func (e logEntry) message() string {
+	return e.Type + ": " + e.Options
+}
+
+// History kept for the log table: the most recent livestatus_log_size entries
+var (
+	logEntries []logEntry
+	logNext    int // Slot of the next entry once the buffer is full
+	logMu      sync.Mutex
+)
+
+// recordLog appends an entry to the in-memory history when Livestatus is enabled
+func recordLog(e logEntry) {
+	if !livestatusEnabled() {
+		return
+	}
+	e.Time = time.Now()
+	logMu.Lock()
+	defer logMu.Unlock()
+	if len(logEntries) < appConfig.LivestatusLogSize {
+		logEntries = append(logEntries, e)
+		return
+	}
+	logEntries[logNext] = e
+	logNext = (logNext + 1) % len(logEntries)
+}
+
+// snapshotLog copies the history, oldest entry first
+func snapshotLog() []logEntry {
+	logMu.Lock()
+	defer logMu.Unlock()
+	out := make([]logEntry, 0, len(logEntries))
+	out = append(out, logEntries[logNext:]...)
+	return append(out, logEntries[:logNext]...)
+}
+
+// recordAlert logs a state change of a host or service
+func recordAlert(entityType, id, stateStr, stateType string, attempt int, output string) {
+	e := logEntry{Class: logClassAlert, Type: entityType + " ALERT", StateType: stateType, Attempt: attempt, Output: output}
+	table := "services"
+	if entityType == "HOST" {
+		e.HostName, table = id, "hosts"
+	} else {
+		e.HostName, e.ServiceID, _ = models.ParseServiceKey(id)
+	}
+	e.State, _ = parseState(stateStr, table)
+	e.Options = strings.Join(append(logObject(e), stateStr, stateType, fmt.Sprint(attempt), output), ";")
+	recordLog(e)
+}
+
+// recordEvent logs a flapping, downtime, acknowledgement or event handler event
+func recordEvent(entityType, id, event, detail string) {
+	e := logEntry{Class: logClassAlert, Comment: detail}
+	switch entityType {
+	case "HOST":
+		e.HostName = id
+	case "SERVICE":
+		e.HostName, e.ServiceID, _ = models.ParseServiceKey(id)
+	default:
+		// Tasks are not monitored objects
+		recordLog(logEntry{Class: logClassInfo, Type: entityType + " " + event, Options: id + ";" + detail, Comment: detail})
+		return
+	}
+
+	var kind, status string
+	switch event {
+	case models.NotificationFlappingStart, models.NotificationFlappingStop:
+		kind, status = "FLAPPING", "STARTED"
+		if event == models.NotificationFlappingStop {
+			status = "STOPPED"
+		}
+	case models.NotificationDowntimeStart, models.NotificationDowntimeEnd:
+		kind, status = "DOWNTIME", "STARTED"
+		if event == models.NotificationDowntimeEnd {
+			status = "STOPPED"
+		}
+	case models.NotificationAcknowledgement, ackEndEvent:
+		kind, status = "ACKNOWLEDGE", "STARTED"
+		if event == ackEndEvent {
+			status = "STOPPED"
+		}
+	default:
+		e.Class, e.Type = logClassInfo, entityType+" "+strings.ReplaceAll(event, "EVENTHANDLER", "EVENT HANDLER")
+		e.Options = strings.Join(append(logObject(e), detail), ";")
+		recordLog(e)
+		return
+	}
+	e.Type = entityType + " " + kind + " ALERT"
+	e.Options = strings.Join(append(logObject(e), status, detail), ";")
+	recordLog(e)
+}
+
+// recordNotification logs a notification once per recipient, as Nagios does
+func recordNotification(n models.NotificationRequest) {
+	entityType := "HOST"
+	if n.ServiceID != "" {
+		entityType = "SERVICE"
+	}
+	state := n.StateName
+	if n.Type != models.NotificationProblem && n.Type != models.NotificationRecovery {
+		state = n.Type + " (" + n.StateName + ")"
+	}
+	contacts := []string{""}
+	if len(n.Contacts) > 0 {
+		contacts = contacts[:0]
+		for _, c := range n.Contacts {
+			contacts = append(contacts, c.ID)
+		}
+	}
+	for _, contact := range contacts {
+		e := logEntry{
+			Class: logClassNotification, Type: entityType + " NOTIFICATION", HostName: n.HostName, ServiceID: n.ServiceID,
+			ContactName: contact, State: n.State, Output: n.Output, Comment: n.Comment,
+		}
+		// The notification command is chosen by the reactionner and left empty
+		fields := append([]string{contact}, logObject(e)...)
+		e.Options = strings.Join(append(fields, state, "", n.Output), ";")
+		recordLog(e)
+	}
+}
+
+// logObject returns the host name, and the service ID of a service entry
+func logObject(e logEntry) []string {
+	if e.ServiceID == "" {
+		return []string{e.HostName}
+	}
+	return []string{e.HostName, e.ServiceID}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/models"
+	"shinsakuto/pkg/perfdata"
+)
+
+// Column kinds, which decide the operators a filter accepts
+const (
+	lsInt    = 'i' // Integers, booleans (0/1) and Unix timestamps
+	lsFloat  = 'f'
+	lsString = 's'
+	lsList   = 'l' // []string, or [][]string for the members of service groups
+)
+
+// lsColumn is a column of a Livestatus table
+type lsColumn struct {
+	kind  byte
+	value func(row interface{}) interface{}
+}
+
+// lsTable lists the columns of a table, in their default order, and its rows
+type lsTable struct {
+	columns map[string]*lsColumn
+	order   []string
+	rows    func(v *lsView) []interface{}
+}
+
+// lsField defines a column on rows of type T
+type lsField[T any] struct {
+	name  string
+	kind  byte
+	value func(T) interface{}
+}
+
+func field[T any](name string, kind byte, value func(T) interface{}) lsField[T] {
+	return lsField[T]{name, kind, value}
+}
+
+// newTable builds a table whose rows are all of type T
+func newTable[T any](rows func(v *lsView) []interface{}, fields ...lsField[T]) *lsTable {
+	t := &lsTable{columns: make(map[string]*lsColumn), rows: rows}
+	for _, f := range fields {
+		value := f.value
+		t.add(f.name, &lsColumn{f.kind, func(row interface{}) interface{} { return value(row.(T)) }})
+	}
+	return t
+}
+
+// add appends a column, keeping the first definition of a name
+func (t *lsTable) add(name string, c *lsColumn) {
+	if _, ok := t.columns[name]; ok {
+		return
+	}
+	t.columns[name] = c
+	t.order = append(t.order, name)
+}
+
+// lsView gives the tables their rows for one query. It is used under the read
+// lock; the rows hold the attributes read by the columns, so the query is
+// evaluated after releasing it. The host and service rows are built on first
+// use: a query only pays for the tables it reads.
+type lsView struct {
+	built    bool
+	hosts    []*lsHost
+	services []*lsService
+}
+
+// lsObject holds the attributes common to hosts and services
+type lsObject struct {
+	checkCommand, checkPeriod, notificationPeriod, eventHandler string
+	contacts, contactGroups, groups                             []string
+	state, attempt, maxAttempts, notificationNumber             int
+	freshnessThreshold                                          int
+	hard, checked, acknowledged, inDowntime, flapping           bool
+	activeChecks, passiveChecks, flapDetection, eventHandlers   bool
+	notifications, checkFreshness                               bool
+	output, longOutput, perfData                                string
+	lastCheck, nextCheck, lastNotification                      time.Time
+	checkInterval, retryInterval                                float64
+	percentStateChange, latency, executionTime                  float64
+}
+
+// Lists of the configuration (groups, parents, contacts) are shared with the
+// scheduler objects: a reload replaces them and never modifies them.
+type lsHost struct {
+	lsObject
+	name, address string
+	parents       []string
+	services      []*lsService
+}
+
+type lsService struct {
+	lsObject
+	hostName, description string
+	host                  *lsHost // nil when the host is not part of the shard
+}
+
+// lsGroup is a host group or service group, built from the groups of its members
+type lsGroup struct {
+	name     string
+	hosts    []*lsHost
+	services []*lsService
+}
+
+type lsDowntime struct {
+	models.Downtime
+	active bool
+}
+
+// objects builds the host and service rows, must be called under mu
+func (v *lsView) objects() {
+	if v.built {
+		return
+	}
+	v.built = true
+	byName := make(map[string]*lsHost, len(hosts))
+	for _, c := range hosts {
+		row := &lsHost{name: c.ID, address: c.Address, parents: c.Parents}
+		row.lsObject = lsObject{
+			checkCommand: c.CheckCommand, checkPeriod: c.CheckPeriod, notificationPeriod: c.NotificationPeriod,
+			eventHandler: c.EventHandler, contacts: c.Contacts, contactGroups: c.ContactGroups, groups: c.HostGroups,
+			state: c.CurrentState, attempt: c.Attempts, maxAttempts: maxAttempts(c.MaxAttempts),
+			notificationNumber: c.NotificationNumber, freshnessThreshold: c.FreshnessThreshold,
+			acknowledged: c.Acknowledged, inDowntime: c.InDowntime, flapping: c.IsFlapping,
+			activeChecks: hostActive(c), passiveChecks: enabled(overridden(c.Overrides.PassiveChecks, c.PassiveChecksEnabled)),
+			flapDetection: appConfig.FlapDetection && enabled(overridden(c.Overrides.FlapDetection, c.FlapDetectionEnabled)),
+			eventHandlers: enabled(overridden(c.Overrides.EventHandlers, c.EventHandlerEnabled)),
+			notifications: enabled(c.Overrides.Notifications), checkFreshness: c.CheckFreshness,
+			lastCheck: c.LastCheck, nextCheck: c.NextCheck, lastNotification: c.LastNotification,
+			percentStateChange: c.PercentStateChange, latency: c.Latency, executionTime: c.ExecutionTime,
+		}
+		row.fill(c.StateType, c.Output, c.LongOutput, c.Perfdata, c.CheckInterval, c.NormalInterval, c.RetryInterval)
+		v.hosts = append(v.hosts, row)
+		byName[c.ID] = row
+	}
+	for _, c := range services {
+		row := &lsService{hostName: c.HostName, description: c.ID, host: byName[c.HostName]}
+		row.lsObject = lsObject{
+			checkCommand: c.CheckCommand, checkPeriod: c.CheckPeriod, notificationPeriod: c.NotificationPeriod,
+			eventHandler: c.EventHandler, contacts: c.Contacts, contactGroups: c.ContactGroups, groups: c.ServiceGroups,
+			state: c.CurrentState, attempt: c.Attempts, maxAttempts: maxAttempts(c.MaxAttempts),
+			notificationNumber: c.NotificationNumber, freshnessThreshold: c.FreshnessThreshold,
+			acknowledged: c.Acknowledged, inDowntime: c.InDowntime, flapping: c.IsFlapping,
+			activeChecks: serviceActive(c), passiveChecks: enabled(overridden(c.Overrides.PassiveChecks, c.PassiveChecksEnabled)),
+			flapDetection: appConfig.FlapDetection && enabled(overridden(c.Overrides.FlapDetection, c.FlapDetectionEnabled)),
+			eventHandlers: enabled(overridden(c.Overrides.EventHandlers, c.EventHandlerEnabled)),
+			notifications: enabled(c.Overrides.Notifications), checkFreshness: c.CheckFreshness,
+			lastCheck: c.LastCheck, nextCheck: c.NextCheck, lastNotification: c.LastNotification,
+			percentStateChange: c.PercentStateChange, latency: c.Latency, executionTime: c.ExecutionTime,
+		}
+		row.fill(c.StateType, c.Output, c.LongOutput, c.Perfdata, c.CheckInterval, c.NormalInterval, c.RetryInterval)
+		v.services = append(v.services, row)
+	}
+	sort.Slice(v.hosts, func(i, j int) bool { return v.hosts[i].name < v.hosts[j].name })
+	sort.Slice(v.services, func(i, j int) bool {
+		a, b := v.services[i], v.services[j]
+		return models.ServiceKey(a.hostName, a.description) < models.ServiceKey(b.hostName, b.description)
+	})
+	for _, row := range v.services {
+		if row.host != nil {
+			row.host.services = append(row.host.services, row)
+		}
+	}
+}
+
+// fill sets the attributes derived from the state type, output and intervals
+func (o *lsObject) fill(stateType, output, longOutput string, perf []models.PerfMetric, checkInterval, normalInterval, retryInterval int) {
+	o.hard = stateType != models.StateTypeSoft
+	o.checked = !o.lastCheck.IsZero()
+	o.output, o.longOutput, o.perfData = output, longOutput, perfdata.Format(perf)
+	// Intervals are expressed in the configured unit, as in the object definitions
+	unit := intervalDuration(1).Seconds()
+	normal := checkDelay(checkInterval, normalInterval)
+	o.checkInterval = normal.Seconds() / unit
+	o.retryInterval = retryDelay(retryInterval, normal).Seconds() / unit
+}
+
+// hostGroups returns the host groups of the view, sorted by name
+func (v *lsView) hostGroups() []interface{} {
+	v.objects()
+	index := make(map[string]*lsGroup)
+	var names []string
+	for _, h := range v.hosts {
+		for _, name := range h.groups {
+			g, ok := index[name]
+			if !ok {
+				g = &lsGroup{name: name}
+				index[name] = g
+				names = append(names, name)
+			}
+			g.hosts = append(g.hosts, h)
+		}
+	}
+	return sortedGroups(index, names)
+}
+
+// serviceGroups returns the service groups of the view, sorted by name
+func (v *lsView) serviceGroups() []interface{} {
+	v.objects()
+	index := make(map[string]*lsGroup)
+	var names []string
+	for _, svc := range v.services {
+		for _, name := range svc.groups {
+			g, ok := index[name]
+			if !ok {
+				g = &lsGroup{name: name}
+				index[name] = g
+				names = append(names, name)
+			}
+			g.services = append(g.services, svc)
+		}
+	}
+	return sortedGroups(index, names)
+}
+
+func sortedGroups(index map[string]*lsGroup, names []string) []interface{} {
+	sort.Strings(names)
+	rows := make([]interface{}, len(names))
+	for i, name := range names {
+		rows[i] = index[name]
+	}
+	return rows
+}
+
+// b2i converts a flag to the 0/1 integers used by Livestatus
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// objectFields returns the columns shared by hosts and services
+func objectFields[T interface{ object() *lsObject }]() []lsField[T] {
+	o := func(f func(o *lsObject) interface{}) func(T) interface{} {
+		return func(row T) interface{} { return f(row.object()) }
+	}
+	return []lsField[T]{
+		field("check_command", lsString, o(func(o *lsObject) interface{} { return o.checkCommand })),
+		field("check_period", lsString, o(func(o *lsObject) interface{} { return o.checkPeriod })),
+		field("notification_period", lsString, o(func(o *lsObject) interface{} { return o.notificationPeriod })),
+		field("event_handler", lsString, o(func(o *lsObject) interface{} { return o.eventHandler })),
+		field("contacts", lsList, o(func(o *lsObject) interface{} { return nonNil(o.contacts) })),
+		field("contact_groups", lsList, o(func(o *lsObject) interface{} { return nonNil(o.contactGroups) })),
+		field("groups", lsList, o(func(o *lsObject) interface{} { return nonNil(o.groups) })),
+		field("state", lsInt, o(func(o *lsObject) interface{} { return o.state })),
+		field("state_type", lsInt, o(func(o *lsObject) interface{} { return b2i(o.hard) })),
+		field("has_been_checked", lsInt, o(func(o *lsObject) interface{} { return b2i(o.checked) })),
+		field("plugin_output", lsString, o(func(o *lsObject) interface{} { return o.output })),
+		field("long_plugin_output", lsString, o(func(o *lsObject) interface{} { return o.longOutput })),
+		field("perf_data", lsString, o(func(o *lsObject) interface{} { return o.perfData })),
+		field("last_check", lsInt, o(func(o *lsObject) interface{} { return unixTime(o.lastCheck) })),
+		field("next_check", lsInt, o(func(o *lsObject) interface{} { return unixTime(o.nextCheck) })),
+		field("current_attempt", lsInt, o(func(o *lsObject) interface{} { return o.attempt })),
+		field("max_check_attempts", lsInt, o(func(o *lsObject) interface{} { return o.maxAttempts })),
+		field("check_interval", lsFloat, o(func(o *lsObject) interface{} { return o.checkInterval })),
+		field("retry_interval", lsFloat, o(func(o *lsObject) interface{} { return o.retryInterval })),
+		field("acknowledged", lsInt, o(func(o *lsObject) interface{} { return b2i(o.acknowledged) })),
+		field("scheduled_downtime_depth", lsInt, o(func(o *lsObject) interface{} { return b2i(o.inDowntime) })),
+		field("is_flapping", lsInt, o(func(o *lsObject) interface{} { return b2i(o.flapping) })),
+		field("percent_state_change", lsFloat, o(func(o *lsObject) interface{} { return o.percentStateChange })),
+		field("active_checks_enabled", lsInt, o(func(o *lsObject) interface{} { return b2i(o.activeChecks) })),
+		field("accept_passive_checks", lsInt, o(func(o *lsObject) interface{} { return b2i(o.passiveChecks) })),
+		field("flap_detection_enabled", lsInt, o(func(o *lsObject) interface{} { return b2i(o.flapDetection) })),
+		field("event_handler_enabled", lsInt, o(func(o *lsObject) interface{} { return b2i(o.eventHandlers) })),
+		field("notifications_enabled", lsInt, o(func(o *lsObject) interface{} { return b2i(o.notifications) })),
+		field("check_freshness", lsInt, o(func(o *lsObject) interface{} { return b2i(o.checkFreshness) })),
+		field("freshness_threshold", lsInt, o(func(o *lsObject) interface{} { return o.freshnessThreshold })),
+		field("current_notification_number", lsInt, o(func(o *lsObject) interface{} { return o.notificationNumber })),
+		field("last_notification", lsInt, o(func(o *lsObject) interface{} { return unixTime(o.lastNotification) })),
+		field("latency", lsFloat, o(func(o *lsObject) interface{} { return o.latency })),
+		field("execution_time", lsFloat, o(func(o *lsObject) interface{} { return o.executionTime })),
+	}
+}
+
+func (h *lsHost) object() *lsObject    { return &h.lsObject }
+func (s *lsService) object() *lsObject { return &s.lsObject }
+
+// nonNil returns an empty list instead of nil, so JSON output has [] and not null
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// countServices counts the services of a host or group in a state (-1: any state)
+func countServices(services []*lsService, state int) int {
+	n := 0
+	for _, s := range services {
+		if state < 0 || (s.checked && s.state == state) {
+			n++
+		}
+	}
+	return n
+}
+
+// pendingServices counts the services that were never checked
+func pendingServices(services []*lsService) int {
+	n := 0
+	for _, s := range services {
+		if !s.checked {
+			n++
+		}
+	}
+	return n
+}
+
+// worstServiceState returns the most severe service state, CRITICAL ranking above UNKNOWN
+func worstServiceState(services []*lsService) int {
+	rank := map[int]int{0: 0, 1: 1, 3: 2, 2: 3}
+	worst := 0
+	for _, s := range services {
+		if rank[s.state] > rank[worst] {
+			worst = s.state
+		}
+	}
+	return worst
+}
+
+// hostFields returns the columns of the hosts table
+func hostFields() []lsField[*lsHost] {
+	fields := []lsField[*lsHost]{
+		field("name", lsString, func(h *lsHost) interface{} { return h.name }),
+		field("display_name", lsString, func(h *lsHost) interface{} { return h.name }),
+		field("alias", lsString, func(h *lsHost) interface{} { return h.name }),
+		field("address", lsString, func(h *lsHost) interface{} { return h.address }),
+		field("parents", lsList, func(h *lsHost) interface{} { return nonNil(h.parents) }),
+	}
+	fields = append(fields, objectFields[*lsHost]()...)
+	return append(fields,
+		field("services", lsList, func(h *lsHost) interface{} {
+			ids := []string{}
+			for _, s := range h.services {
+				ids = append(ids, s.description)
+			}
+			return ids
+		}),
+		field("num_services", lsInt, func(h *lsHost) interface{} { return countServices(h.services, -1) }),
+		field("num_services_ok", lsInt, func(h *lsHost) interface{} { return countServices(h.services, 0) }),
+		field("num_services_warn", lsInt, func(h *lsHost) interface{} { return countServices(h.services, 1) }),
+		field("num_services_crit", lsInt, func(h *lsHost) interface{} { return countServices(h.services, 2) }),
+		field("num_services_unknown", lsInt, func(h *lsHost) interface{} { return countServices(h.services, 3) }),
+		field("num_services_pending", lsInt, func(h *lsHost) interface{} { return pendingServices(h.services) }),
+		field("worst_service_state", lsInt, func(h *lsHost) interface{} { return worstServiceState(h.services) }),
+	)
+}
+
+// serviceFields returns the columns of the services table
+func serviceFields() []lsField[*lsService] {
+	fields := []lsField[*lsService]{
+		field("host_name", lsString, func(s *lsService) interface{} { return s.hostName }),
+		field("description", lsString, func(s *lsService) interface{} { return s.description }),
+		field("display_name", lsString, func(s *lsService) interface{} { return s.description }),
+	}
+	return append(fields, objectFields[*lsService]()...)
+}
+
+// groupFields returns the columns of the hostgroups and servicegroups tables
+func groupFields(hostGroups bool) []lsField[*lsGroup] {
+	fields := []lsField[*lsGroup]{
+		field("name", lsString, func(g *lsGroup) interface{} { return g.name }),
+		field("alias", lsString, func(g *lsGroup) interface{} { return g.name }),
+	}
+	if !hostGroups {
+		return append(fields,
+			field("members", lsList, func(g *lsGroup) interface{} {
+				members := [][]string{}
+				for _, s := range g.services {
+					members = append(members, []string{s.hostName, s.description})
+				}
+				return members
+			}),
+			field("num_services", lsInt, func(g *lsGroup) interface{} { return countServices(g.services, -1) }),
+			field("num_services_ok", lsInt, func(g *lsGroup) interface{} { return countServices(g.services, 0) }),
+			field("num_services_warn", lsInt, func(g *lsGroup) interface{} { return countServices(g.services, 1) }),
+			field("num_services_crit", lsInt, func(g *lsGroup) interface{} { return countServices(g.services, 2) }),
+			field("num_services_unknown", lsInt, func(g *lsGroup) interface{} { return countServices(g.services, 3) }),
+			field("num_services_pending", lsInt, func(g *lsGroup) interface{} { return pendingServices(g.services) }),
+			field("worst_service_state", lsInt, func(g *lsGroup) interface{} { return worstServiceState(g.services) }),
+		)
+	}
+
+	countHosts := func(g *lsGroup, state int) int {
+		n := 0
+		for _, h := range g.hosts {
+			if state < 0 || (h.checked && h.state == state) {
+				n++
+			}
+		}
+		return n
+	}
+	groupServices := func(g *lsGroup) []*lsService {
+		var services []*lsService
+		for _, h := range g.hosts {
+			services = append(services, h.services...)
+		}
+		return services
+	}
+	return append(fields,
+		field("members", lsList, func(g *lsGroup) interface{} {
+			members := []string{}
+			for _, h := range g.hosts {
+				members = append(members, h.name)
+			}
+			return members
+		}),
+		field("num_hosts", lsInt, func(g *lsGroup) interface{} { return countHosts(g, -1) }),
+		field("num_hosts_up", lsInt, func(g *lsGroup) interface{} { return countHosts(g, models.HostUp) }),
+		field("num_hosts_down", lsInt, func(g *lsGroup) interface{} { return countHosts(g, models.HostDown) }),
+		field("num_hosts_unreach", lsInt, func(g *lsGroup) interface{} { return countHosts(g, models.HostUnreachable) }),
+		field("num_hosts_pending", lsInt, func(g *lsGroup) interface{} {
+			n := 0
+			for _, h := range g.hosts {
+				if !h.checked {
+					n++
+				}
+			}
+			return n
+		}),
+		field("worst_host_state", lsInt, func(g *lsGroup) interface{} {
+			worst := models.HostUp
+			for _, h := range g.hosts {
+				if h.state > worst {
+					worst = h.state
+				}
+			}
+			return worst
+		}),
+		field("num_services", lsInt, func(g *lsGroup) interface{} { return countServices(groupServices(g), -1) }),
+		field("num_services_ok", lsInt, func(g *lsGroup) interface{} { return countServices(groupServices(g), 0) }),
+		field("num_services_warn", lsInt, func(g *lsGroup) interface{} { return countServices(groupServices(g), 1) }),
+		field("num_services_crit", lsInt, func(g *lsGroup) interface{} { return countServices(groupServices(g), 2) }),
+		field("num_services_unknown", lsInt, func(g *lsGroup) interface{} { return countServices(groupServices(g), 3) }),
+		field("num_services_pending", lsInt, func(g *lsGroup) interface{} { return pendingServices(groupServices(g)) }),
+		field("worst_service_state", lsInt, func(g *lsGroup) interface{} { return worstServiceState(groupServices(g)) }),
+	)
+}
+
+// downtimeFields returns the columns of the downtimes table
+func downtimeFields() []lsField[*lsDowntime] {
+	return []lsField[*lsDowntime]{
+		field("id", lsString, func(d *lsDowntime) interface{} { return d.ID }),
+		field("host_name", lsString, func(d *lsDowntime) interface{} { return d.HostName }),
+		field("service_description", lsString, func(d *lsDowntime) interface{} { return d.ServiceID }),
+		field("is_service", lsInt, func(d *lsDowntime) interface{} { return b2i(d.ServiceID != "") }),
+		field("author", lsString, func(d *lsDowntime) interface{} { return d.Author }),
+		field("comment", lsString, func(d *lsDowntime) interface{} { return d.Comment }),
+		field("start_time", lsInt, func(d *lsDowntime) interface{} { return unixTime(d.StartTime) }),
+		field("end_time", lsInt, func(d *lsDowntime) interface{} { return unixTime(d.EndTime) }),
+		field("fixed", lsInt, func(d *lsDowntime) interface{} { return b2i(!d.Flexible) }),
+		field("duration", lsInt, func(d *lsDowntime) interface{} { return d.Duration * 60 }),
+		field("recurring", lsInt, func(d *lsDowntime) interface{} { return b2i(d.Recurring()) }),
+		field("schedule", lsString, func(d *lsDowntime) interface{} { return d.Schedule }),
+		field("timeperiod", lsString, func(d *lsDowntime) interface{} { return d.TimePeriod }),
+		field("trigger_time", lsInt, func(d *lsDowntime) interface{} { return unixTime(d.TriggerTime) }),
+		// Livestatus types: 0 for a downtime in effect, 1 for a pending one
+		field("type", lsInt, func(d *lsDowntime) interface{} { return b2i(!d.active) }),
+	}
+}
+
+// logFields returns the columns of the log table
+func logFields() []lsField[*logEntry] {
+	return []lsField[*logEntry]{
+		field("time", lsInt, func(e *logEntry) interface{} { return unixTime(e.Time) }),
+		field("class", lsInt, func(e *logEntry) interface{} { return e.Class }),
+		field("type", lsString, func(e *logEntry) interface{} { return e.Type }),
+		field("message", lsString, func(e *logEntry) interface{} { return e.message() }),
+		field("options", lsString, func(e *logEntry) interface{} { return e.Options }),
+		field("host_name", lsString, func(e *logEntry) interface{} { return e.HostName }),
+		field("service_description", lsString, func(e *logEntry) interface{} { return e.ServiceID }),
+		field("contact_name", lsString, func(e *logEntry) interface{} { return e.ContactName }),
+		field("state", lsInt, func(e *logEntry) interface{} { return e.State }),
+		field("state_type", lsString, func(e *logEntry) interface{} { return e.StateType }),
+		field("attempt", lsInt, func(e *logEntry) interface{} { return e.Attempt }),
+		field("plugin_output", lsString, func(e *logEntry) interface{} { return e.Output }),
+		field("comment", lsString, func(e *logEntry) interface{} { return e.Comment }),
+	}
+}
+
+// lsTables are the tables served by the Livestatus listener
+var lsTables = map[string]*lsTable{
+	"hosts":         hostsTable(),
+	"services":      servicesTable(),
+	"hostgroups":    newTable(func(v *lsView) []interface{} { return v.hostGroups() }, groupFields(true)...),
+	"servicegroups": newTable(func(v *lsView) []interface{} { return v.serviceGroups() }, groupFields(false)...),
+	"downtimes": newTable(func(*lsView) []interface{} {
+		now := time.Now()
+		rows := make([]interface{}, len(downtimes))
+		for i := range downtimes {
+			rows[i] = &lsDowntime{Downtime: downtimes[i], active: downtimeActive(&downtimes[i], now)}
+		}
+		return rows
+	}, downtimeFields()...),
+	"log": newTable(func(*lsView) []interface{} {
+		entries := snapshotLog()
+		rows := make([]interface{}, len(entries))
+		for i := range entries {
+			rows[i] = &entries[i]
+		}
+		return rows
+	}, logFields()...),
+}
+
+func hostsTable() *lsTable {
+	return newTable(func(v *lsView) []interface{} {
+		v.objects()
+		rows := make([]interface{}, len(v.hosts))
+		for i, h := range v.hosts {
+			rows[i] = h
+		}
+		return rows
+	}, hostFields()...)
+}
+
+// servicesTable also offers the host columns with a "host_" prefix, as Livestatus does
+func servicesTable() *lsTable {
+	t := newTable(func(v *lsView) []interface{} {
+		v.objects()
+		rows := make([]interface{}, len(v.services))
+		for i, svc := range v.services {
+			rows[i] = svc
+		}
+		return rows
+	}, serviceFields()...)
+	hostTable := hostsTable()
+	for _, name := range hostTable.order {
+		c := hostTable.columns[name]
+		t.add("host_"+name, &lsColumn{c.kind, func(row interface{}) interface{} {
+			if h := row.(*lsService).host; h != nil {
+				return c.value(h)
+			}
+			return zeroValue(c.kind)
+		}})
+	}
+	return t
+}
+
+// zeroValue is the value of a column whose object is missing
+func zeroValue(kind byte) interface{} {
+	switch kind {
+	case lsInt:
+		return 0
+	case lsFloat:
+		return 0.0
+	case lsList:
+		return []string{}
+	}
+	return ""
+}
+
+// predicate parses a "column operator value" filter
+func (t *lsTable) predicate(spec string) (lsPredicate, error) {
+	parts := strings.SplitN(spec, " ", 3)
+	if len(parts) < 2 {
+		return nil, badRequest("Invalid filter: %s", spec)
+	}
+	c, ok := t.columns[parts[0]]
+	if !ok {
+		return nil, badRequest("Unknown column in filter: %s", parts[0])
+	}
+	op, operand := parts[1], ""
+	if len(parts) == 3 {
+		operand = parts[2]
+	}
+	// Every operator can be negated with a leading "!"
+	negated := strings.HasPrefix(op, "!")
+	if negated {
+		op = op[1:]
+	}
+
+	var test func(v interface{}) bool
+	var err error
+	switch c.kind {
+	case lsInt, lsFloat:
+		test, err = numberTest(op, operand)
+	case lsString:
+		test, err = stringTest(op, operand)
+	default:
+		test, err = listTest(op, operand)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(row interface{}) bool { return test(c.value(row)) != negated }, nil
+}
+
+// numberTest compares numeric columns
+func numberTest(op, operand string) (func(v interface{}) bool, error) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(operand), 64)
+	if err != nil {
+		return nil, badRequest("Invalid number: '%s'", operand)
+	}
+	var cmp func(a float64) bool
+	switch op {
+	case "=":
+		cmp = func(a float64) bool { return a == n }
+	case "<":
+		cmp = func(a float64) bool { return a < n }
+	case ">":
+		cmp = func(a float64) bool { return a > n }
+	case "<=":
+		cmp = func(a float64) bool { return a <= n }
+	case ">=":
+		cmp = func(a float64) bool { return a >= n }
+	default:
+		return nil, badRequest("Invalid operator '%s' for a numeric column", op)
+	}
+	return func(v interface{}) bool { return cmp(toFloat(v)) }, nil
+}
+
+// stringTest compares string columns: = exact, =~ case-insensitive, ~ and ~~ regular expressions
+func stringTest(op, operand string) (func(v interface{}) bool, error) {
+	switch op {
+	case "=":
+		return func(v interface{}) bool { return v.(string) == operand }, nil
+	case "=~":
+		return func(v interface{}) bool { return strings.EqualFold(v.(string), operand) }, nil
+	case "~", "~~":
+		re, err := compileRegexp(operand, op == "~~")
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool { return re.MatchString(v.(string)) }, nil
+	case "<":
+		return func(v interface{}) bool { return v.(string) < operand }, nil
+	case ">":
+		return func(v interface{}) bool { return v.(string) > operand }, nil
+	case "<=":
+		return func(v interface{}) bool { return v.(string) <= operand }, nil
+	case ">=":
+		return func(v interface{}) bool { return v.(string) >= operand }, nil
+	}
+	return nil, badRequest("Invalid operator '%s' for a string column", op)
+}
+
+// listTest compares list columns: = with an empty value tests for an empty list,
+// >= tests membership (<= ignoring case), ~ and ~~ match any element.
+// Service group members are compared as "host|service".
+func listTest(op, operand string) (func(v interface{}) bool, error) {
+	var match func(item string) bool
+	switch op {
+	case "=":
+		if operand != "" {
+			return nil, badRequest("Lists can only be compared to an empty value with '='")
+		}
+		return func(v interface{}) bool { return len(listItems(v)) == 0 }, nil
+	case ">=":
+		match = func(item string) bool { return item == operand }
+	case "<=":
+		match = func(item string) bool { return strings.EqualFold(item, operand) }
+	case "~", "~~":
+		re, err := compileRegexp(operand, op == "~~")
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	default:
+		return nil, badRequest("Invalid operator '%s' for a list column", op)
+	}
+	return func(v interface{}) bool {
+		for _, item := range listItems(v) {
+			if match(item) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// listItems returns the elements of a list column as strings
+func listItems(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case [][]string:
+		items := make([]string, len(v))
+		for i, p := range v {
+			items[i] = strings.Join(p, "|")
+		}
+		return items
+	}
+	return nil
+}
+
+// stat parses a "Stats:" line: a filter counting rows, or "sum|min|max|avg|std column"
+func (t *lsTable) stat(spec string) (*lsStat, error) {
+	parts := strings.Fields(spec)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case "sum", "min", "max", "avg", "std":
+			c, ok := t.columns[parts[1]]
+			if !ok {
+				return nil, badRequest("Unknown column in stats: %s", parts[1])
+			}
+			if c.kind != lsInt && c.kind != lsFloat {
+				return nil, badRequest("Cannot aggregate the non-numeric column %s", parts[1])
+			}
+			return &lsStat{aggregate: parts[0], column: c}, nil
+		}
+	}
+	p, err := t.predicate(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &lsStat{match: p}, nil
+}
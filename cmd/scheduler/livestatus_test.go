@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+// lsInventory fills the scheduler maps with 3 hosts and 5 services:
+//
+//	db1   UP        disk CRITICAL 0.5s, mysql OK 1s
+//	web1  UP        disk WARNING 1.5s, http OK 0.5s
+//	web2  DOWN      http CRITICAL 2.5s, acknowledged
+func lsInventory(tb testing.TB) {
+	saveInventory(tb)
+	hosts = map[string]*models.Host{
+		"db1":  {ID: "db1", CurrentState: 0, HostGroups: []string{"db"}},
+		"web1": {ID: "web1", CurrentState: 0, HostGroups: []string{"web"}},
+		"web2": {ID: "web2", CurrentState: 1, HostGroups: []string{"web"}},
+	}
+	services = make(map[string]*models.Service)
+	for _, s := range []*models.Service{
+		{ID: "disk", HostName: "db1", CurrentState: 2, Latency: 0.5},
+		{ID: "mysql", HostName: "db1", CurrentState: 0, Latency: 1},
+		{ID: "disk", HostName: "web1", CurrentState: 1, Latency: 1.5},
+		{ID: "http", HostName: "web1", CurrentState: 0, Latency: 0.5},
+		{ID: "http", HostName: "web2", CurrentState: 2, Latency: 2.5, Acknowledged: true},
+	} {
+		services[s.Key()] = s
+	}
+	downtimes = nil
+}
+
+func TestLivestatusQueries(t *testing.T) {
+	lsInventory(t)
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"columns", "GET hosts\nColumns: name state", "db1;0\nweb1;0\nweb2;1\n"},
+		{"filter", "GET hosts\nColumns: name\nFilter: state = 0", "db1\nweb1\n"},
+		{"stacked filters are and-ed", "GET services\nColumns: host_name description\nFilter: state > 0\nFilter: host_name ~ ^web", "web1;disk\nweb2;http\n"},
+		{"or", "GET services\nColumns: host_name description\nFilter: state = 1\nFilter: description = mysql\nOr: 2", "db1;mysql\nweb1;disk\n"},
+		{"and of ors", "GET services\nColumns: host_name description\nFilter: state = 1\nFilter: state = 2\nOr: 2\nFilter: host_name = web1\nFilter: host_name = db1\nOr: 2\nAnd: 2", "db1;disk\nweb1;disk\n"},
+		{"and with 0 operands is true", "GET hosts\nColumns: name\nAnd: 0", "db1\nweb1\nweb2\n"},
+		{"or with 0 operands is false", "GET hosts\nColumns: name\nOr: 0", ""},
+		{"empty or as an operand", "GET hosts\nColumns: name\nFilter: state = 1\nOr: 0\nOr: 2", "web2\n"},
+		{"negate", "GET hosts\nColumns: name\nFilter: state = 0\nNegate:", "web2\n"},
+		{"negated operator", "GET hosts\nColumns: name\nFilter: name != web1", "db1\nweb2\n"},
+		{"list membership", "GET hosts\nColumns: name\nFilter: groups >= web", "web1\nweb2\n"},
+		{"acknowledged", "GET services\nColumns: host_name description\nFilter: acknowledged = 1", "web2;http\n"},
+		{"host columns on services", "GET services\nColumns: description\nFilter: host_state = 1", "http\n"},
+		{"limit", "GET hosts\nColumns: name\nLimit: 2", "db1\nweb1\n"},
+		{"headers", "GET hosts\nColumns: name\nColumnHeaders: on\nLimit: 1", "name\ndb1\n"},
+		{"json", "GET hosts\nColumns: name state\nFilter: name = web2\nOutputFormat: json", "[[\"web2\",1]]\n"},
+
+		{"counting stats", "GET services\nStats: state = 0\nStats: state = 2", "2;2\n"},
+		{"aggregates", "GET services\nStats: sum latency\nStats: min latency\nStats: max latency\nStats: avg latency", "6;0.5;2.5;1.2\n"},
+		{"stats without matching rows", "GET services\nFilter: host_name = none\nStats: state = 0\nStats: avg latency", "0;0\n"},
+		{"stats headers", "GET services\nColumnHeaders: on\nStats: state = 0", "stats_1\n2\n"},
+		{"stats or and negate", "GET services\nStats: state = 1\nStats: state = 2\nStatsOr: 2\nStatsNegate:", "2\n"},
+		{"stats and with 0 operands", "GET services\nStats: state = 0\nStatsAnd: 0", "2;5\n"},
+		{"stats and after an aggregate", "GET services\nStats: avg latency\nStats: state = 2\nStats: host_name = db1\nStatsAnd: 2\nStats: min latency", "1.2;1;0.5\n"},
+		{"stats or between aggregates", "GET services\nStats: sum latency\nStats: state = 1\nStats: state = 2\nStatsOr: 2\nStats: max latency", "6;3;2.5\n"},
+		{"grouped stats", "GET services\nColumns: host_name\nStats: state = 0\nStats: state != 0\nStats: max latency", "db1;1;1;1\nweb1;1;1;1.5\nweb2;0;1;2.5\n"},
+		{"grouped by two columns", "GET services\nColumns: host_state state\nStats: sum latency", "0;2;0.5\n0;0;1.5\n0;1;1.5\n1;2;2.5\n"},
+		{"grouped stats without matching rows", "GET services\nColumns: host_name\nFilter: state = 3\nStats: state = 0", ""},
+		{"host groups", "GET hostgroups\nColumns: name num_hosts", "db;1\nweb;2\n"},
+	}
+	for _, tt := range tests {
+		req, err := parseLQL(strings.Split(tt.query, "\n"))
+		if err != nil {
+			t.Errorf("%s: parse error: %v", tt.name, err)
+			continue
+		}
+		out, err := req.execute()
+		if err != nil {
+			t.Errorf("%s: execute error: %v", tt.name, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestLivestatusRowsOutliveTheLock(t *testing.T) {
+	lsInventory(t)
+	req, err := parseLQL([]string{"GET services", "Columns: host_name description state acknowledged", "Filter: host_name = web2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.RLock()
+	rows := req.table.rows(&lsView{})
+	mu.RUnlock()
+
+	// Results processed while the query is formatted do not change its answer
+	mu.Lock()
+	services["web2/http"].CurrentState, services["web2/http"].Acknowledged = 0, false
+	delete(services, "web2/http")
+	mu.Unlock()
+	for _, row := range rows {
+		if req.table.columns["host_name"].value(row) != "web2" {
+			continue
+		}
+		if got := req.table.columns["state"].value(row); got != 2 {
+			t.Errorf("state = %v after the lock was released, want 2", got)
+		}
+		if got := req.table.columns["acknowledged"].value(row); got != 1 {
+			t.Errorf("acknowledged = %v after the lock was released, want 1", got)
+		}
+	}
+}
+
+func TestLivestatusAcknowledgementLog(t *testing.T) {
+	saveInventory(t)
+	entries, next := logEntries, logNext
+	t.Cleanup(func() { logEntries, logNext = entries, next })
+	logEntries, logNext = nil, 0
+	appConfig.LivestatusAddress, appConfig.LivestatusLogSize = "127.0.0.1:0", 10
+
+	logEvent("SERVICE", "web2/http", models.NotificationAcknowledgement, "Problem acknowledged")
+	logEvent("HOST", "web2", ackEndEvent, "Acknowledgement cleared")
+	req, err := parseLQL([]string{"GET log", "Columns: type options"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := req.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SERVICE ACKNOWLEDGE ALERT;web2;http;STARTED;Problem acknowledged\n" +
+		"HOST ACKNOWLEDGE ALERT;web2;STOPPED;Acknowledgement cleared\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestLivestatusParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		code  int
+		msg   string
+	}{
+		{"PUT hosts", lsBadRequest, "Invalid request method"},
+		{"GET nope", lsNotFound, "no such table 'nope'"},
+		{"GET hosts\nColumns: name nope", lsBadRequest, "has no column 'nope'"},
+		{"GET hosts\nno colon", lsBadRequest, "Invalid header line"},
+		{"GET hosts\nBogus: 1", lsBadRequest, "Undefined request header"},
+		{"GET hosts\nFilter: state", lsBadRequest, "Invalid filter"},
+		{"GET hosts\nFilter: nope = 1", lsBadRequest, "Unknown column in filter"},
+		{"GET hosts\nFilter: state = up", lsBadRequest, "Invalid number"},
+		{"GET hosts\nFilter: state ~ 1", lsBadRequest, "Invalid operator"},
+		{"GET hosts\nFilter: name ~ (", lsBadRequest, "Invalid regular expression"},
+		{"GET hosts\nFilter: groups = web", lsBadRequest, "empty value"},
+		{"GET hosts\nAnd: 1", lsBadRequest, "Cannot combine 1 filters, 0 on the stack"},
+		{"GET hosts\nFilter: state = 0\nOr: 2", lsBadRequest, "Cannot combine 2 filters, 1 on the stack"},
+		{"GET hosts\nAnd: -1", lsBadRequest, "Cannot combine"},
+		{"GET hosts\nOr: two", lsBadRequest, "Cannot combine"},
+		{"GET hosts\nNegate:", lsBadRequest, "Nothing to negate"},
+		{"GET hosts\nFilter: state = 0\nAnd: 1\nNegate:\nNegate:\nOr: 2", lsBadRequest, "Cannot combine"},
+		{"GET hosts\nStats: sum name", lsBadRequest, "non-numeric column"},
+		{"GET hosts\nStats: avg nope", lsBadRequest, "Unknown column in stats"},
+		{"GET hosts\nStatsNegate:", lsBadRequest, "Nothing to negate"},
+		{"GET hosts\nStats: sum latency\nStatsNegate:", lsBadRequest, "Nothing to negate"},
+		{"GET hosts\nStats: state = 0\nStats: sum latency\nStats: state = 1\nStatsAnd: 2", lsBadRequest, "Cannot combine 2 filters, 1 on the stack"},
+		{"GET hosts\nOutputFormat: xml", lsBadRequest, "Unsupported output format"},
+		{"GET hosts\nLimit: -1", lsBadRequest, "Invalid limit"},
+	}
+	for _, tt := range tests {
+		_, err := parseLQL(strings.Split(tt.query, "\n"))
+		var lsErr *lsError
+		if !errors.As(err, &lsErr) || lsErr.code != tt.code || !strings.Contains(lsErr.msg, tt.msg) {
+			t.Errorf("%q: error = %v, want %d %q", tt.query, err, tt.code, tt.msg)
+		}
+	}
+}
+
+func TestCombineKeepsStackBelowOperands(t *testing.T) {
+	yes := func(interface{}) bool { return true }
+	no := func(interface{}) bool { return false }
+
+	stack, err := combine([]lsPredicate{no, yes, no}, "2", true)
+	if err != nil || len(stack) != 2 || stack[0](nil) || stack[1](nil) {
+		t.Fatalf("And: 2 over [no yes no] = %d predicates, %v", len(stack), err)
+	}
+	if stack, _ = negate(stack); stack[0](nil) || !stack[1](nil) {
+		t.Error("Negate: changed a predicate below the top of the stack")
+	}
+	if stack, err = combine(stack, "0", false); err != nil || len(stack) != 3 || stack[2](nil) {
+		t.Errorf("Or: 0 = %d predicates, %v, want an added false predicate", len(stack), err)
+	}
+	if stack, err = negate(nil); err == nil || len(stack) != 0 {
+		t.Errorf("Negate: on an empty stack = %d predicates, %v, want an error", len(stack), err)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "live.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	file := filepath.Join(dir, "status.dat")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.sock")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		kept bool
+	}{
+		{socket, false},
+		{file, true},
+		{link, true},
+		{filepath.Join(dir, "missing.sock"), false},
+	}
+	for _, tt := range tests {
+		removeStaleSocket(tt.path)
+		if _, err := os.Lstat(tt.path); (err == nil) != tt.kept {
+			t.Errorf("%s: kept %v, want %v", filepath.Base(tt.path), err == nil, tt.kept)
+		}
+	}
+}
@@ -25,13 +25,15 @@ var (
 	mu           sync.RWMutex
 	appConfig    SchedConfig
 	statusLogger *log.Logger
-	stateChanged bool
 	httpClient   = &http.Client{Timeout: 5 * time.Second}
 	brokerWG     sync.WaitGroup
 	// resultQueue decouples HTTP reception from logic processing to prevent saturation
 	resultQueue  = make(chan models.CheckResult, 5000)
 )
 
+// maxBatchSize caps the number of tasks served by a single /v1/pop-tasks call
+const maxBatchSize = 500
+
 func main() {
 	configPath := flag.String("c", "config.json", "Path to configuration file")
 	daemonMode := flag.Bool("d", false, "Run as a daemon in the background")
@@ -43,9 +45,8 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 2. Initialize loggers and restore state from disk
+	// 2. Initialize loggers
 	initLoggers()
-	loadState()
 
 	// 3. Handle Daemonization
 	if *daemonMode {
@@ -57,33 +58,67 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Restore state from disk, in the daemon only: the state file is locked while open
+	loadState()
+
+	// A spare waits for its primary; a primary first takes its shard back from an active spare
+	if appConfig.Spare {
+		standby, lastHeartbeat = true, time.Now()
+		go startFailoverMonitor()
+	} else if appConfig.SpareURL != "" {
+		reclaimShard()
+	}
+
 	// 4. Start asynchronous workers to process incoming check results
 	for i := 0; i < 10; i++ {
 		go resultWorker()
 	}
 
-	// 5. Periodic state persistence loop
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		for range ticker.C {
-			if stateChanged {
-				saveState()
-				stateChanged = false
-			}
-		}
-	}()
+	// Freshness checking of passive and active results
+	go startFreshnessChecker()
+
+	// Re-queue tasks whose results never came back
+	go startOrphanReaper()
+
+	// Start and end scheduled downtimes
+	go startDowntimeChecker()
+
+	// Mirror the acknowledgements of the Reactionner
+	if appConfig.ReactionnerURL != "" {
+		go startAckSync()
+	}
+
+	// Optional Livestatus listener for existing frontends
+	if livestatusEnabled() {
+		startLivestatus()
+	}
+
+	// 5. State persistence: modified objects are flushed every few seconds
+	go startStatePersistence()
 
 	// 6. Setup HTTP routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/sync-all", syncAllHandler)
 	mux.HandleFunc("/v1/pop-task", popTaskHandler)
+	mux.HandleFunc("/v1/pop-tasks", popTasksHandler)
 	mux.HandleFunc("/v1/push-result", pushResultHandler)
+	mux.HandleFunc("/v1/push-results", pushResultsHandler)
+	mux.HandleFunc("/v1/passive-result", passiveResultHandler)
 	mux.HandleFunc("/v1/status", statusHandler)
+	mux.HandleFunc("/v1/metrics", metricsHandler)
+	mux.HandleFunc("/v1/query/", queryHandler)
+	mux.HandleFunc("/v1/events", eventsHandler)
+	mux.HandleFunc("/v1/schedule-check", scheduleCheckHandler)
+	mux.HandleFunc("/v1/overrides", overridesHandler)
+	mux.HandleFunc("/v1/replicate", replicateHandler)
+	mux.HandleFunc("/v1/handback", handbackHandler)
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", appConfig.APIAddress, appConfig.APIPort),
 		Handler: mux,
 	}
+	// Event streams never end on their own
+	server.RegisterOnShutdown(closeEventStreams)
 
 	// 7. Graceful shutdown handling
 	stop := make(chan os.Signal, 1)
@@ -112,7 +147,7 @@ func main() {
 		brokerWG.Wait()
 	}
 
-	saveState() // Final persistence before exit
+	closeState() // Final persistence before exit
 	logger.Always("Scheduler stopped safely.")
 }
 
@@ -120,12 +155,19 @@ func main() {
 func resultWorker() {
 	for res := range resultQueue {
 		mu.Lock()
+		// A spare in standby drops results of checks dispatched before its handback
+		if !serving() || !acceptResult(res) {
+			mu.Unlock()
+			continue
+		}
 		if strings.HasPrefix(res.ID, "HOST:") {
 			handleHostResult(res)
+		} else if strings.HasPrefix(res.ID, "EVENT:") {
+			handleEventResult(res)
 		} else {
 			handleServiceResult(res)
 		}
-		stateChanged = true
+		markResult(res.ID)
 		mu.Unlock()
 	}
 }
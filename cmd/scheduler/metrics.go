@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// histogram accumulates observations into cumulative Prometheus-style buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64 // One count per bound, the last one being +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// observe records a value in the first bucket that holds it
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// write prints the histogram in the Prometheus text format
+func (h *histogram) write(w http.ResponseWriter, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %.6f\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// Check performance histograms, guarded by mu
+var (
+	latencyHistogram   = newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60)
+	executionHistogram = newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30)
+)
+
+// recordLatency stores the scheduling latency of a dispatched check, must be called under mu.
+// Checks run ahead of their schedule have no latency and stay out of the histogram.
+func recordLatency(latency *float64, due, now time.Time) {
+	l := now.Sub(due).Seconds()
+	if l < 0 {
+		*latency = 0
+		return
+	}
+	*latency = l
+	latencyHistogram.observe(l)
+}
+
+// recordExecution stores the execution details of a poller result, must be called under mu
+func recordExecution(res models.CheckResult, executionTime *float64, poller *string) {
+	if res.PollerID == "" {
+		return // Passive and stale results did not run on a poller
+	}
+	*executionTime, *poller = res.ExecutionTime, res.PollerID
+	executionHistogram.observe(res.ExecutionTime)
+}
+
+// metricsHandler serves scheduling and execution metrics in Prometheus-style format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	mu.RLock()
+	defer mu.RUnlock()
+
+	totalOrphans := 0
+	for _, n := range orphans {
+		totalOrphans += n
+	}
+
+	fmt.Fprintf(w, "# Shinsakuto Scheduler Metrics\n")
+	fmt.Fprintf(w, "scheduler_process_uptime_seconds %.0f\n", time.Since(startTime).Seconds())
+	fmt.Fprintf(w, "scheduler_monitored_hosts_total %d\n", len(hosts))
+	fmt.Fprintf(w, "scheduler_monitored_services_total %d\n", len(services))
+	fmt.Fprintf(w, "scheduler_result_queue_length %d\n", len(resultQueue))
+	fmt.Fprintf(w, "scheduler_tasks_in_flight %d\n", len(inFlight))
+	fmt.Fprintf(w, "scheduler_tasks_orphaned_total %d\n", totalOrphans)
+	fmt.Fprintf(w, "scheduler_results_late_total %d\n", lateResults)
+	latencyHistogram.write(w, "scheduler_check_latency_seconds", "Delay between the scheduled time of a check and its dispatch.")
+	executionHistogram.write(w, "scheduler_check_execution_seconds", "Plugin execution time reported by the pollers.")
+}
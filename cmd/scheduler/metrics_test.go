@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveHistograms replaces the check histograms by empty ones for a test
+func saveHistograms(t *testing.T) {
+	l, e := latencyHistogram, executionHistogram
+	t.Cleanup(func() { latencyHistogram, executionHistogram = l, e })
+	latencyHistogram = newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60)
+	executionHistogram = newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30)
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogram(0.1, 1, 2.5)
+	// A value on a bound falls in that bound's bucket
+	for _, v := range []float64{0, 0.1, 0.5, 1, 1.0001, 2.5, 3, 120} {
+		h.observe(v)
+	}
+	w := httptest.NewRecorder()
+	h.write(w, "check_seconds", "Check duration.")
+	want := `# HELP check_seconds Check duration.
+# TYPE check_seconds histogram
+check_seconds_bucket{le="0.1"} 2
+check_seconds_bucket{le="1"} 4
+check_seconds_bucket{le="2.5"} 6
+check_seconds_bucket{le="+Inf"} 8
+check_seconds_sum 128.100100
+check_seconds_count 8
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRecordLatencyAndExecution(t *testing.T) {
+	saveHistograms(t)
+	now := time.Now()
+	var latency, execution float64
+	var poller string
+
+	recordLatency(&latency, now.Add(-300*time.Millisecond), now)
+	if latency != 0.3 || latencyHistogram.count != 1 {
+		t.Errorf("late check: latency %v, %d observed, want 0.3 and 1", latency, latencyHistogram.count)
+	}
+	// Checks run ahead of their schedule are not late
+	recordLatency(&latency, now.Add(time.Minute), now)
+	if latency != 0 || latencyHistogram.count != 1 {
+		t.Errorf("early check: latency %v, %d observed, want 0 and 1", latency, latencyHistogram.count)
+	}
+
+	recordExecution(models.CheckResult{PollerID: "p1", ExecutionTime: 1.5}, &execution, &poller)
+	if execution != 1.5 || poller != "p1" || executionHistogram.count != 1 {
+		t.Errorf("poller result: %v on %q, %d observed, want 1.5 on p1 and 1", execution, poller, executionHistogram.count)
+	}
+	// Passive and stale results keep the last execution details
+	recordExecution(models.CheckResult{Passive: true}, &execution, &poller)
+	if execution != 1.5 || poller != "p1" || executionHistogram.count != 1 {
+		t.Errorf("passive result: %v on %q, %d observed, want 1.5 on p1 and 1", execution, poller, executionHistogram.count)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	saveInventory(t)
+	saveHistograms(t)
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = make(map[string]*models.Service)
+	latencyHistogram.observe(0.2)
+	latencyHistogram.observe(45)
+	executionHistogram.observe(0.03)
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/v1/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"scheduler_monitored_hosts_total 1",
+		"scheduler_monitored_services_total 0",
+		`scheduler_check_latency_seconds_bucket{le="0.1"} 0`,
+		`scheduler_check_latency_seconds_bucket{le="0.25"} 1`,
+		`scheduler_check_latency_seconds_bucket{le="30"} 1`,
+		`scheduler_check_latency_seconds_bucket{le="60"} 2`,
+		`scheduler_check_latency_seconds_bucket{le="+Inf"} 2`,
+		"scheduler_check_latency_seconds_sum 45.200000",
+		"scheduler_check_latency_seconds_count 2",
+		`scheduler_check_execution_seconds_bucket{le="0.01"} 0`,
+		`scheduler_check_execution_seconds_bucket{le="0.05"} 1`,
+		`scheduler_check_execution_seconds_bucket{le="+Inf"} 1`,
+		"scheduler_check_execution_seconds_count 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+	if strings.Contains(body, `scheduler_check_execution_seconds_bucket{le="60"}`) {
+		t.Error("execution histogram has a 60s bucket, want 30s as its last bound")
+	}
+}
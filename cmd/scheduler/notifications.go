@@ -0,0 +1,167 @@
+package main
+
+import (
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// hostNotification builds a notification describing a host
+func hostNotification(h *models.Host, t string, prevState int, output string) models.NotificationRequest {
+	return models.NotificationRequest{
+		EntityID: h.ID, Type: t, HostName: h.ID,
+		State: h.CurrentState, StateName: hostStateName(h.CurrentState),
+		PreviousState: prevState, PreviousStateName: hostStateName(prevState),
+		NotificationNumber: h.NotificationNumber, Output: output,
+		Contacts: notificationContacts(h.Contacts, h.ContactGroups, nil),
+	}
+}
+
+// serviceNotification builds a notification describing a service
+func serviceNotification(s *models.Service, t string, prevState int, output string) models.NotificationRequest {
+	return models.NotificationRequest{
+		EntityID: s.Key(), Type: t, HostName: s.HostName, ServiceID: s.ID,
+		State: s.CurrentState, StateName: serviceStateName(s.CurrentState),
+		PreviousState: prevState, PreviousStateName: serviceStateName(prevState),
+		NotificationNumber: s.NotificationNumber, Output: output,
+		Contacts: notificationContacts(s.Contacts, s.ContactGroups, nil),
+	}
+}
+
+// objectNotification builds a notification for an event that does not change the
+// state of a "HOST" or "SERVICE" entity (flapping, downtimes). Objects whose
+// notifications are disabled have none.
+func objectNotification(entityType, id, t, output string) (models.NotificationRequest, bool) {
+	if entityType == "HOST" {
+		h, ok := hosts[id]
+		if !ok || !enabled(h.Overrides.Notifications) {
+			return models.NotificationRequest{}, false
+		}
+		return hostNotification(h, t, h.CurrentState, output), true
+	}
+	s, ok := services[id]
+	if !ok || !enabled(s.Overrides.Notifications) {
+		return models.NotificationRequest{}, false
+	}
+	return serviceNotification(s, t, s.CurrentState, output), true
+}
+
+// notificationDue reports whether a HARD problem must be (re-)notified: problems are
+// notified once, then every notification_interval (possibly escalated) when it is set
+func notificationDue(number int, last time.Time, interval int) bool {
+	return number == 0 || (interval > 0 && time.Since(last) >= intervalDuration(interval))
+}
+
+// notifyHostResult sends the PROBLEM or RECOVERY notification triggered by a host
+// result, and re-notifies persistent HARD problems. Must be called under mu.
+func notifyHostResult(h *models.Host, oldState int, hardChange bool, output string) {
+	now := time.Now()
+	interval := escalatedInterval(h.NotificationInterval, activeEscalations(h.Escalations, h.NotificationNumber, h.NotifiedSince, now))
+	recovery := hardChange && h.CurrentState == models.HostUp
+	problem := h.CurrentState != models.HostUp && h.StateType == models.StateTypeHard
+	if recovery && h.NotificationNumber == 0 {
+		// The problem was never notified, so there is nothing to recover from
+		return
+	}
+	if !recovery && !(problem && (hardChange || notificationDue(h.NotificationNumber, h.LastNotification, interval))) {
+		return
+	}
+
+	reason := ""
+	switch {
+	case !enabled(h.Overrides.Notifications):
+		reason = "notifications disabled"
+	case hostMasterFailing(h, hostNotificationCriteria):
+		reason = "notification dependency failing"
+	case !notificationAllowed(h.NotificationPeriod):
+		reason = "outside notification_period"
+	case h.InDowntime:
+		reason = "in downtime"
+	case h.IsFlapping:
+		reason = "flapping"
+	case !shouldNotifyHost(oldState, h.CurrentState):
+		reason = "UNREACHABLE notifications disabled"
+	}
+	if reason != "" {
+		if hardChange {
+			logger.Info("Notification for host %s suppressed: %s", h.ID, reason)
+		}
+		if recovery {
+			h.NotificationNumber, h.NotifiedSince = 0, time.Time{}
+		}
+		return
+	}
+
+	t := models.NotificationRecovery
+	if !recovery {
+		t = models.NotificationProblem
+		if h.NotificationNumber == 0 {
+			h.NotifiedSince = now
+		}
+		h.NotificationNumber++
+	}
+	h.LastNotification = now
+	n := hostNotification(h, t, oldState, output)
+	n.Contacts = notificationContacts(h.Contacts, h.ContactGroups,
+		activeEscalations(h.Escalations, h.NotificationNumber, h.NotifiedSince, now))
+	notifyReactionner(n)
+	if recovery {
+		h.NotificationNumber, h.NotifiedSince = 0, time.Time{}
+	}
+}
+
+// notifyServiceResult sends the PROBLEM or RECOVERY notification triggered by a service
+// result, and re-notifies persistent HARD problems. Must be called under mu.
+func notifyServiceResult(s *models.Service, oldState int, hardChange, inDowntime bool, output string) {
+	now := time.Now()
+	interval := escalatedInterval(s.NotificationInterval, activeEscalations(s.Escalations, s.NotificationNumber, s.NotifiedSince, now))
+	recovery := hardChange && s.CurrentState == 0
+	problem := s.CurrentState != 0 && s.StateType == models.StateTypeHard
+	if recovery && s.NotificationNumber == 0 {
+		return
+	}
+	if !recovery && !(problem && (hardChange || notificationDue(s.NotificationNumber, s.LastNotification, interval))) {
+		return
+	}
+
+	reason := ""
+	switch {
+	case !enabled(s.Overrides.Notifications):
+		reason = "notifications disabled"
+	case serviceMasterFailing(s, serviceNotificationCriteria):
+		reason = "notification dependency failing"
+	case !notificationAllowed(s.NotificationPeriod):
+		reason = "outside notification_period"
+	case inDowntime:
+		reason = "in downtime"
+	case s.IsFlapping:
+		reason = "flapping"
+	}
+	if reason != "" {
+		if hardChange {
+			logger.Info("Notification for service %s suppressed: %s", s.Key(), reason)
+		}
+		if recovery {
+			s.NotificationNumber, s.NotifiedSince = 0, time.Time{}
+		}
+		return
+	}
+
+	t := models.NotificationRecovery
+	if !recovery {
+		t = models.NotificationProblem
+		if s.NotificationNumber == 0 {
+			s.NotifiedSince = now
+		}
+		s.NotificationNumber++
+	}
+	s.LastNotification = now
+	n := serviceNotification(s, t, oldState, output)
+	n.Contacts = notificationContacts(s.Contacts, s.ContactGroups,
+		activeEscalations(s.Escalations, s.NotificationNumber, s.NotifiedSince, now))
+	notifyReactionner(n)
+	if recovery {
+		s.NotificationNumber, s.NotifiedSince = 0, time.Time{}
+	}
+}
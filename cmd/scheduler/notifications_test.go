@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// lastEventID returns the ID of the most recent event published on the stream
+func lastEventID() uint64 {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return streamSeq
+}
+
+// sentNotifications lists the notifications published after an event ID, as "TYPE#number"
+// with the current notification number of the object
+func sentNotifications(since uint64, number func() int) []string {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	var sent []string
+	for _, e := range streamRing {
+		if e.ID > since && e.Type == eventNotification {
+			sent = append(sent, fmt.Sprintf("%s#%d", e.NotificationType, number()))
+		}
+	}
+	return sent
+}
+
+// notificationService installs a single HARD-on-first-failure service
+func notificationService(t *testing.T, interval int) *models.Service {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	s := &models.Service{
+		ID: "http", HostName: "web1", CheckCommand: "check_http", StateType: models.StateTypeHard, Attempts: 1,
+		MaxAttempts: 1, NotificationInterval: interval,
+	}
+	hosts = map[string]*models.Host{"web1": {ID: "web1"}}
+	services = map[string]*models.Service{s.Key(): s}
+	downtimes = nil
+	checks = newCheckQueue()
+	return s
+}
+
+func TestNotificationNumbering(t *testing.T) {
+	s := notificationService(t, 600)
+	steps := []struct {
+		name       string
+		status     int
+		elapsed    time.Duration // Moves the last notification back before the result
+		want       string
+		wantNumber int
+	}{
+		{"first HARD problem", 2, 0, "[PROBLEM#1]", 1},
+		{"problem persists within the interval", 2, 0, "[]", 1},
+		{"problem persists past the interval", 2, 601 * time.Second, "[PROBLEM#2]", 2},
+		{"HARD state change is notified at once", 1, 0, "[PROBLEM#3]", 3},
+		{"recovery", 0, 0, "[RECOVERY#0]", 0},
+		{"ok stays ok", 0, 601 * time.Second, "[]", 0},
+		{"new problem starts over", 2, 0, "[PROBLEM#1]", 1},
+	}
+	for _, st := range steps {
+		s.LastNotification = s.LastNotification.Add(-st.elapsed)
+		since := lastEventID()
+		handleServiceResult(models.CheckResult{ID: s.Key(), Status: st.status, Output: "output"})
+		got := fmt.Sprint(sentNotifications(since, func() int { return s.NotificationNumber }))
+		if got != st.want || s.NotificationNumber != st.wantNumber {
+			t.Errorf("%s: sent %s, number %d, want %s, %d", st.name, got, s.NotificationNumber, st.want, st.wantNumber)
+		}
+	}
+}
+
+func TestNotificationWithoutInterval(t *testing.T) {
+	s := notificationService(t, 0)
+	var sent []string
+	for i := 0; i < 3; i++ {
+		s.LastNotification = s.LastNotification.Add(-time.Hour)
+		since := lastEventID()
+		handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"})
+		sent = append(sent, sentNotifications(since, func() int { return s.NotificationNumber })...)
+	}
+	if got := fmt.Sprint(sent); got != "[PROBLEM#1]" {
+		t.Errorf("notification_interval 0 sent %s, want a single PROBLEM", got)
+	}
+}
+
+func TestUnnotifiedProblemHasNoRecovery(t *testing.T) {
+	s := notificationService(t, 600)
+	s.MaxAttempts = 3
+	since := lastEventID()
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 2, Output: "CRITICAL"}) // SOFT 1/3
+	handleServiceResult(models.CheckResult{ID: s.Key(), Status: 0, Output: "OK"})
+	if got := sentNotifications(since, func() int { return s.NotificationNumber }); len(got) != 0 {
+		t.Errorf("SOFT problem and recovery sent %v, want nothing", got)
+	}
+}
+
+func TestNotificationDue(t *testing.T) {
+	saveInventory(t)
+	defaultConfig(t, "minutes")
+	now := time.Now()
+	tests := []struct {
+		name     string
+		number   int
+		last     time.Time
+		interval int
+		want     bool
+	}{
+		{"never notified", 0, time.Time{}, 0, true},
+		{"notified once, no interval", 1, now.Add(-24 * time.Hour), 0, false},
+		{"within the interval", 1, now.Add(-9 * time.Minute), 10, false},
+		{"interval elapsed", 1, now.Add(-10 * time.Minute), 10, true},
+		{"later notifications", 5, now.Add(-11 * time.Minute), 10, true},
+	}
+	for _, tt := range tests {
+		if got := notificationDue(tt.number, tt.last, tt.interval); got != tt.want {
+			t.Errorf("%s: notificationDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
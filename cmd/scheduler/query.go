@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"shinsakuto/pkg/models"
+)
+
+// queryRow is a copy of an object taken under mu, with the attributes used by filters
+type queryRow struct {
+	key           string
+	object        interface{} // *models.Host or *models.Service, never shared with the scheduler
+	state         int
+	stateName     string
+	stateType     string
+	hostName      string
+	hostGroups    []string
+	serviceGroups []string // Of the service, or of every service of the host
+	inDowntime    bool
+	acknowledged  bool
+	flapping      bool
+	fields        map[string]json.RawMessage // Decoded on demand for columns and sorting
+}
+
+// query holds the parsed parameters of a /v1/query request
+type query struct {
+	states       map[int]bool
+	stateType    string
+	hostGroup    string
+	serviceGroup string
+	hostName     string
+	name         *regexp.Regexp
+	inDowntime   *bool
+	acknowledged *bool
+	flapping     *bool
+	columns      []string
+	sortKeys     []string
+	limit        int
+	offset       int
+	stats        bool
+}
+
+// queryStats aggregates the rows matching the filters, before pagination
+type queryStats struct {
+	Total        int            `json:"total"`
+	States       map[string]int `json:"states"`
+	Hard         int            `json:"hard"`
+	Soft         int            `json:"soft"`
+	InDowntime   int            `json:"in_downtime"`
+	Acknowledged int            `json:"acknowledged"`
+	Flapping     int            `json:"flapping"`
+}
+
+// Column names accepted by each table, taken from the JSON tags of the models
+var (
+	hostColumns    = jsonFields(models.Host{})
+	serviceColumns = jsonFields(models.Service{})
+)
+
+// queryHandler serves /v1/query/hosts and /v1/query/services. The objects are
+// copied under the read lock and filtered, sorted and encoded after releasing
+// it, so large queries do not hold up result processing.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	table := strings.TrimPrefix(r.URL.Path, "/v1/query/")
+	columns := hostColumns
+	if table == "services" {
+		columns = serviceColumns
+	} else if table != "hosts" {
+		http.Error(w, "Unknown table: "+table, http.StatusNotFound)
+		return
+	}
+	q, err := parseQuery(r.URL.Query(), table, columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows []*queryRow
+	if table == "hosts" {
+		rows = snapshotHosts()
+	} else {
+		rows = snapshotServices()
+	}
+
+	matched := rows[:0]
+	for _, row := range rows {
+		if q.match(row) {
+			matched = append(matched, row)
+		}
+	}
+	sortRows(matched, q.sortKeys)
+
+	resp := map[string]interface{}{"table": table, "total": len(matched), "offset": q.offset, "limit": q.limit}
+	if q.stats {
+		resp["stats"] = computeStats(matched)
+	}
+	page := paginate(matched, q.offset, q.limit)
+	items := make([]interface{}, 0, len(page))
+	for _, row := range page {
+		items = append(items, row.project(q.columns))
+	}
+	resp["items"] = items
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// snapshotHosts copies every host under the read lock. A host belongs to the
+// service groups of its services, so servicegroup= selects the hosts of a group.
+func snapshotHosts() []*queryRow {
+	mu.RLock()
+	defer mu.RUnlock()
+	groups := make(map[string][]string)
+	for _, s := range services {
+		for _, g := range s.ServiceGroups {
+			if !contains(groups[s.HostName], g) {
+				groups[s.HostName] = append(groups[s.HostName], g)
+			}
+		}
+	}
+	rows := make([]*queryRow, 0, len(hosts))
+	for _, h := range hosts {
+		c := *h
+		c.StateHistory = append([]int(nil), h.StateHistory...)
+		c.Perfdata, c.Overrides = copyPerfdata(h.Perfdata), copyOverrides(h.Overrides)
+		rows = append(rows, &queryRow{
+			key: h.ID, object: &c, state: h.CurrentState, stateName: hostStateName(h.CurrentState),
+			stateType: h.StateType, hostName: h.ID, hostGroups: h.HostGroups, serviceGroups: groups[h.ID],
+			inDowntime: h.InDowntime, acknowledged: h.Acknowledged, flapping: h.IsFlapping,
+		})
+	}
+	return rows
+}
+
+// snapshotServices copies every service under the read lock, with the groups of its host
+func snapshotServices() []*queryRow {
+	mu.RLock()
+	defer mu.RUnlock()
+	rows := make([]*queryRow, 0, len(services))
+	for key, s := range services {
+		c := *s
+		c.StateHistory = append([]int(nil), s.StateHistory...)
+		c.Perfdata, c.Overrides = copyPerfdata(s.Perfdata), copyOverrides(s.Overrides)
+		row := &queryRow{
+			key: key, object: &c, state: s.CurrentState, stateName: serviceStateName(s.CurrentState),
+			stateType: s.StateType, hostName: s.HostName, serviceGroups: s.ServiceGroups,
+			inDowntime: s.InDowntime, acknowledged: s.Acknowledged, flapping: s.IsFlapping,
+		}
+		if h, ok := hosts[s.HostName]; ok {
+			row.hostGroups = h.HostGroups
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// copyPerfdata copies the metrics of an object with their bounds
+func copyPerfdata(metrics []models.PerfMetric) []models.PerfMetric {
+	if metrics == nil {
+		return nil
+	}
+	out := make([]models.PerfMetric, len(metrics))
+	for i, m := range metrics {
+		if m.Min != nil {
+			v := *m.Min
+			m.Min = &v
+		}
+		if m.Max != nil {
+			v := *m.Max
+			m.Max = &v
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// copyOverrides copies the runtime settings of an object
+func copyOverrides(o models.Overrides) models.Overrides {
+	for _, p := range []**bool{&o.ActiveChecks, &o.PassiveChecks, &o.Notifications, &o.EventHandlers, &o.FlapDetection} {
+		if *p != nil {
+			v := **p
+			*p = &v
+		}
+	}
+	return o
+}
+
+// parseQuery reads the filters, columns, sort keys and pagination of a request.
+//
+//	state=DOWN,2  state_type=HARD  hostgroup=web  servicegroup=db  host_name=srv1
+//	name=<regexp>  in_downtime=true  acknowledged=false  flapping=true
+//	columns=id,current_state  sort=-last_check,id  limit=50  offset=100  stats=true
+func parseQuery(v url.Values, table string, columns map[string]bool) (*query, error) {
+	q := &query{
+		stateType:    strings.ToUpper(v.Get("state_type")),
+		hostGroup:    v.Get("hostgroup"),
+		serviceGroup: v.Get("servicegroup"),
+		hostName:     v.Get("host_name"),
+	}
+	if s := v.Get("state"); s != "" {
+		q.states = make(map[int]bool)
+		for _, item := range strings.Split(s, ",") {
+			state, ok := parseState(strings.TrimSpace(item), table)
+			if !ok {
+				return nil, fmt.Errorf("invalid state %q", item)
+			}
+			q.states[state] = true
+		}
+	}
+	if s := v.Get("name"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name regexp: %v", err)
+		}
+		q.name = re
+	}
+
+	var err error
+	if q.inDowntime, err = parseFlag(v, "in_downtime"); err != nil {
+		return nil, err
+	}
+	if q.acknowledged, err = parseFlag(v, "acknowledged"); err != nil {
+		return nil, err
+	}
+	if q.flapping, err = parseFlag(v, "flapping"); err != nil {
+		return nil, err
+	}
+
+	if s := v.Get("columns"); s != "" {
+		for _, c := range strings.Split(s, ",") {
+			c = strings.TrimSpace(c)
+			if !columns[c] {
+				return nil, fmt.Errorf("unknown column %q", c)
+			}
+			q.columns = append(q.columns, c)
+		}
+	}
+	if s := v.Get("sort"); s != "" {
+		for _, k := range strings.Split(s, ",") {
+			k = strings.TrimSpace(k)
+			if !columns[strings.TrimPrefix(k, "-")] {
+				return nil, fmt.Errorf("unknown sort column %q", k)
+			}
+			q.sortKeys = append(q.sortKeys, k)
+		}
+	}
+	if q.limit, err = parseCount(v, "limit"); err != nil {
+		return nil, err
+	}
+	if q.offset, err = parseCount(v, "offset"); err != nil {
+		return nil, err
+	}
+	q.stats = v.Get("stats") == "true"
+	return q, nil
+}
+
+// parseState accepts a numeric state or its name for the table
+func parseState(s, table string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	for state := 0; state <= 3; state++ {
+		name := serviceStateName(state)
+		if table == "hosts" {
+			name = hostStateName(state)
+		}
+		if strings.EqualFold(s, name) {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+// parseFlag reads an optional boolean filter
+func parseFlag(v url.Values, name string) (*bool, error) {
+	s := v.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", name, s)
+	}
+	return &b, nil
+}
+
+// parseCount reads an optional non-negative integer
+func parseCount(v url.Values, name string) (int, error) {
+	s := v.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, s)
+	}
+	return n, nil
+}
+
+// match applies every filter of the query to a row
+func (q *query) match(row *queryRow) bool {
+	if q.states != nil && !q.states[row.state] {
+		return false
+	}
+	if q.stateType != "" && row.stateType != q.stateType {
+		return false
+	}
+	if q.hostGroup != "" && !contains(row.hostGroups, q.hostGroup) {
+		return false
+	}
+	if q.serviceGroup != "" && !contains(row.serviceGroups, q.serviceGroup) {
+		return false
+	}
+	if q.hostName != "" && row.hostName != q.hostName {
+		return false
+	}
+	if q.name != nil && !q.name.MatchString(row.key) {
+		return false
+	}
+	if q.inDowntime != nil && row.inDowntime != *q.inDowntime {
+		return false
+	}
+	if q.acknowledged != nil && row.acknowledged != *q.acknowledged {
+		return false
+	}
+	if q.flapping != nil && row.flapping != *q.flapping {
+		return false
+	}
+	return true
+}
+
+// contains reports whether a list holds a value
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// decoded returns the JSON fields of the object of a row
+func (row *queryRow) decoded() map[string]json.RawMessage {
+	if row.fields == nil {
+		data, _ := json.Marshal(row.object)
+		json.Unmarshal(data, &row.fields)
+	}
+	return row.fields
+}
+
+// project returns the object, or only the requested columns
+func (row *queryRow) project(columns []string) interface{} {
+	if len(columns) == 0 {
+		return row.object
+	}
+	fields := row.decoded()
+	out := make(map[string]json.RawMessage, len(columns))
+	for _, c := range columns {
+		if v, ok := fields[c]; ok {
+			out[c] = v
+		} else {
+			out[c] = json.RawMessage("null") // Omitted empty value
+		}
+	}
+	return out
+}
+
+// sortRows orders rows by the given columns ("-" for descending), then by identity
+func sortRows(rows []*queryRow, keys []string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			desc := strings.HasPrefix(k, "-")
+			col := strings.TrimPrefix(k, "-")
+			c := compareJSON(rows[i].decoded()[col], rows[j].decoded()[col])
+			if c != 0 {
+				return (c < 0) != desc
+			}
+		}
+		return rows[i].key < rows[j].key
+	})
+}
+
+// compareJSON orders two JSON values: numbers numerically, booleans false first,
+// anything else (strings, RFC 3339 times) by its text
+func compareJSON(a, b json.RawMessage) int {
+	var fa, fb float64
+	if json.Unmarshal(a, &fa) == nil && json.Unmarshal(b, &fb) == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	var sa, sb string
+	if json.Unmarshal(a, &sa) == nil && json.Unmarshal(b, &sb) == nil {
+		return strings.Compare(sa, sb)
+	}
+	return bytes.Compare(a, b)
+}
+
+// paginate returns the page of rows selected by offset and limit (0: no limit)
+func paginate(rows []*queryRow, offset, limit int) []*queryRow {
+	if offset >= len(rows) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// computeStats counts the matching rows per state name and flag
+func computeStats(rows []*queryRow) queryStats {
+	st := queryStats{Total: len(rows), States: make(map[string]int)}
+	for _, row := range rows {
+		st.States[row.stateName]++
+		if row.stateType == models.StateTypeSoft {
+			st.Soft++
+		} else {
+			st.Hard++
+		}
+		if row.inDowntime {
+			st.InDowntime++
+		}
+		if row.acknowledged {
+			st.Acknowledged++
+		}
+		if row.flapping {
+			st.Flapping++
+		}
+	}
+	return st
+}
+
+// jsonFields lists the JSON names of the fields of a struct
+func jsonFields(v interface{}) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
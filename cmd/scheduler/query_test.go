@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+// queryInventory is the Livestatus inventory with flags and service groups:
+//
+//	db1   UP                      disk CRITICAL SOFT (storage), mysql OK (storage)
+//	web1  UP    in downtime       disk WARNING, http OK (frontend)
+//	web2  DOWN  flapping, acked   http CRITICAL flapping, acked (frontend)
+func queryInventory(tb testing.TB) {
+	lsInventory(tb)
+	hosts["web1"].InDowntime = true
+	hosts["web2"].IsFlapping, hosts["web2"].Acknowledged = true, true
+	for key, s := range services {
+		s.StateType = models.StateTypeHard
+		switch key {
+		case "db1/disk":
+			s.StateType, s.ServiceGroups = models.StateTypeSoft, []string{"storage"}
+		case "db1/mysql":
+			s.ServiceGroups = []string{"storage"}
+		case "web1/http":
+			s.ServiceGroups = []string{"frontend"}
+		case "web2/http":
+			s.ServiceGroups, s.IsFlapping, s.Acknowledged = []string{"frontend"}, true, true
+		}
+	}
+	for _, h := range hosts {
+		h.StateType = models.StateTypeHard
+	}
+}
+
+// runQuery calls the query handler and returns the status and decoded response
+func runQuery(t *testing.T, target string) (int, map[string]json.RawMessage) {
+	w := httptest.NewRecorder()
+	queryHandler(w, httptest.NewRequest("GET", target, nil))
+	var resp map[string]json.RawMessage
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+	}
+	return w.Code, resp
+}
+
+// itemKeys lists the identity of the returned objects: the host ID or host/service key
+func itemKeys(resp map[string]json.RawMessage) string {
+	var items []struct {
+		ID       string `json:"id"`
+		HostName string `json:"host_name"`
+	}
+	json.Unmarshal(resp["items"], &items)
+	keys := make([]string, 0, len(items))
+	for _, it := range items {
+		if it.HostName != "" {
+			keys = append(keys, it.HostName+"/"+it.ID)
+		} else {
+			keys = append(keys, it.ID)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestQueries(t *testing.T) {
+	queryInventory(t)
+	tests := []struct {
+		name   string
+		target string
+		want   string
+		total  int
+	}{
+		{"all hosts", "/v1/query/hosts", "db1,web1,web2", 3},
+		{"state name", "/v1/query/hosts?state=DOWN", "web2", 1},
+		{"state names and numbers", "/v1/query/services?state=warning,2", "db1/disk,web1/disk,web2/http", 3},
+		{"state type", "/v1/query/services?state_type=soft", "db1/disk", 1},
+		{"host group", "/v1/query/services?hostgroup=web", "web1/disk,web1/http,web2/http", 3},
+		{"service group", "/v1/query/services?servicegroup=storage", "db1/disk,db1/mysql", 2},
+		{"hosts with a service in the group", "/v1/query/hosts?servicegroup=frontend", "web1,web2", 2},
+		{"unknown service group", "/v1/query/hosts?servicegroup=none", "", 0},
+		{"host name", "/v1/query/services?host_name=web1", "web1/disk,web1/http", 2},
+		{"name regexp", "/v1/query/services?name=^web./http$", "web1/http,web2/http", 2},
+		{"in downtime", "/v1/query/hosts?in_downtime=true", "web1", 1},
+		{"not flapping", "/v1/query/hosts?flapping=false", "db1,web1", 2},
+		{"acknowledged", "/v1/query/services?acknowledged=true", "web2/http", 1},
+		{"unacknowledged problems", "/v1/query/services?acknowledged=0&state=1,2", "db1/disk,web1/disk", 2},
+		{"combined filters", "/v1/query/services?hostgroup=web&state=2&flapping=true", "web2/http", 1},
+
+		{"sort descending", "/v1/query/hosts?sort=-current_state", "web2,db1,web1", 3},
+		{"sort by two columns", "/v1/query/services?sort=-current_state,-latency", "web2/http,db1/disk,web1/disk,db1/mysql,web1/http", 5},
+		{"sort by text", "/v1/query/services?sort=id,-host_name", "web1/disk,db1/disk,web2/http,web1/http,db1/mysql", 5},
+
+		{"limit", "/v1/query/services?limit=2", "db1/disk,db1/mysql", 5},
+		{"offset", "/v1/query/services?offset=3", "web1/http,web2/http", 5},
+		{"page", "/v1/query/services?sort=-latency&offset=1&limit=2", "web1/disk,db1/mysql", 5},
+		{"offset past the end", "/v1/query/hosts?offset=10", "", 3},
+	}
+	for _, tt := range tests {
+		code, resp := runQuery(t, tt.target)
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, code, http.StatusOK)
+			continue
+		}
+		if got := itemKeys(resp); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		var total int
+		json.Unmarshal(resp["total"], &total)
+		if total != tt.total {
+			t.Errorf("%s: got total %d, want %d", tt.name, total, tt.total)
+		}
+	}
+}
+
+func TestQueryColumns(t *testing.T) {
+	queryInventory(t)
+	_, resp := runQuery(t, "/v1/query/hosts?columns=id,current_state,long_output&host_name=web2")
+	var items []map[string]interface{}
+	json.Unmarshal(resp["items"], &items)
+	want := []map[string]interface{}{{"id": "web2", "current_state": float64(1), "long_output": nil}}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("got %v, want %v", items, want)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	queryInventory(t)
+	tests := []struct {
+		target string
+		want   queryStats
+	}{
+		{"/v1/query/services?stats=true", queryStats{
+			Total: 5, States: map[string]int{"OK": 2, "WARNING": 1, "CRITICAL": 2}, Hard: 4, Soft: 1, Acknowledged: 1, Flapping: 1,
+		}},
+		{"/v1/query/hosts?stats=true&limit=1", queryStats{
+			Total: 3, States: map[string]int{"UP": 2, "DOWN": 1}, Hard: 3, InDowntime: 1, Acknowledged: 1, Flapping: 1,
+		}},
+		{"/v1/query/hosts?stats=true&state=3", queryStats{States: map[string]int{}}},
+	}
+	for _, tt := range tests {
+		_, resp := runQuery(t, tt.target)
+		var got queryStats
+		if err := json.Unmarshal(resp["stats"], &got); err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+	if _, resp := runQuery(t, "/v1/query/hosts"); resp["stats"] != nil {
+		t.Errorf("stats returned without stats=true")
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	queryInventory(t)
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/v1/query/contacts", http.StatusNotFound},
+		{"/v1/query/hosts?state=WARNING", http.StatusBadRequest},
+		{"/v1/query/hosts?name=(", http.StatusBadRequest},
+		{"/v1/query/hosts?in_downtime=maybe", http.StatusBadRequest},
+		{"/v1/query/services?acknowledged=yes", http.StatusBadRequest},
+		{"/v1/query/hosts?columns=id,nope", http.StatusBadRequest},
+		{"/v1/query/hosts?columns=host_name", http.StatusBadRequest},
+		{"/v1/query/services?sort=-nope", http.StatusBadRequest},
+		{"/v1/query/services?limit=-1", http.StatusBadRequest},
+		{"/v1/query/services?offset=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := runQuery(t, tt.target); code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.target, code, tt.want)
+		}
+	}
+}
+
+func TestQuerySnapshotIsolation(t *testing.T) {
+	queryInventory(t)
+	off, max := false, 100.0
+	h := hosts["db1"]
+	h.Perfdata = []models.PerfMetric{{Label: "rta", Value: 1, Max: &max}}
+	h.Overrides.Notifications = &off
+
+	var row *queryRow
+	for _, r := range snapshotHosts() {
+		if r.key == "db1" {
+			row = r
+		}
+	}
+	// The scheduler keeps updating its objects while the response is encoded
+	*h.Perfdata[0].Max, *h.Overrides.Notifications = 200, true
+	h.Perfdata[0].Value = 2
+
+	c := row.object.(*models.Host)
+	if got := c.Perfdata[0]; got.Value != 1 || *got.Max != 100 {
+		t.Errorf("perfdata changed in the snapshot: value %v, max %v", got.Value, *got.Max)
+	}
+	if *c.Overrides.Notifications {
+		t.Errorf("overrides changed in the snapshot")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// checks is the time-ordered queue of pending checks, guarded by mu
+var checks = newCheckQueue()
+
+// checkItem is a single scheduled check, identified by its task ID
+type checkItem struct {
+	key       string
+	nextCheck time.Time
+	index     int
+}
+
+// checkQueue is a min-heap of checks keyed on NextCheck with O(1) lookup by task ID.
+// Pops and reschedules are O(log n) so dispatch cost does not grow with the inventory.
+type checkQueue struct {
+	items []*checkItem
+	byKey map[string]*checkItem
+}
+
+func newCheckQueue() *checkQueue {
+	return &checkQueue{byKey: make(map[string]*checkItem)}
+}
+
+// heap.Interface implementation
+func (q *checkQueue) Len() int { return len(q.items) }
+func (q *checkQueue) Less(i, j int) bool {
+	return q.items[i].nextCheck.Before(q.items[j].nextCheck)
+}
+func (q *checkQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+func (q *checkQueue) Push(x interface{}) {
+	it := x.(*checkItem)
+	it.index = len(q.items)
+	q.items = append(q.items, it)
+}
+func (q *checkQueue) Pop() interface{} {
+	old := q.items
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	q.items = old[:n-1]
+	it.index = -1
+	return it
+}
+
+// schedule inserts a check or moves an existing one to a new due time
+func (q *checkQueue) schedule(key string, at time.Time) {
+	if it, ok := q.byKey[key]; ok {
+		it.nextCheck = at
+		heap.Fix(q, it.index)
+		return
+	}
+	it := &checkItem{key: key, nextCheck: at}
+	heap.Push(q, it)
+	q.byKey[key] = it
+}
+
+// due returns the time a check is queued at, if it is queued
+func (q *checkQueue) due(key string) (time.Time, bool) {
+	if it, ok := q.byKey[key]; ok {
+		return it.nextCheck, true
+	}
+	return time.Time{}, false
+}
+
+// popDue removes and returns the most overdue check, if any is due at 'now'
+func (q *checkQueue) popDue(now time.Time) (string, bool) {
+	if len(q.items) == 0 || !now.After(q.items[0].nextCheck) {
+		return "", false
+	}
+	it := heap.Pop(q).(*checkItem)
+	delete(q.byKey, it.key)
+	return it.key, true
+}
+
+// rebuildQueue recreates the check queue from the host and service maps.
+// Called under mu after a sync-all or a state restore.
+func rebuildQueue() {
+	q := newCheckQueue()
+	for _, h := range hosts {
+		if hostActive(h) {
+			it := &checkItem{key: "HOST:" + h.ID, nextCheck: h.NextCheck, index: len(q.items)}
+			q.items = append(q.items, it)
+			q.byKey[it.key] = it
+		}
+	}
+	for _, s := range services {
+		if serviceActive(s) {
+			it := &checkItem{key: s.Key(), nextCheck: s.NextCheck, index: len(q.items)}
+			q.items = append(q.items, it)
+			q.byKey[it.key] = it
+		}
+	}
+	heap.Init(q)
+	checks = q
+
+	// Pending freshness and forced checks must survive the rebuild
+	for key := range freshnessPending {
+		checks.schedule(key, time.Now().Add(-time.Millisecond))
+	}
+	for key, at := range forcedChecks {
+		checks.schedule(key, at)
+	}
+}
+
+// scheduleHost (re)queues a host at its current NextCheck
+func scheduleHost(h *models.Host) {
+	if hostActive(h) {
+		checks.schedule("HOST:"+h.ID, h.NextCheck)
+	}
+}
+
+// scheduleService (re)queues a service at its current NextCheck
+func scheduleService(s *models.Service) {
+	if serviceActive(s) {
+		checks.schedule(s.Key(), s.NextCheck)
+	}
+}
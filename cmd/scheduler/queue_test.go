@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// saveInventory restores the scheduler maps, the check queue and the configuration
+// once the test or benchmark ends, so that tests do not depend on their order
+func saveInventory(tb testing.TB) {
+	h, s, q, d, ds, cfg := hosts, services, checks, downtimes, schedules, appConfig
+	c, cg, e := contacts, contactGroups, escalations
+	tb.Cleanup(func() {
+		hosts, services, checks, downtimes, schedules, appConfig = h, s, q, d, ds, cfg
+		contacts, contactGroups, escalations = c, cg, e
+	})
+}
+
+// populate fills the scheduler maps with n services spread over 10 hosts, all overdue
+func populate(tb testing.TB, n int) {
+	saveInventory(tb)
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("host-%d", i)
+		hosts[id] = &models.Host{ID: id, CheckCommand: "check_ping", NextCheck: base}
+	}
+	for i := 0; i < n; i++ {
+		s := &models.Service{
+			ID: fmt.Sprintf("svc-%d", i), HostName: fmt.Sprintf("host-%d", i%10), CheckCommand: "check_http",
+			NextCheck: base.Add(time.Duration(i) * time.Millisecond),
+		}
+		services[s.Key()] = s
+	}
+	rebuildQueue()
+}
+
+func benchmarkPopTask(b *testing.B, n int) {
+	populate(b, n)
+	req := httptest.NewRequest("GET", "/v1/pop-task", nil)
+	// Every dispatched check goes back to the end of the overdue checks,
+	// so the queue stays saturated with due checks for the whole run.
+	overdue := time.Now().Add(-30 * time.Minute)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := checks.items[0].key
+		popTaskHandler(httptest.NewRecorder(), req)
+		checks.schedule(key, overdue)
+		overdue = overdue.Add(time.Microsecond)
+	}
+}
+
+func BenchmarkPopTask10k(b *testing.B)  { benchmarkPopTask(b, 10000) }
+func BenchmarkPopTask100k(b *testing.B) { benchmarkPopTask(b, 100000) }
+
+func benchmarkReschedule(b *testing.B, n int) {
+	populate(b, n)
+	now := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		checks.schedule(models.ServiceKey(fmt.Sprintf("host-%d", i%n%10), fmt.Sprintf("svc-%d", i%n)), now.Add(time.Duration(i)*time.Millisecond))
+	}
+}
+
+func BenchmarkReschedule10k(b *testing.B)  { benchmarkReschedule(b, 10000) }
+func BenchmarkReschedule100k(b *testing.B) { benchmarkReschedule(b, 100000) }
+
+func benchmarkRebuild(b *testing.B, n int) {
+	populate(b, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rebuildQueue()
+	}
+}
+
+func BenchmarkRebuild10k(b *testing.B)  { benchmarkRebuild(b, 10000) }
+func BenchmarkRebuild100k(b *testing.B) { benchmarkRebuild(b, 100000) }
+
+// drain pops every check due at 'now', in dispatch order
+func drain(q *checkQueue, now time.Time) []string {
+	var keys []string
+	for {
+		key, ok := q.popDue(now)
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+// queuedAt returns the time a check is queued at, if it is queued
+func queuedAt(q *checkQueue, key string) (time.Time, bool) {
+	if it, ok := q.byKey[key]; ok {
+		return it.nextCheck, true
+	}
+	return time.Time{}, false
+}
+
+func TestQueueOrdersByNextCheck(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	q := newCheckQueue()
+	for i, offset := range []int{5, 1, 4, 2, 3} {
+		q.schedule(fmt.Sprintf("c%d", i), base.Add(time.Duration(offset)*time.Second))
+	}
+	got := fmt.Sprint(drain(q, time.Now()))
+	if want := "[c1 c3 c4 c2 c0]"; got != want {
+		t.Errorf("dispatch order = %s, want %s", got, want)
+	}
+}
+
+func TestQueuePopDueOnlyReturnsDueChecks(t *testing.T) {
+	now := time.Now()
+	q := newCheckQueue()
+	q.schedule("past", now.Add(-time.Second))
+	q.schedule("future", now.Add(time.Minute))
+
+	if got := fmt.Sprint(drain(q, now)); got != "[past]" {
+		t.Errorf("due checks = %s, want [past]", got)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("queue length = %d, want 1", q.Len())
+	}
+	if at, ok := queuedAt(q, "future"); !ok || !at.Equal(now.Add(time.Minute)) {
+		t.Errorf("future check queued at %v (%v), want %v", at, ok, now.Add(time.Minute))
+	}
+}
+
+func TestQueueRescheduleMovesExistingKey(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	q := newCheckQueue()
+	q.schedule("a", base.Add(1*time.Second))
+	q.schedule("b", base.Add(2*time.Second))
+	q.schedule("c", base.Add(3*time.Second))
+
+	// Moving "a" behind "c", then "c" ahead of everything
+	q.schedule("a", base.Add(4*time.Second))
+	q.schedule("c", base)
+
+	if q.Len() != 3 || len(q.byKey) != 3 {
+		t.Fatalf("queue holds %d items and %d keys after reschedules, want 3", q.Len(), len(q.byKey))
+	}
+	if at, _ := queuedAt(q, "a"); !at.Equal(base.Add(4 * time.Second)) {
+		t.Errorf("a queued at %v, want %v", at, base.Add(4*time.Second))
+	}
+	if got := fmt.Sprint(drain(q, time.Now())); got != "[c b a]" {
+		t.Errorf("dispatch order = %s, want [c b a]", got)
+	}
+}
+
+func TestQueuePopRemovesKey(t *testing.T) {
+	now := time.Now()
+	q := newCheckQueue()
+	q.schedule("a", now.Add(-time.Second))
+	q.schedule("b", now.Add(time.Minute))
+
+	if key, ok := q.popDue(now); !ok || key != "a" {
+		t.Fatalf("popDue = %q, %v, want a", key, ok)
+	}
+	if _, ok := queuedAt(q, "a"); ok {
+		t.Error("popped check is still indexed")
+	}
+	if _, ok := q.popDue(now); ok {
+		t.Error("popDue returned a check that is not due")
+	}
+
+	// A popped key can be queued again as a new item
+	q.schedule("a", now.Add(-time.Second))
+	if q.Len() != 2 {
+		t.Errorf("queue length = %d, want 2", q.Len())
+	}
+	for i, it := range q.items {
+		if it.index != i || q.byKey[it.key] != it {
+			t.Errorf("item %s has index %d at position %d", it.key, it.index, i)
+		}
+	}
+}
+
+func TestRebuildQueueDropsRemovedAndPassiveObjects(t *testing.T) {
+	populate(t, 20)
+	delete(hosts, "host-3")
+	delete(services, models.ServiceKey("host-1", "svc-1"))
+	passive := services[models.ServiceKey("host-2", "svc-2")]
+	passive.CheckCommand = ""
+	rebuildQueue()
+
+	if want := 9 + 18; checks.Len() != want {
+		t.Errorf("queue length = %d, want %d", checks.Len(), want)
+	}
+	for _, key := range []string{"HOST:host-3", "host-1/svc-1", "host-2/svc-2"} {
+		if _, ok := queuedAt(checks, key); ok {
+			t.Errorf("%s is still queued", key)
+		}
+	}
+	if _, ok := queuedAt(checks, "HOST:host-0"); !ok {
+		t.Error("HOST:host-0 is not queued")
+	}
+}
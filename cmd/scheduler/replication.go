@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// Hot-standby pair: the primary streams every persisted batch to its spare
+// (spare_url). The batches double as heartbeats; a spare without heartbeat for
+// failover_timeout seconds takes over the shard until the primary reclaims it.
+var (
+	// standby is set on a spare that does not serve its shard, guarded by mu
+	standby bool
+	// lastHeartbeat is the reception time of the last batch from the primary, guarded by mu
+	lastHeartbeat time.Time
+	// replicaReady is set on a spare once it received a full batch, guarded by mu
+	replicaReady bool
+
+	// replicaSynced is set on a primary while its spare holds the complete state
+	replicaSynced atomic.Bool
+	// replMu serializes the replication cycles of a primary
+	replMu sync.Mutex
+)
+
+// serving reports whether this scheduler dispatches checks and notifies, must be called under mu
+func serving() bool {
+	return !standby
+}
+
+// replicate sends the changes of a persistence cycle to the spare. A spare that
+// missed a batch receives the complete state; a spare that took over is asked
+// to hand the shard back.
+func replicate(b stateBatch) {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	if !replicaSynced.Load() {
+		mu.RLock()
+		b = fullState()
+		mu.RUnlock()
+	}
+	status, err := postBatch(strings.TrimSuffix(appConfig.SpareURL, "/")+"/v1/replicate", b)
+	switch {
+	case err != nil:
+		if replicaSynced.Load() {
+			logger.Info("[WARNING] Spare %s unreachable: %v", appConfig.SpareURL, err)
+		}
+		replicaSynced.Store(false)
+	case status == http.StatusConflict:
+		logger.Always("Spare %s is serving the shard, taking it back", appConfig.SpareURL)
+		reclaimShard()
+		replicaSynced.Store(false)
+	case status == http.StatusPreconditionFailed:
+		// The spare restarted and needs the complete state
+		replicaSynced.Store(false)
+	case status != http.StatusOK:
+		logger.Info("[WARNING] Spare %s rejected replication: status %d", appConfig.SpareURL, status)
+		replicaSynced.Store(false)
+	default:
+		if !replicaSynced.Load() {
+			logger.Always("Spare %s synchronized", appConfig.SpareURL)
+		}
+		replicaSynced.Store(true)
+	}
+}
+
+// postBatch sends a batch to the other scheduler of the pair
+func postBatch(url string, b stateBatch) (int, error) {
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// reclaimShard takes the state back from a spare that served the shard, so the
+// primary resumes exactly where the spare stopped and does not notify again.
+// Called on startup before serving, and when the spare reports it is active.
+func reclaimShard() {
+	url := strings.TrimSuffix(appConfig.SpareURL, "/")
+	resp, err := httpClient.Post(url+"/v1/handback", "application/json", nil)
+	if err != nil {
+		logger.Info("[WARNING] Spare %s unreachable for handback: %v", appConfig.SpareURL, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return // The spare was in standby: the local state is current
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Info("[WARNING] Handback refused by %s: status %d", appConfig.SpareURL, resp.StatusCode)
+		return
+	}
+
+	var b stateBatch
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		logger.Info("[ERROR] Invalid handback state from %s: %v", appConfig.SpareURL, err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := applyBatch(b); err != nil {
+		logger.Info("[ERROR] Invalid handback state from %s: %v", appConfig.SpareURL, err)
+		return
+	}
+	rebuildQueue()
+	// Checks the spare had dispatched will never report here: run them again
+	now := time.Now()
+	for _, key := range b.InFlight {
+		checks.schedule(key, now)
+	}
+	logger.Always("Shard taken back from spare: %d hosts, %d services", len(hosts), len(services))
+}
+
+// applyBatch installs a batch received from the other scheduler of the pair, must be called under mu
+func applyBatch(b stateBatch) error {
+	// Decode everything first so an invalid batch leaves the state untouched
+	newHosts := make(map[string]*models.Host, len(b.Hosts))
+	for id, raw := range b.Hosts {
+		if deleted(raw) {
+			newHosts[id] = nil
+			continue
+		}
+		var h models.Host
+		if err := json.Unmarshal(raw, &h); err != nil {
+			return fmt.Errorf("host %s: %v", id, err)
+		}
+		newHosts[id] = &h
+	}
+	newServices := make(map[string]*models.Service, len(b.Services))
+	for key, raw := range b.Services {
+		if deleted(raw) {
+			newServices[key] = nil
+			continue
+		}
+		var s models.Service
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("service %s: %v", key, err)
+		}
+		newServices[key] = &s
+	}
+
+	if b.Full {
+		hosts, services = make(map[string]*models.Host), make(map[string]*models.Service)
+		markAll()
+	}
+	for id, h := range newHosts {
+		if h == nil {
+			delete(hosts, id)
+		} else {
+			hosts[id] = h
+		}
+		markHost(id)
+	}
+	for key, s := range newServices {
+		if s == nil {
+			delete(services, key)
+		} else {
+			services[key] = s
+		}
+		markService(key)
+	}
+	if b.Config != nil {
+		applyConfig(func(name string) []byte { return b.Config[name] })
+		markConfig()
+	}
+	return nil
+}
+
+// replicateHandler receives the batches of the primary on a spare
+func replicateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !appConfig.Spare {
+		http.Error(w, "Not a spare scheduler", http.StatusForbidden)
+		return
+	}
+	var b stateBatch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "Bad JSON", 400)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !standby {
+		http.Error(w, "Spare is serving the shard", http.StatusConflict)
+		return
+	}
+	lastHeartbeat = time.Now()
+	if !b.Full && !replicaReady {
+		http.Error(w, "Full state required", http.StatusPreconditionFailed)
+		return
+	}
+	if err := applyBatch(b); err != nil {
+		logger.Info("[ERROR] Invalid replication batch: %v", err)
+		replicaReady = false
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if b.Full && !replicaReady {
+		logger.Always("Replica synchronized: %d hosts, %d services", len(hosts), len(services))
+	}
+	replicaReady = true
+	w.WriteHeader(http.StatusOK)
+}
+
+// handbackHandler returns the shard to the primary: an active spare goes back to
+// standby and answers with its complete state and the checks it has in flight
+func handbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !appConfig.Spare {
+		http.Error(w, "Not a spare scheduler", http.StatusForbidden)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	lastHeartbeat = time.Now()
+	if standby {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	standby = true
+	b := fullState()
+	for key := range inFlight {
+		if !strings.HasPrefix(key, "EVENT:") {
+			b.InFlight = append(b.InFlight, key)
+		}
+	}
+	// Nothing dispatched by the spare is followed up from now on
+	inFlight = make(map[string]*inFlightTask)
+	freshnessPending = make(map[string]bool)
+	forcedChecks = make(map[string]time.Time)
+	pendingEvents, eventTasks = nil, make(map[string]eventTask)
+	logger.Always("Shard handed back to the primary")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// startFailoverMonitor makes a spare take over its shard when the primary stops heartbeating
+func startFailoverMonitor() {
+	timeout := time.Duration(appConfig.FailoverTimeout) * time.Second
+	ticker := time.NewTicker(time.Second)
+	for range ticker.C {
+		mu.Lock()
+		if standby && time.Since(lastHeartbeat) > timeout {
+			standby = false
+			rebuildQueue()
+			logger.Always("No heartbeat from the primary for %s, taking over the shard", time.Since(lastHeartbeat).Truncate(time.Second))
+		}
+		mu.Unlock()
+	}
+}
+
+// replicationStatus describes the role of the scheduler in its pair, must be called under mu
+func replicationStatus() map[string]interface{} {
+	switch {
+	case appConfig.Spare:
+		return map[string]interface{}{"role": "spare", "standby": standby, "last_heartbeat": lastHeartbeat}
+	case appConfig.SpareURL != "":
+		return map[string]interface{}{"role": "primary", "spare_url": appConfig.SpareURL, "spare_synced": replicaSynced.Load()}
+	}
+	return map[string]interface{}{"role": "standalone"}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// pairState restores the replication state and the dispatch tracking once the test ends
+func pairState(t *testing.T) {
+	saveInventory(t)
+	sb, hb, ready, synced := standby, lastHeartbeat, replicaReady, replicaSynced.Load()
+	inf, fp, fc, pe, et := inFlight, freshnessPending, forcedChecks, pendingEvents, eventTasks
+	cmds := commands
+	t.Cleanup(func() {
+		standby, lastHeartbeat, replicaReady = sb, hb, ready
+		replicaSynced.Store(synced)
+		inFlight, freshnessPending, forcedChecks, pendingEvents, eventTasks = inf, fp, fc, pe, et
+		commands = cmds
+		dirtyHosts, dirtyServices = make(map[string]bool), make(map[string]bool)
+		dirtyConfig, dirtyAll = false, false
+	})
+	inFlight = make(map[string]*inFlightTask)
+	freshnessPending = make(map[string]bool)
+	forcedChecks = make(map[string]time.Time)
+}
+
+// pairInventory replaces the inventory by the given hosts, each with an http service
+func pairInventory(ids ...string) {
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	for _, id := range ids {
+		hosts[id] = &models.Host{ID: id, CheckCommand: "check_ping"}
+		s := &models.Service{ID: "http", HostName: id, CheckCommand: "check_http"}
+		services[s.Key()] = s
+	}
+	rebuildQueue()
+}
+
+// hostList returns the sorted IDs of the hosts in the inventory
+func hostList() string {
+	var ids []string
+	for id := range hosts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// record serializes an object of a batch
+func record(v interface{}) json.RawMessage {
+	raw, _ := json.Marshal(v)
+	return raw
+}
+
+func TestApplyBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		batch     stateBatch
+		wantErr   bool
+		wantHosts string
+		wantSvcs  int
+		wantCmd   string
+	}{
+		{
+			name: "full batch replaces the inventory",
+			batch: stateBatch{
+				Full:     true,
+				Hosts:    map[string]json.RawMessage{"db1": record(models.Host{ID: "db1"})},
+				Services: map[string]json.RawMessage{},
+			},
+			wantHosts: "db1",
+			wantSvcs:  0,
+		},
+		{
+			name: "incremental batch adds and deletes",
+			batch: stateBatch{
+				Hosts:    map[string]json.RawMessage{"db1": record(models.Host{ID: "db1"}), "web2": nil},
+				Services: map[string]json.RawMessage{"web2/http": json.RawMessage("null")},
+			},
+			wantHosts: "db1,web1",
+			wantSvcs:  1,
+		},
+		{
+			name: "configuration block",
+			batch: stateBatch{
+				Config: map[string]json.RawMessage{"commands": record(map[string]string{"check_ping": "/bin/ping $HOSTADDRESS$"})},
+			},
+			wantHosts: "web1,web2",
+			wantSvcs:  2,
+			wantCmd:   "/bin/ping $HOSTADDRESS$",
+		},
+		{
+			name: "invalid record leaves the state untouched",
+			batch: stateBatch{
+				Full:     true,
+				Hosts:    map[string]json.RawMessage{"db1": record(models.Host{ID: "db1"})},
+				Services: map[string]json.RawMessage{"db1/http": json.RawMessage(`{"id": 42}`)},
+			},
+			wantErr:   true,
+			wantHosts: "web1,web2",
+			wantSvcs:  2,
+		},
+	}
+	for _, tt := range tests {
+		pairState(t)
+		pairInventory("web1", "web2")
+		err := applyBatch(tt.batch)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := hostList(); got != tt.wantHosts {
+			t.Errorf("%s: got hosts %q, want %q", tt.name, got, tt.wantHosts)
+		}
+		if len(services) != tt.wantSvcs {
+			t.Errorf("%s: got %d services, want %d", tt.name, len(services), tt.wantSvcs)
+		}
+		if tt.wantCmd != "" && commands["check_ping"] != tt.wantCmd {
+			t.Errorf("%s: got command %q, want %q", tt.name, commands["check_ping"], tt.wantCmd)
+		}
+		// Applied changes are persisted by the next flush of the spare
+		if !tt.wantErr && len(dirtyHosts)+len(dirtyServices) == 0 && !dirtyConfig {
+			t.Errorf("%s: applied batch was not marked for persistence", tt.name)
+		}
+	}
+}
+
+func TestReplicateHandler(t *testing.T) {
+	full := stateBatch{Full: true, Hosts: map[string]json.RawMessage{"db1": record(models.Host{ID: "db1"})}}
+	incremental := stateBatch{Hosts: map[string]json.RawMessage{"db2": record(models.Host{ID: "db2"})}}
+	tests := []struct {
+		name      string
+		spare     bool
+		standby   bool
+		ready     bool
+		batch     stateBatch
+		want      int
+		wantHosts string
+	}{
+		{"not a spare", false, true, true, full, http.StatusForbidden, "web1"},
+		{"spare serving the shard", true, false, true, full, http.StatusConflict, "web1"},
+		{"incremental before full", true, true, false, incremental, http.StatusPreconditionFailed, "web1"},
+		{"full batch", true, true, false, full, http.StatusOK, "db1"},
+		{"incremental batch", true, true, true, incremental, http.StatusOK, "db2,web1"},
+	}
+	for _, tt := range tests {
+		pairState(t)
+		pairInventory("web1")
+		appConfig.Spare, standby, replicaReady = tt.spare, tt.standby, tt.ready
+		w := httptest.NewRecorder()
+		replicateHandler(w, httptest.NewRequest("POST", "/v1/replicate", strings.NewReader(string(record(tt.batch)))))
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if got := hostList(); got != tt.wantHosts {
+			t.Errorf("%s: got hosts %q, want %q", tt.name, got, tt.wantHosts)
+		}
+		if tt.want == http.StatusOK && !replicaReady {
+			t.Errorf("%s: replica not ready after a batch", tt.name)
+		}
+	}
+}
+
+func TestHandbackHandler(t *testing.T) {
+	pairState(t)
+	pairInventory("web1", "web2")
+	appConfig.Spare, standby = true, false
+	inFlight["HOST:web1"] = &inFlightTask{Seq: 1}
+	inFlight["web2/http"] = &inFlightTask{Seq: 2}
+	inFlight["EVENT:1"] = &inFlightTask{Seq: 3}
+	forcedChecks["web1/http"] = time.Now()
+
+	w := httptest.NewRecorder()
+	handbackHandler(w, httptest.NewRequest("POST", "/v1/handback", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var b stateBatch
+	if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(b.InFlight)
+	if got := strings.Join(b.InFlight, ","); got != "HOST:web1,web2/http" {
+		t.Errorf("in flight: got %q, want %q", got, "HOST:web1,web2/http")
+	}
+	if !b.Full || len(b.Hosts) != 2 || len(b.Services) != 2 {
+		t.Errorf("got full=%v with %d hosts and %d services, want the complete state", b.Full, len(b.Hosts), len(b.Services))
+	}
+	if !standby || len(inFlight) != 0 || len(forcedChecks) != 0 {
+		t.Errorf("spare still serving: standby=%v, %d in flight, %d forced", standby, len(inFlight), len(forcedChecks))
+	}
+
+	// A spare already in standby has nothing to hand back
+	w = httptest.NewRecorder()
+	handbackHandler(w, httptest.NewRequest("POST", "/v1/handback", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("second handback: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestReclaimShard(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	handback := stateBatch{
+		Full: true,
+		Hosts: map[string]json.RawMessage{
+			"web1": record(models.Host{ID: "web1", CheckCommand: "check_ping", Output: "from spare", NextCheck: later}),
+		},
+		Services: map[string]json.RawMessage{},
+		InFlight: []string{"HOST:web1"},
+	}
+	tests := []struct {
+		name       string
+		status     int
+		wantOutput string
+	}{
+		{"spare in standby", http.StatusNoContent, ""},
+		{"active spare", http.StatusOK, "from spare"},
+		{"handback refused", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		pairState(t)
+		spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/handback" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(tt.status)
+			if tt.status == http.StatusOK {
+				json.NewEncoder(w).Encode(handback)
+			}
+		}))
+		appConfig.SpareURL = spare.URL + "/"
+		pairInventory("web1")
+		hosts["web1"].NextCheck = later
+		rebuildQueue()
+
+		reclaimShard()
+		spare.Close()
+		if got := hosts["web1"].Output; got != tt.wantOutput {
+			t.Errorf("%s: got output %q, want %q", tt.name, got, tt.wantOutput)
+		}
+		// A check the spare had dispatched runs again on the primary
+		at, _ := queuedAt(checks, "HOST:web1")
+		if rerun := at.Before(later); rerun != (tt.status == http.StatusOK) {
+			t.Errorf("%s: host queued at %v, rerun %v", tt.name, at, rerun)
+		}
+	}
+}
+
+func TestReplicateReclaimsFromActiveSpare(t *testing.T) {
+	pairState(t)
+	pairInventory("web1")
+	handedBack := false
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/replicate":
+			http.Error(w, "Spare is serving the shard", http.StatusConflict)
+		case "/v1/handback":
+			handedBack = true
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer spare.Close()
+	appConfig.SpareURL = spare.URL
+	replicaSynced.Store(true)
+
+	replicate(stateBatch{})
+	if !handedBack {
+		t.Errorf("primary did not reclaim its shard")
+	}
+	if replicaSynced.Load() {
+		t.Errorf("spare still considered synchronized")
+	}
+}
+
+func TestAcknowledgementsReplicated(t *testing.T) {
+	eventRing(t, 10)
+	pairState(t)
+	pairInventory("web1", "web2")
+	collectChanges()
+
+	// Primary: the acknowledgements read from the Reactionner go with the next batch
+	applyAcknowledgements(map[string]bool{"web1": true, "web2/http": true})
+	b := collectChanges()
+	if len(b.Hosts) != 1 || len(b.Services) != 1 {
+		t.Fatalf("got %d hosts and %d services in the batch, want 1 and 1", len(b.Hosts), len(b.Services))
+	}
+
+	// Spare: the flags are applied with the objects
+	pairInventory("web1", "web2")
+	appConfig.Spare, standby, replicaReady = true, true, true
+	w := httptest.NewRecorder()
+	replicateHandler(w, httptest.NewRequest("POST", "/v1/replicate", strings.NewReader(string(record(b)))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := ackedObjects(); got != "web1,web2/http" {
+		t.Errorf("got %q acknowledged on the spare, want %q", got, "web1,web2/http")
+	}
+	// Handback: the primary gets them back with the state of the spare
+	standby = false
+	w = httptest.NewRecorder()
+	handbackHandler(w, httptest.NewRequest("POST", "/v1/handback", nil))
+	var back stateBatch
+	if err := json.NewDecoder(w.Body).Decode(&back); err != nil {
+		t.Fatal(err)
+	}
+	pairInventory("web1", "web2")
+	if err := applyBatch(back); err != nil {
+		t.Fatal(err)
+	}
+	if got := ackedObjects(); got != "web1,web2/http" {
+		t.Errorf("got %q acknowledged after the handback, want %q", got, "web1,web2/http")
+	}
+}
@@ -2,45 +2,561 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 
-	"shinsakuto/pkg/models"
 	"shinsakuto/pkg/logger"
+	"shinsakuto/pkg/models"
+)
+
+// schemaVersion is the layout version of the state database
+const schemaVersion = 1
+
+// migrations upgrade a state database from the version they are indexed by to the next one
+var migrations = map[int]func(tx *bolt.Tx) error{}
+
+// Buckets of the state database: one record per host and service, and one
+// record per configuration block received from the Arbiter
+var (
+	bucketMeta     = []byte("meta")
+	bucketHosts    = []byte("hosts")
+	bucketServices = []byte("services")
+	bucketConfig   = []byte("config")
 )
 
-// saveState serializes the current host and service status to disk
-func saveState() {
-	mu.RLock()
-	defer mu.RUnlock()
+// errSchemaTooNew stops the Scheduler rather than overwriting a newer database
+var errSchemaTooNew = errors.New("state database was written by a newer scheduler")
+
+// State database and pending changes, the dirty sets being guarded by mu
+var (
+	stateDB       *bolt.DB
+	dirtyHosts    = make(map[string]bool)
+	dirtyServices = make(map[string]bool)
+	dirtyConfig   bool
+	dirtyAll      bool
+)
+
+func markHost(id string)     { dirtyHosts[id] = true }
+func markService(key string) { dirtyServices[key] = true }
+func markConfig()            { dirtyConfig = true }
+
+// markAll schedules a full rewrite of the state, used after a configuration sync
+func markAll() { dirtyAll, dirtyConfig = true, true }
+
+// markResult marks the object a check result belongs to
+func markResult(id string) {
+	if strings.HasPrefix(id, "HOST:") {
+		markHost(strings.TrimPrefix(id, "HOST:"))
+	} else if !strings.HasPrefix(id, "EVENT:") {
+		markService(id)
+	}
+}
+
+// configBlocks lists the configuration received from the Arbiter, persisted as whole records
+func configBlocks() map[string]interface{} {
+	return map[string]interface{}{
+		"commands":      commands,
+		"resources":     resources,
+		"timeperiods":   timePeriods,
+		"downtimes":     downtimes,
+		"contacts":      contacts,
+		"contactgroups": contactGroups,
+		"escalations":   escalations,
+		"hostdeps":      hostDeps,
+		"servicedeps":   serviceDeps,
+	}
+}
+
+// stateBatch holds the serialized changes of one persistence cycle. It is
+// also the unit of replication to a spare scheduler.
+type stateBatch struct {
+	Full     bool                       `json:"full"`
+	Hosts    map[string]json.RawMessage `json:"hosts"` // null value: deleted object
+	Services map[string]json.RawMessage `json:"services"`
+	Config   map[string]json.RawMessage `json:"config,omitempty"`
+	InFlight []string                   `json:"in_flight,omitempty"` // Only set on handback
+}
+
+// empty reports whether the batch carries no change
+func (b stateBatch) empty() bool {
+	return !b.Full && len(b.Hosts) == 0 && len(b.Services) == 0 && b.Config == nil
+}
+
+// collectChanges serializes the dirty objects and resets the dirty sets, must be called under mu
+func collectChanges() stateBatch {
+	b := encodeState(dirtyAll, dirtyHosts, dirtyServices, dirtyConfig)
+	dirtyHosts, dirtyServices = make(map[string]bool), make(map[string]bool)
+	dirtyConfig, dirtyAll = false, false
+	return b
+}
+
+// fullState serializes every object and the configuration, must be called under mu
+func fullState() stateBatch {
+	return encodeState(true, nil, nil, true)
+}
+
+// encodeState serializes the given objects, or all of them for a full batch
+func encodeState(full bool, hostIDs, serviceKeys map[string]bool, config bool) stateBatch {
+	b := stateBatch{Full: full, Hosts: make(map[string]json.RawMessage), Services: make(map[string]json.RawMessage)}
+	if full {
+		hostIDs, serviceKeys = make(map[string]bool), make(map[string]bool)
+		for id := range hosts {
+			hostIDs[id] = true
+		}
+		for key := range services {
+			serviceKeys[key] = true
+		}
+	}
+	for id := range hostIDs {
+		if h, ok := hosts[id]; ok {
+			b.Hosts[id], _ = json.Marshal(h)
+		} else {
+			b.Hosts[id] = nil
+		}
+	}
+	for key := range serviceKeys {
+		if s, ok := services[key]; ok {
+			b.Services[key], _ = json.Marshal(s)
+		} else {
+			b.Services[key] = nil
+		}
+	}
+	if config {
+		b.Config = make(map[string]json.RawMessage)
+		for name, v := range configBlocks() {
+			b.Config[name], _ = json.Marshal(v)
+		}
+	}
+	return b
+}
+
+// saveMu serializes the flushes, so that batches are written and replicated in
+// the order they were collected and an older batch never overwrites a newer one
+var saveMu sync.Mutex
+
+// flushState persists the pending changes and hands them to the spare
+func flushState() {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	b := saveState()
+	if appConfig.SpareURL != "" {
+		replicate(b)
+	}
+}
+
+// saveState writes the modified objects to the state database in a single
+// transaction and returns the persisted changes. Must be called under saveMu.
+func saveState() stateBatch {
+	mu.Lock()
+	b := collectChanges()
+	mu.Unlock()
+	if b.empty() {
+		return b
+	}
 
-	logger.Info("Persisting state to disk...")
-	data, err := json.MarshalIndent(map[string]interface{}{
-		"hosts":    hosts,
-		"services": services,
-	}, "", "  ")
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		if b.Full {
+			// Objects removed from the configuration disappear with a full rewrite
+			for _, name := range [][]byte{bucketHosts, bucketServices} {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		if err := putRecords(tx.Bucket(bucketHosts), b.Hosts); err != nil {
+			return err
+		}
+		if err := putRecords(tx.Bucket(bucketServices), b.Services); err != nil {
+			return err
+		}
+		if err := putRecords(tx.Bucket(bucketConfig), b.Config); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMeta).Put([]byte("saved_at"), []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		logger.Info("[ERROR] Failed to persist state: %v", err)
+		// Nothing was written: everything is written again on the next cycle
+		mu.Lock()
+		markAll()
+		mu.Unlock()
+	}
+	return b
+}
 
+// deleted reports whether a record of a batch stands for a removed object
+func deleted(v json.RawMessage) bool {
+	return v == nil || string(v) == "null"
+}
+
+// putRecords writes or deletes the records of a bucket
+func putRecords(bucket *bolt.Bucket, records map[string]json.RawMessage) error {
+	for k, v := range records {
+		var err error
+		if deleted(v) {
+			err = bucket.Delete([]byte(k))
+		} else {
+			err = bucket.Put([]byte(k), v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startStatePersistence flushes pending changes and takes periodic snapshots
+func startStatePersistence() {
+	flush := time.NewTicker(time.Duration(appConfig.StateSyncInterval) * time.Second)
+	snapshot := time.NewTicker(time.Duration(appConfig.StateSnapshotInterval) * time.Second)
+	for {
+		select {
+		case <-flush.C:
+			flushState()
+		case <-snapshot.C:
+			snapshotState()
+		}
+	}
+}
+
+// snapshotState copies the database to its snapshot file, replaced atomically
+func snapshotState() {
+	path := appConfig.StateDB + ".snapshot"
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path+".tmp", 0600)
+	})
 	if err == nil {
-		os.WriteFile(appConfig.StateFile, data, 0644)
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		logger.Info("[ERROR] Failed to snapshot state: %v", err)
 	}
 }
 
-// loadState restores the state from the JSON file on startup
+// closeState persists the last changes, hands them to the spare and closes the database
+func closeState() {
+	flushState()
+	snapshotState()
+	stateDB.Close()
+}
+
+// loadState opens the state database and restores the objects on startup.
+// A corrupt database is replaced by its last snapshot; an empty one imports
+// the JSON state file written by older schedulers.
 func loadState() {
+	if err := openStateDB(); err != nil {
+		logger.Fatal("Cannot open state database %s: %v", appConfig.StateDB, err)
+	}
+
 	mu.Lock()
-	defer mu.Unlock()
+	restored, err := readState()
+	if err != nil {
+		// Undecodable records are corruption too: the snapshot is the last good state
+		logger.Always("[ERROR] State database %s has unreadable records (%v), restoring the last snapshot", appConfig.StateDB, err)
+		stateDB.Close()
+		if err := restoreSnapshot(true); err != nil {
+			logger.Fatal("Cannot open state database %s: %v", appConfig.StateDB, err)
+		}
+		if restored, err = readState(); err != nil {
+			logger.Always("[ERROR] Snapshot has unreadable records too (%v), starting with an empty state", err)
+			stateDB.Close()
+			if err := restoreSnapshot(false); err != nil {
+				logger.Fatal("Cannot open state database %s: %v", appConfig.StateDB, err)
+			}
+			restored = false
+		}
+	}
+	imported := false
+	if !restored {
+		imported = importLegacyState(appConfig.StateFile)
+		if imported {
+			markAll()
+		}
+	}
+	rebuildQueue()
+	logger.Always("State restored: %d hosts, %d services", len(hosts), len(services))
+	mu.Unlock()
+
+	if imported {
+		saveMu.Lock()
+		saveState()
+		saveMu.Unlock()
+		os.Rename(appConfig.StateFile, appConfig.StateFile+".imported")
+		logger.Always("Legacy state file %s imported", appConfig.StateFile)
+	}
+}
+
+// openStateDB opens the state database, falling back to the last snapshot when it is corrupt
+func openStateDB() error {
+	path := appConfig.StateDB
+	db, err := openChecked(path)
+	if err == nil {
+		stateDB = db
+		return nil
+	}
+	if err == bolt.ErrTimeout || err == errSchemaTooNew {
+		return err
+	}
+
+	logger.Always("[ERROR] State database %s is unusable (%v), restoring the last snapshot", path, err)
+	return restoreSnapshot(true)
+}
+
+// restoreSnapshot sets the unusable state database aside and replaces it by its last
+// snapshot, or by an empty database when there is no usable snapshot
+func restoreSnapshot(useSnapshot bool) error {
+	path := appConfig.StateDB
+	if _, err := os.Stat(path); err == nil {
+		os.Rename(path, fmt.Sprintf("%s.corrupt-%d", path, time.Now().UnixNano()))
+	}
+	if useSnapshot {
+		if err := copyFile(path+".snapshot", path); err == nil {
+			if db, err := openChecked(path); err == nil {
+				stateDB = db
+				return nil
+			}
+			os.Remove(path)
+		}
+		logger.Always("[ERROR] No usable snapshot, starting with an empty state")
+	}
+
+	db, err := openChecked(path)
+	stateDB = db
+	return err
+}
+
+// openChecked opens a database, verifies its consistency and applies schema migrations
+func openChecked(path string) (db *bolt.DB, err error) {
+	// Bolt may panic, or fault on the memory map, on pages it cannot decode
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if db != nil {
+				db.Close()
+			}
+			db, err = nil, fmt.Errorf("corrupt database: %v", r)
+		}
+	}()
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		var checkErr error
+		for e := range tx.Check() {
+			if checkErr == nil {
+				checkErr = e
+			}
+		}
+		if checkErr != nil {
+			return checkErr
+		}
+		for _, name := range [][]byte{bucketMeta, bucketHosts, bucketServices, bucketConfig} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return migrate(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate brings the database to the current schema version
+func migrate(tx *bolt.Tx) error {
+	meta := tx.Bucket(bucketMeta)
+	version := schemaVersion
+	if raw := meta.Get([]byte("schema_version")); raw != nil {
+		v, err := strconv.Atoi(string(raw))
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q", raw)
+		}
+		version = v
+	}
+	if version > schemaVersion {
+		return errSchemaTooNew
+	}
+	for ; version < schemaVersion; version++ {
+		if m, ok := migrations[version]; ok {
+			if err := m(tx); err != nil {
+				return fmt.Errorf("migration from schema %d: %v", version, err)
+			}
+		}
+	}
+	return meta.Put([]byte("schema_version"), []byte(strconv.Itoa(schemaVersion)))
+}
+
+// readState loads every record of the database, must be called under mu.
+// It returns false when the database holds no state yet.
+func readState() (bool, error) {
+	restored := false
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		restored = tx.Bucket(bucketMeta).Get([]byte("saved_at")) != nil
+		if !restored {
+			return nil
+		}
+
+		newHosts := make(map[string]*models.Host)
+		err := tx.Bucket(bucketHosts).ForEach(func(k, v []byte) error {
+			var h models.Host
+			if err := json.Unmarshal(v, &h); err != nil {
+				return fmt.Errorf("host %s: %v", k, err)
+			}
+			newHosts[string(k)] = &h
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		newServices := make(map[string]*models.Service)
+		err = tx.Bucket(bucketServices).ForEach(func(k, v []byte) error {
+			var s models.Service
+			if err := json.Unmarshal(v, &s); err != nil {
+				return fmt.Errorf("service %s: %v", k, err)
+			}
+			newServices[string(k)] = &s
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		hosts, services = newHosts, newServices
+
+		cfg := tx.Bucket(bucketConfig)
+		applyConfig(func(name string) []byte { return cfg.Get([]byte(name)) })
+		return nil
+	})
+	return restored, err
+}
+
+// applyConfig restores the configuration blocks returned by get, must be called under mu
+func applyConfig(get func(name string) []byte) {
+	var (
+		cmds, res map[string]string
+		tps       []models.TimePeriod
+		dts       []models.Downtime
+		cts       map[string]models.Contact
+		cgs       map[string][]string
+		escs      map[string]models.Escalation
+		hdeps     map[string][]models.HostDependency
+		sdeps     map[string][]models.ServiceDependency
+	)
+	decode := func(name string, v interface{}) {
+		if raw := get(name); raw != nil {
+			if err := json.Unmarshal(raw, v); err != nil {
+				logger.Info("[WARNING] Ignoring unreadable %s in state: %v", name, err)
+			}
+		}
+	}
+	decode("commands", &cmds)
+	decode("resources", &res)
+	decode("timeperiods", &tps)
+	decode("downtimes", &dts)
+	decode("contacts", &cts)
+	decode("contactgroups", &cgs)
+	decode("escalations", &escs)
+	decode("hostdeps", &hdeps)
+	decode("servicedeps", &sdeps)
+	if cmds != nil {
+		commands = cmds
+	}
+	if res != nil {
+		resources = res
+	}
+	if cts != nil {
+		contacts, contactGroups, escalations = cts, cgs, escs
+	}
+	if hdeps != nil {
+		hostDeps, serviceDeps = hdeps, sdeps
+	}
+	loadTimePeriods(tps)
+	loadDowntimes(dts)
+}
 
-	data, err := os.ReadFile(appConfig.StateFile)
+// importLegacyState restores the JSON state file of older schedulers, must be called under mu
+func importLegacyState(path string) bool {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return
+		return false
 	}
 
 	var st struct {
-		Hosts    map[string]*models.Host    `json:"hosts"`
-		Services map[string]*models.Service `json:"services"`
+		Hosts         map[string]*models.Host      `json:"hosts"`
+		Services      map[string]*models.Service   `json:"services"`
+		Commands      map[string]string            `json:"commands"`
+		Resources     map[string]string            `json:"resources"`
+		TimePeriods   []models.TimePeriod          `json:"timeperiods"`
+		Downtimes     []models.Downtime            `json:"downtimes"`
+		Contacts      map[string]models.Contact    `json:"contacts"`
+		ContactGroups map[string][]string          `json:"contactgroups"`
+		Escalations   map[string]models.Escalation `json:"escalations"`
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		logger.Always("[ERROR] Legacy state file %s is unreadable: %v", path, err)
+		return false
 	}
 
-	if err := json.Unmarshal(data, &st); err == nil {
-		hosts, services = st.Hosts, st.Services
-		logger.Always("State restored: %d hosts, %d services", len(hosts), len(services))
+	hosts = st.Hosts
+	if hosts == nil {
+		hosts = make(map[string]*models.Host)
+	}
+	for _, h := range hosts {
+		// Older state files only carried the is_up flag
+		if !h.IsUp && h.CurrentState == models.HostUp {
+			h.CurrentState = models.HostDown
+		}
+	}
+	// Re-key services by host/service identity (older state files used the bare ID)
+	services = make(map[string]*models.Service, len(st.Services))
+	for _, s := range st.Services {
+		services[s.Key()] = s
+	}
+	if st.Commands != nil {
+		commands = st.Commands
+	}
+	if st.Resources != nil {
+		resources = st.Resources
+	}
+	loadTimePeriods(st.TimePeriods)
+	// InDowntime flags are restored with the objects, so only transitions
+	// missed while stopped are notified by the next evaluation
+	loadDowntimes(st.Downtimes)
+	if st.Contacts != nil {
+		contacts, contactGroups, escalations = st.Contacts, st.ContactGroups, st.Escalations
+	}
+	return true
+}
+
+// copyFile copies src to dst, used to restore a snapshot
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
 	}
+	return out.Close()
 }
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"shinsakuto/pkg/models"
+)
+
+// stateFiles points the state database and the legacy state file into a
+// temporary directory and closes the database once the test ends
+func stateFiles(t *testing.T) string {
+	saveInventory(t)
+	cmds, res, tps, hd, sd := commands, resources, timePeriods, hostDeps, serviceDeps
+	t.Cleanup(func() {
+		if stateDB != nil {
+			stateDB.Close()
+		}
+		stateDB = nil
+		commands, resources, hostDeps, serviceDeps = cmds, res, hd, sd
+		loadTimePeriods(tps)
+		dirtyHosts, dirtyServices = make(map[string]bool), make(map[string]bool)
+		dirtyConfig, dirtyAll = false, false
+	})
+	dir := t.TempDir()
+	appConfig.StateDB = filepath.Join(dir, "states.db")
+	appConfig.StateFile = filepath.Join(dir, "states.json")
+	return dir
+}
+
+// storedState fills the inventory and writes it to a new state database
+func storedState(t *testing.T, hostIDs ...string) {
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	for _, id := range hostIDs {
+		hosts[id] = &models.Host{ID: id, CheckCommand: "check_ping", Output: "PING OK " + id}
+		s := &models.Service{ID: "http", HostName: id, CheckCommand: "check_http"}
+		services[s.Key()] = s
+	}
+	if err := openStateDB(); err != nil {
+		t.Fatal(err)
+	}
+	markAll()
+	saveState()
+}
+
+// reload closes the database, forgets the inventory and restores it from disk
+func reload() {
+	stateDB.Close()
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	loadState()
+}
+
+// restored lists the given hosts found in the inventory
+func restored(ids ...string) []string {
+	var found []string
+	for _, id := range ids {
+		if _, ok := hosts[id]; ok {
+			found = append(found, id)
+		}
+	}
+	return found
+}
+
+func TestLoadStateRestoresSavedObjects(t *testing.T) {
+	stateFiles(t)
+	storedState(t, "web1", "web2")
+	reload()
+	if len(hosts) != 2 || len(services) != 2 {
+		t.Fatalf("got %d hosts and %d services, want 2 and 2", len(hosts), len(services))
+	}
+	if got := hosts["web1"].Output; got != "PING OK web1" {
+		t.Errorf("web1 output: got %q, want %q", got, "PING OK web1")
+	}
+	if _, ok := services[models.ServiceKey("web2", "http")]; !ok {
+		t.Errorf("service web2/http was not restored")
+	}
+	if _, ok := checks.byKey["HOST:web1"]; !ok {
+		t.Errorf("web1 was not queued after the restore")
+	}
+}
+
+func TestSaveStateWritesModifiedObjectsOnly(t *testing.T) {
+	stateFiles(t)
+	storedState(t, "web1", "web2", "web3")
+
+	// Only marked objects are written: web2 changed in memory but keeps its record
+	hosts["web1"].Output = "PING CRITICAL"
+	hosts["web2"].Output = "changed but not marked"
+	delete(hosts, "web3")
+	markHost("web1")
+	markHost("web3")
+	saveState()
+
+	read := func(id string) string {
+		var out string
+		stateDB.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket(bucketHosts).Get([]byte(id)); v != nil {
+				var h models.Host
+				json.Unmarshal(v, &h)
+				out = h.Output
+			} else {
+				out = "<deleted>"
+			}
+			return nil
+		})
+		return out
+	}
+	tests := []struct{ id, want string }{
+		{"web1", "PING CRITICAL"},
+		{"web2", "PING OK web2"},
+		{"web3", "<deleted>"},
+	}
+	for _, tt := range tests {
+		if got := read(tt.id); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.id, got, tt.want)
+		}
+	}
+	if dirtyAll || dirtyConfig || len(dirtyHosts) != 0 {
+		t.Errorf("dirty sets not reset after the save")
+	}
+}
+
+func TestLoadStateFallsBackToSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot bool
+		corrupt  func(t *testing.T, path string)
+		want     []string
+	}{
+		{
+			name:     "garbage file",
+			snapshot: true,
+			corrupt: func(t *testing.T, path string) {
+				garbage := make([]byte, 64*1024)
+				for i := range garbage {
+					garbage[i] = byte(i * 7)
+				}
+				if err := os.WriteFile(path, garbage, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"web1"},
+		},
+		{
+			name:     "truncated file",
+			snapshot: true,
+			corrupt: func(t *testing.T, path string) {
+				if err := os.Truncate(path, 4096); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"web1"},
+		},
+		{
+			name:     "unreadable record",
+			snapshot: true,
+			corrupt: func(t *testing.T, path string) {
+				db, err := bolt.Open(path, 0600, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+				db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket(bucketHosts).Put([]byte("web2"), []byte("{not json"))
+				})
+			},
+			want: []string{"web1"},
+		},
+		{
+			name: "no snapshot",
+			corrupt: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("not a bolt database"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := stateFiles(t)
+			storedState(t, "web1")
+			if tt.snapshot {
+				snapshotState()
+			}
+			// Changes after the snapshot are lost with the corrupt database
+			hosts["web2"] = &models.Host{ID: "web2"}
+			markHost("web2")
+			saveState()
+			stateDB.Close()
+			tt.corrupt(t, appConfig.StateDB)
+
+			hosts = make(map[string]*models.Host)
+			services = make(map[string]*models.Service)
+			loadState()
+			if got := restored("web1", "web2"); len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("%s: got hosts %v, want %v", tt.name, got, tt.want)
+			}
+			if set, _ := filepath.Glob(filepath.Join(dir, "states.db.corrupt-*")); len(set) != 1 {
+				t.Errorf("%s: got %d databases set aside, want 1", tt.name, len(set))
+			}
+		})
+	}
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	stateFiles(t)
+	storedState(t, "web1")
+	snapshotState()
+	stateDB.Close()
+
+	if err := restoreSnapshot(true); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	ok, err := readState()
+	mu.Unlock()
+	if !ok || err != nil || len(hosts) != 1 {
+		t.Errorf("restore from snapshot: got restored=%v err=%v hosts=%d, want true, nil, 1", ok, err, len(hosts))
+	}
+	stateDB.Close()
+
+	// Without a usable snapshot the state starts empty
+	if err := restoreSnapshot(false); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	ok, err = readState()
+	mu.Unlock()
+	if ok || err != nil {
+		t.Errorf("restore without snapshot: got restored=%v err=%v, want an empty database", ok, err)
+	}
+}
+
+func TestImportLegacyState(t *testing.T) {
+	stateFiles(t)
+	legacy := `{
+		"hosts": {
+			"web1": {"id": "web1", "is_up": false, "current_state": 0},
+			"web2": {"id": "web2", "is_up": true, "current_state": 0}
+		},
+		"services": {"http": {"id": "http", "host_name": "web1"}},
+		"commands": {"check_http": "/usr/lib/nagios/plugins/check_http -H $HOSTADDRESS$"}
+	}`
+	if err := os.WriteFile(appConfig.StateFile, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hosts = make(map[string]*models.Host)
+	services = make(map[string]*models.Service)
+	loadState()
+
+	if got := hosts["web1"].CurrentState; got != models.HostDown {
+		t.Errorf("web1 state: got %d, want %d", got, models.HostDown)
+	}
+	if got := hosts["web2"].CurrentState; got != models.HostUp {
+		t.Errorf("web2 state: got %d, want %d", got, models.HostUp)
+	}
+	if _, ok := services[models.ServiceKey("web1", "http")]; !ok {
+		t.Errorf("service not re-keyed by host, got keys %v", services)
+	}
+	if commands["check_http"] == "" {
+		t.Errorf("commands not imported")
+	}
+	if _, err := os.Stat(appConfig.StateFile + ".imported"); err != nil {
+		t.Errorf("legacy file not renamed: %v", err)
+	}
+
+	// The imported state is in the database: the next start does not need the file
+	reload()
+	if len(hosts) != 2 || len(services) != 1 {
+		t.Errorf("after restart: got %d hosts and %d services, want 2 and 1", len(hosts), len(services))
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+
+	tests := []struct {
+		name     string
+		version  string // empty: no version recorded
+		wantErr  string
+		migrated bool
+	}{
+		{name: "new database"},
+		{name: "current version", version: "1"},
+		{name: "older version", version: "0", migrated: true},
+		{name: "newer version", version: "2", wantErr: errSchemaTooNew.Error()},
+		{name: "invalid version", version: "one", wantErr: `invalid schema version "one"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "states.db")
+		db, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Update(func(tx *bolt.Tx) error {
+			meta, _ := tx.CreateBucket(bucketMeta)
+			if tt.version != "" {
+				meta.Put([]byte("schema_version"), []byte(tt.version))
+			}
+			return nil
+		})
+		db.Close()
+
+		migrated := false
+		migrations = map[int]func(tx *bolt.Tx) error{
+			0: func(tx *bolt.Tx) error { migrated = true; return nil },
+		}
+		db, err = openChecked(path)
+		if got := errString(err); got != tt.wantErr {
+			t.Errorf("%s: got error %q, want %q", tt.name, got, tt.wantErr)
+		}
+		if migrated != tt.migrated {
+			t.Errorf("%s: migrated %v, want %v", tt.name, migrated, tt.migrated)
+		}
+		if err != nil {
+			continue
+		}
+		db.View(func(tx *bolt.Tx) error {
+			if got := string(tx.Bucket(bucketMeta).Get([]byte("schema_version"))); got != "1" {
+				t.Errorf("%s: schema version %q, want %q", tt.name, got, "1")
+			}
+			return nil
+		})
+		db.Close()
+	}
+}
+
+// errString returns the message of err, or an empty string for nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestLoadStateKeepsServicesOfEachHost(t *testing.T) {
+	stateFiles(t)
+	storedState(t, "web1", "web2")
+	services["web1/http"].CurrentState, services["web1/http"].Output = 2, "CRITICAL"
+	markService("web1/http")
+	saveState()
+	reload()
+	if s := services["web1/http"]; s == nil || s.CurrentState != 2 || s.Output != "CRITICAL" {
+		t.Errorf("web1/http restored as %+v, want CRITICAL", s)
+	}
+	if s := services["web2/http"]; s == nil || s.CurrentState != 0 {
+		t.Errorf("web2/http restored as %+v, want OK", s)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// hostFailureState decides whether a failing host is DOWN or UNREACHABLE.
+// A host is UNREACHABLE only when all of its parents are themselves failing.
+// Parents unknown to this scheduler are assumed reachable.
+func hostFailureState(h *models.Host) int {
+	if len(h.Parents) == 0 {
+		return models.HostDown
+	}
+	for _, p := range h.Parents {
+		parent, ok := hosts[p]
+		if !ok || parent.CurrentState == models.HostUp {
+			return models.HostDown
+		}
+	}
+	return models.HostUnreachable
+}
+
+// checkParentsNow schedules an immediate check of the parents of a failing host
+// so that the DOWN/UNREACHABLE decision is based on fresh parent states.
+func checkParentsNow(h *models.Host) {
+	now := time.Now()
+	for _, p := range h.Parents {
+		if parent, ok := hosts[p]; ok && parent.NextCheck.After(now) {
+			parent.NextCheck = now
+			scheduleHost(parent)
+		}
+	}
+}
+
+// checkChildrenNow schedules an immediate check of the direct children of a host
+// after a HARD state change, so they move between DOWN and UNREACHABLE quickly.
+func checkChildrenNow(h *models.Host) {
+	now := time.Now()
+	for _, child := range hosts {
+		for _, p := range child.Parents {
+			if p == h.ID && child.NextCheck.After(now) {
+				child.NextCheck = now
+				scheduleHost(child)
+				break
+			}
+		}
+	}
+}
+
+// shouldNotifyHost applies the UNREACHABLE notification policy.
+// Unless notify_unreachable is set, neither UNREACHABLE problems nor
+// the recoveries from them are sent to the Reactionner.
+func shouldNotifyHost(oldState, newState int) bool {
+	if appConfig.NotifyUnreachable {
+		return true
+	}
+	return newState != models.HostUnreachable && !(newState == models.HostUp && oldState == models.HostUnreachable)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// topology installs hosts with their parents and current states, all HARD on the first failure
+func topology(t *testing.T, parents map[string][]string, states map[string]int) {
+	saveInventory(t)
+	defaultConfig(t, "seconds")
+	later := time.Now().Add(time.Hour)
+	hosts = make(map[string]*models.Host)
+	for id, p := range parents {
+		hosts[id] = &models.Host{
+			ID: id, CheckCommand: "check_ping", Parents: p, CurrentState: states[id], IsUp: states[id] == models.HostUp,
+			StateType: models.StateTypeHard, Attempts: 1, MaxAttempts: 1, NextCheck: later,
+		}
+	}
+	services = make(map[string]*models.Service)
+	downtimes = nil
+	rebuildQueue()
+}
+
+func TestHostFailureState(t *testing.T) {
+	down, unreachable := models.HostDown, models.HostUnreachable
+	topology(t, map[string][]string{
+		"router": nil, "dead": nil, "lost": {"dead"},
+		"web1": {"router"}, "web2": {"dead"}, "web3": {"router", "dead"}, "web4": {"dead", "lost"}, "web5": {"nowhere"},
+		"a": {"b"}, "b": {"a"},
+	}, map[string]int{"dead": down, "lost": unreachable, "a": down, "b": down})
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"router", down},      // No parent: the host itself is failing
+		{"web1", down},        // Its parent is up
+		{"web2", unreachable}, // Behind a dead parent
+		{"web3", down},        // One parent still up
+		{"web4", unreachable}, // Every parent failing, DOWN or UNREACHABLE
+		{"web5", down},        // Parents unknown to the shard are assumed reachable
+		{"a", unreachable},    // A parent cycle only looks at the direct parents
+		{"b", unreachable},
+	}
+	for _, tt := range tests {
+		if got := hostFailureState(hosts[tt.host]); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.host, hostStateName(got), hostStateName(tt.want))
+		}
+	}
+}
+
+func TestHostBehindDeadParent(t *testing.T) {
+	topology(t, map[string][]string{"router": nil, "web1": {"router"}}, nil)
+	router, web := hosts["router"], hosts["web1"]
+	now := time.Now()
+
+	// The child fails first: DOWN while its parent is up, and the parent is checked at once
+	handleHostResult(models.CheckResult{ID: "HOST:web1", Status: 2, Output: "CRITICAL"})
+	if web.CurrentState != models.HostDown {
+		t.Errorf("web1 with its parent up: %s, want DOWN", hostStateName(web.CurrentState))
+	}
+	if at, _ := queuedAt(checks, "HOST:router"); at.After(time.Now()) {
+		t.Errorf("router due at %v, want an immediate check", at)
+	}
+
+	// The parent goes down: its children are checked at once and become UNREACHABLE
+	handleHostResult(models.CheckResult{ID: "HOST:router", Status: 2, Output: "CRITICAL"})
+	if router.CurrentState != models.HostDown {
+		t.Errorf("router: %s, want DOWN", hostStateName(router.CurrentState))
+	}
+	if at, _ := queuedAt(checks, "HOST:web1"); at.After(time.Now()) {
+		t.Errorf("web1 due at %v after the router went down, want an immediate check", at)
+	}
+	handleHostResult(models.CheckResult{ID: "HOST:web1", Status: 2, Output: "CRITICAL"})
+	if web.CurrentState != models.HostUnreachable {
+		t.Errorf("web1 behind a dead router: %s, want UNREACHABLE", hostStateName(web.CurrentState))
+	}
+
+	// The parent recovers: a HARD change, the child is re-checked and is DOWN on its own
+	web.NextCheck = now.Add(time.Hour)
+	scheduleHost(web)
+	handleHostResult(models.CheckResult{ID: "HOST:router", Status: 0, Output: "OK"})
+	if at, _ := queuedAt(checks, "HOST:web1"); at.After(time.Now()) {
+		t.Errorf("web1 due at %v after the router recovered, want an immediate check", at)
+	}
+	handleHostResult(models.CheckResult{ID: "HOST:web1", Status: 2, Output: "CRITICAL"})
+	if web.CurrentState != models.HostDown {
+		t.Errorf("web1 with its router back: %s, want DOWN", hostStateName(web.CurrentState))
+	}
+}
+
+func TestParentCycle(t *testing.T) {
+	// The Arbiter rejects parent cycles; a Scheduler receiving one must not loop on it
+	topology(t, map[string][]string{"a": {"b"}, "b": {"a"}}, nil)
+	done := make(chan struct{})
+	go func() {
+		handleHostResult(models.CheckResult{ID: "HOST:a", Status: 2, Output: "CRITICAL"})
+		handleHostResult(models.CheckResult{ID: "HOST:b", Status: 2, Output: "CRITICAL"})
+		handleHostResult(models.CheckResult{ID: "HOST:a", Status: 0, Output: "OK"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("results of hosts in a parent cycle never processed")
+	}
+	if hosts["a"].CurrentState != models.HostUp || hosts["b"].CurrentState != models.HostUnreachable {
+		t.Errorf("got a %s, b %s, want UP and UNREACHABLE",
+			hostStateName(hosts["a"].CurrentState), hostStateName(hosts["b"].CurrentState))
+	}
+}
+
+func TestShouldNotifyHost(t *testing.T) {
+	saveInventory(t)
+	up, down, unreachable := models.HostUp, models.HostDown, models.HostUnreachable
+	tests := []struct {
+		old, new                int
+		notifyUnreachable, want bool
+	}{
+		{up, down, false, true},
+		{up, unreachable, false, false},
+		{unreachable, up, false, false},
+		{down, up, false, true},
+		{unreachable, down, false, true},
+		{up, unreachable, true, true},
+		{unreachable, up, true, true},
+	}
+	for _, tt := range tests {
+		appConfig.NotifyUnreachable = tt.notifyUnreachable
+		if got := shouldNotifyHost(tt.old, tt.new); got != tt.want {
+			t.Errorf("%s -> %s with notify_unreachable %v: got %v, want %v",
+				hostStateName(tt.old), hostStateName(tt.new), tt.notifyUnreachable, got, tt.want)
+		}
+	}
+}
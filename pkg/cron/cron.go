@@ -0,0 +1,155 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute hour day-of-month month day-of-week
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Standard cron semantics: when both day fields are restricted, either may match
+	domAny, dowAny bool
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse compiles a cron expression such as "30 2 * * sun" or "0 */6 1-7 * mon-fri"
+func Parse(spec string) (*Schedule, error) {
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d", spec, len(f))
+	}
+	// As in Vixie cron, a day field starting with "*" ("*", "*/2") is unrestricted
+	s := &Schedule{domAny: strings.HasPrefix(f[2], "*"), dowAny: strings.HasPrefix(f[4], "*")}
+	var err error
+	if s.minute, err = parseField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: minute: %v", spec, err)
+	}
+	if s.hour, err = parseField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: hour: %v", spec, err)
+	}
+	if s.dom, err = parseField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: day of month: %v", spec, err)
+	}
+	if s.month, err = parseField(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron %q: month: %v", spec, err)
+	}
+	if s.dow, err = parseField(f[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("cron %q: day of week: %v", spec, err)
+	}
+	// 7 is an alias of Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// Match reports whether the minute of t is selected by the schedule
+func (s *Schedule) Match(t time.Time) bool {
+	return s.month&(1<<uint(t.Month())) != 0 && s.matchDay(t) &&
+		s.hour&(1<<uint(t.Hour())) != 0 && s.minute&(1<<uint(t.Minute())) != 0
+}
+
+// matchDay reports whether the day of t is selected by the day-of-month and day-of-week fields
+func (s *Schedule) matchDay(t time.Time) bool {
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// Prev returns the latest scheduled minute at or before t, looking back at most 'within'.
+// A month, day or hour that does not match is skipped at once, so the cost depends on
+// the number of candidate hours rather than on the number of minutes in the window.
+func (s *Schedule) Prev(t time.Time, within time.Duration) (time.Time, bool) {
+	m := t.Truncate(time.Minute)
+	limit := t.Add(-within)
+	for !m.Before(limit) {
+		switch {
+		case s.month&(1<<uint(m.Month())) == 0:
+			// Last minute of the previous month
+			m = time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, m.Location()).Add(-time.Minute)
+		case !s.matchDay(m):
+			// Last minute of the previous day
+			m = time.Date(m.Year(), m.Month(), m.Day(), 0, 0, 0, 0, m.Location()).Add(-time.Minute)
+		case s.hour&(1<<uint(m.Hour())) == 0:
+			// Last minute of the previous hour
+			m = m.Add(-time.Duration(m.Minute()+1) * time.Minute)
+		default:
+			// Latest selected minute of this hour, if any
+			for min := m.Minute(); min >= 0; min-- {
+				if s.minute&(1<<uint(min)) != 0 {
+					if at := m.Add(-time.Duration(m.Minute()-min) * time.Minute); !at.Before(limit) {
+						return at, true
+					}
+					return time.Time{}, false
+				}
+			}
+			m = m.Add(-time.Duration(m.Minute()+1) * time.Minute)
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseField converts a comma-separated list of values, ranges and steps into a bit set
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q", field)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseValue reads a number or a three-letter month/day name
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
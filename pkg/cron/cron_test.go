@@ -0,0 +1,173 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec, err string
+	}{
+		{"", "expected 5 fields, got 0"},
+		{"* * * *", "expected 5 fields, got 4"},
+		{"* * * * * *", "expected 5 fields, got 6"},
+		{"60 * * * *", "minute: value out of range"},
+		{"* 24 * * *", "hour: value out of range"},
+		{"* * 0 * *", "day of month: value out of range"},
+		{"* * 32 * *", "day of month: value out of range"},
+		{"* * * 13 * ", "month: value out of range"},
+		{"* * * * 8", "day of week: value out of range"},
+		{"10-5 * * * *", "minute: value out of range"},
+		{"*/0 * * * *", "minute: invalid step"},
+		{"*/x * * * *", "minute: invalid step"},
+		{"a * * * *", "minute: invalid value"},
+		{"* * * foo *", "month: invalid value"},
+		{"* * * * mon-bar", "day of week: invalid value"},
+		{"1,,2 * * * *", "minute: invalid value"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	// 2026-10-16 is a Friday
+	tests := []struct {
+		spec, time string
+		want       bool
+	}{
+		{"* * * * *", "2026-10-16 13:37", true},
+		{"30 2 * * *", "2026-10-16 02:30", true},
+		{"30 2 * * *", "2026-10-16 02:31", false},
+		{"*/15 * * * *", "2026-10-16 10:45", true},
+		{"*/15 * * * *", "2026-10-16 10:50", false},
+		{"5/20 * * * *", "2026-10-16 10:45", true}, // 5, 25, 45
+		{"5/20 * * * *", "2026-10-16 10:40", false},
+		{"0 8-18/2 * * *", "2026-10-16 14:00", true},
+		{"0 8-18/2 * * *", "2026-10-16 15:00", false},
+		{"0 20-22 * * *", "2026-10-16 23:00", false},
+		{"0,30 9,17 * * *", "2026-10-16 17:30", true},
+		{"0 0 * jan,oct *", "2026-10-16 00:00", true},
+		{"0 0 * JAN-MAR *", "2026-10-16 00:00", false},
+		{"0 0 * * fri", "2026-10-16 00:00", true},
+		{"0 0 * * mon-thu", "2026-10-16 00:00", false},
+		{"0 0 * * 7", "2026-10-18 00:00", true}, // 7 is Sunday
+		{"0 0 * * 0", "2026-10-18 00:00", true},
+		{"0 0 * * sun", "2026-10-18 00:00", true},
+		// Only one day field restricted: it must match
+		{"0 0 16 * *", "2026-10-16 00:00", true},
+		{"0 0 17 * *", "2026-10-16 00:00", false},
+		{"0 0 * * sat", "2026-10-16 00:00", false},
+		// Both day fields restricted: either may match
+		{"0 0 1 * fri", "2026-10-16 00:00", true},
+		{"0 0 16 * mon", "2026-10-16 00:00", true},
+		{"0 0 1 * mon", "2026-10-16 00:00", false},
+		{"0 0 1-7 * mon", "2026-10-05 00:00", true},
+		// A stepped "*" leaves its day field unrestricted: the other one must match too
+		{"0 0 */2 * mon", "2026-10-16 00:00", false}, // Odd day, but a Friday
+		{"0 0 */2 * fri", "2026-10-16 00:00", false}, // Friday, but an even day
+		{"0 0 */2 * fri", "2026-10-23 00:00", true},
+		{"0 0 16 * */2", "2026-10-16 00:00", false}, // 16th, but Friday is day 5
+		{"0 0 16 * */2", "2026-10-18 00:00", false}, // Sunday, but the 18th
+		{"0 0 18 * */2", "2026-10-18 00:00", true},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		if got := s.Match(at(tt.time)); got != tt.want {
+			t.Errorf("%q Match(%s) = %v, want %v", tt.spec, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	tests := []struct {
+		spec, time string
+		within     time.Duration
+		want       string // Empty when no occurrence is expected
+	}{
+		{"30 2 * * *", "2026-10-16 02:30", time.Hour, "2026-10-16 02:30"}, // t itself is included
+		{"30 2 * * *", "2026-10-16 10:00", 24 * time.Hour, "2026-10-16 02:30"},
+		{"30 2 * * *", "2026-10-16 02:29", 24 * time.Hour, "2026-10-15 02:30"},
+		{"30 2 * * *", "2026-10-16 10:00", time.Hour, ""},
+		{"0 0 * * sun", "2026-10-16 12:00", 7 * 24 * time.Hour, "2026-10-11 00:00"},
+		{"*/10 * * * *", "2026-10-16 12:34", time.Hour, "2026-10-16 12:30"},
+		{"* * * * *", "2026-10-16 12:34", time.Minute, "2026-10-16 12:34"},
+		{"15 * * * *", "2026-10-16 12:14", time.Hour, "2026-10-16 11:15"},
+		{"15 * * * *", "2026-10-16 12:14", 59 * time.Minute, ""}, // 11:15 is 59m30s back
+		{"0 3 1 jan *", "2026-10-16 12:00", 365 * 24 * time.Hour, "2026-01-01 03:00"},
+		{"0 3 1 jan *", "2026-01-01 02:00", 365 * 24 * time.Hour, "2025-01-01 03:00"},
+		{"0 0 13 * fri", "2026-10-16 12:00", 7 * 24 * time.Hour, "2026-10-16 00:00"}, // Friday, day fields are or-ed
+		{"0 0 13 * fri", "2026-10-15 12:00", 7 * 24 * time.Hour, "2026-10-13 00:00"},
+		{"59 23 29 feb *", "2026-10-16 12:00", 3 * 365 * 24 * time.Hour, "2024-02-29 23:59"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		got, ok := s.Prev(at(tt.time).Add(30*time.Second), tt.within)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%q Prev(%s, %v) = %v, want none", tt.spec, tt.time, tt.within, got)
+			}
+			continue
+		}
+		if !ok || !got.Equal(at(tt.want)) {
+			t.Errorf("%q Prev(%s, %v) = %v, %v, want %s", tt.spec, tt.time, tt.within, got, ok, tt.want)
+		}
+	}
+}
+
+// prevByMinute is the reference implementation of Prev: a scan of every minute
+func prevByMinute(s *Schedule, t time.Time, within time.Duration) (time.Time, bool) {
+	limit := t.Add(-within)
+	for m := t.Truncate(time.Minute); !m.Before(limit); m = m.Add(-time.Minute) {
+		if s.Match(m) {
+			return m, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func TestPrevMatchesMinuteScan(t *testing.T) {
+	specs := []string{"30 2 * * *", "0 0 * * sun", "*/7 9-17 * * mon-fri", "0 12 1,15 * *", "5 4 * 2,3 *", "0 0 1 * mon"}
+	start := at("2026-01-25 13:37")
+	for _, spec := range specs {
+		s, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", spec, err)
+		}
+		for h := 0; h < 24*60; h += 7 {
+			now := start.Add(time.Duration(h) * time.Hour)
+			got, ok := s.Prev(now, 8*24*time.Hour)
+			want, wantOK := prevByMinute(s, now, 8*24*time.Hour)
+			if ok != wantOK || !got.Equal(want) {
+				t.Fatalf("%q Prev(%s) = %v, %v, want %v, %v", spec, now, got, ok, want, wantOK)
+			}
+		}
+	}
+}
+
+func BenchmarkPrevWeek(b *testing.B) {
+	s, _ := Parse("0 2 1 * *")
+	now := at("2026-10-16 12:00")
+	for i := 0; i < b.N; i++ {
+		s.Prev(now, 7*24*time.Hour)
+	}
+}
@@ -0,0 +1,84 @@
+package macros
+
+import (
+	"regexp"
+	"strings"
+)
+
+// macroPattern matches Nagios-style macros such as $HOSTADDRESS$ or $ARG1$,
+// and the "$$" escape of a literal dollar sign
+var macroPattern = regexp.MustCompile(`\$\$|\$([A-Z][A-Z0-9_]*)\$`)
+
+// indexedPattern matches the numbered macro families ($ARGn$ and $USERn$)
+var indexedPattern = regexp.MustCompile(`^(ARG|USER)[1-9][0-9]*$`)
+
+// standard lists the object macros the scheduler knows how to expand
+var standard = map[string]bool{
+	"HOSTNAME":           true,
+	"HOSTADDRESS":        true,
+	"ADDRESS":            true,
+	"SERVICEDESC":        true,
+	"HOSTSTATE":          true,
+	"HOSTSTATEID":        true,
+	"HOSTSTATETYPE":      true,
+	"HOSTATTEMPT":        true,
+	"MAXHOSTATTEMPTS":    true,
+	"HOSTOUTPUT":         true,
+	"SERVICESTATE":       true,
+	"SERVICESTATEID":     true,
+	"SERVICESTATETYPE":   true,
+	"SERVICEATTEMPT":     true,
+	"MAXSERVICEATTEMPTS": true,
+	"SERVICEOUTPUT":      true,
+}
+
+// DefaultIllegalOutputChars are the characters removed from plugin output before it is
+// expanded into a command line run by "/bin/sh -c", as Nagios illegal_macro_output_chars
+const DefaultIllegalOutputChars = "`~$&|;'\"<>()\\\n\r"
+
+// StripOutput removes the illegal characters from a plugin output used as a macro value
+func StripOutput(output, illegal string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(illegal, r) {
+			return -1
+		}
+		return r
+	}, output)
+}
+
+// SplitCommand splits a "command!arg1!arg2" reference into its name and arguments
+func SplitCommand(ref string) (string, []string) {
+	parts := strings.Split(ref, "!")
+	return parts[0], parts[1:]
+}
+
+// Expand replaces every known macro in s with its value.
+// Macros without a value are left untouched and "$$" is unescaped to "$"
+// in s only: substituted values are copied as they are.
+func Expand(s string, values map[string]string) string {
+	return macroPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+		if v, ok := values[m[1:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// Find returns the names of all macros referenced in s, in order of appearance
+func Find(s string) []string {
+	var names []string
+	for _, m := range macroPattern.FindAllStringSubmatch(s, -1) {
+		if m[1] != "" {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// IsKnown reports whether name is a macro the scheduler can expand
+func IsKnown(name string) bool {
+	return standard[name] || indexedPattern.MatchString(name)
+}
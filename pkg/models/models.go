@@ -1,19 +1,41 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // GlobalConfig is the final payload sent to the Scheduler
 type GlobalConfig struct {
 	Commands      []Command      `json:"commands"`
 	Contacts      []Contact      `json:"contacts"`
+	ContactGroups []ContactGroup `json:"contactgroups"`
+	Escalations   []Escalation   `json:"escalations"`
 	TimePeriods   []TimePeriod   `json:"timeperiods"`
 	Hosts         []Host         `json:"hosts"`
 	Services      []Service      `json:"services"`
 	HostGroups    []HostGroup    `json:"hostgroups"`
 	ServiceGroups []ServiceGroup `json:"servicegroups"`
 	Downtimes     []Downtime     `json:"downtimes"`
+	ServiceDependencies []ServiceDependency `yaml:"servicedependencies" json:"servicedependencies"`
+	HostDependencies    []HostDependency    `yaml:"hostdependencies" json:"hostdependencies"`
+	// Resources holds the $USERn$ macros (e.g. USER1: /usr/lib/nagios/plugins)
+	Resources     map[string]string `yaml:"resources" json:"resources"`
 }
 
+// State types of the soft/hard state machine
+const (
+	StateTypeSoft = "SOFT"
+	StateTypeHard = "HARD"
+)
+
+// Host states
+const (
+	HostUp          = 0
+	HostDown        = 1
+	HostUnreachable = 2
+)
+
 // Host represents a monitored machine or template
 type Host struct {
 	ID           string   `yaml:"id" json:"id"`
@@ -21,16 +43,56 @@ type Host struct {
 	Address      string   `yaml:"address" json:"address"`
 	CheckCommand string   `yaml:"check_command" json:"check_command"`
 	CheckPeriod  string   `yaml:"check_period" json:"check_period"`
+	NotificationPeriod string `yaml:"notification_period" json:"notification_period"`
 	Contacts     []string `yaml:"contacts" json:"contacts"`
+	ContactGroups []string `yaml:"contact_groups" json:"contact_groups"`
+	Escalations  []string `yaml:"escalations" json:"escalations"`
 	HostGroups   []string `yaml:"hostgroups" json:"hostgroups"`
 	Register     *bool    `yaml:"register" json:"register"` 
 	InDowntime   bool     `json:"in_downtime"`
 	Parents      []string `yaml:"parents" json:"parents"`
+	CheckInterval  int     `yaml:"check_interval" json:"check_interval"`
+	NormalInterval int     `yaml:"normal_interval" json:"normal_interval"`
+	MaxAttempts   int      `yaml:"max_attempts" json:"max_attempts"`
+	RetryInterval int      `yaml:"retry_interval" json:"retry_interval"`
+	FlapDetectionEnabled *bool   `yaml:"flap_detection_enabled" json:"flap_detection_enabled"`
+	LowFlapThreshold     float64 `yaml:"low_flap_threshold" json:"low_flap_threshold"`
+	HighFlapThreshold    float64 `yaml:"high_flap_threshold" json:"high_flap_threshold"`
+	ActiveChecksEnabled  *bool   `yaml:"active_checks_enabled" json:"active_checks_enabled"`
+	PassiveChecksEnabled *bool   `yaml:"passive_checks_enabled" json:"passive_checks_enabled"`
+	CheckFreshness       bool    `yaml:"check_freshness" json:"check_freshness"`
+	FreshnessThreshold   int     `yaml:"freshness_threshold" json:"freshness_threshold"`
+	FreshnessCommand     string  `yaml:"freshness_command" json:"freshness_command"`
+	StaleState           int     `yaml:"stale_state" json:"stale_state"` // Exit code forced when stale, UNKNOWN (3) by default
+	NotificationInterval int     `yaml:"notification_interval" json:"notification_interval"` // Re-notification delay, 0 notifies once
+	EventHandler         string  `yaml:"event_handler" json:"event_handler"`
+	EventHandlerEnabled  *bool   `yaml:"event_handler_enabled" json:"event_handler_enabled"`
 	// Runtime State Fields
 	IsUp         bool      `json:"is_up"`     
 	Status       int       `json:"status"`    
+	CurrentState int       `json:"current_state"` // HostUp, HostDown or HostUnreachable
+	StateType    string    `json:"state_type"`
+	Attempts     int       `json:"attempts"`
 	NextCheck    time.Time `json:"next_check"`
+	LastCheck    time.Time `json:"last_check"`
 	Output       string    `json:"output"`
+	// Flap detection
+	StateHistory       []int     `json:"state_history"`
+	StateRecorded      time.Time `json:"state_recorded"` // Last addition to StateHistory
+	IsFlapping         bool    `json:"is_flapping"`
+	PercentStateChange float64 `json:"percent_state_change"`
+	NotificationNumber int       `json:"current_notification_number"`
+	LastNotification   time.Time `json:"last_notification"`
+	NotifiedSince      time.Time `json:"notified_since"` // First notification of the current problem
+	Acknowledged       bool      `json:"problem_has_been_acknowledged"` // Mirrored from the Reactionner
+	LongOutput         string       `json:"long_output,omitempty"`
+	Perfdata           []PerfMetric `json:"perfdata,omitempty"`
+	// Check performance of the last active check
+	Latency       float64 `json:"latency"`        // Seconds between the scheduled time and the dispatch
+	ExecutionTime float64 `json:"execution_time"` // Seconds spent running the plugin
+	LastPoller    string  `json:"last_poller,omitempty"`
+	// Settings changed at runtime through the Scheduler API
+	Overrides Overrides `json:"overrides"`
 }
 
 // Service represents a specific check linked to a host
@@ -40,32 +102,156 @@ type Service struct {
 	HostName      string   `yaml:"host_name" json:"host_name"`
 	CheckCommand  string   `yaml:"check_command" json:"check_command"`
 	CheckPeriod   string   `yaml:"check_period" json:"check_period"`
+	NotificationPeriod string `yaml:"notification_period" json:"notification_period"`
 	Contacts      []string `yaml:"contacts" json:"contacts"`
+	ContactGroups []string `yaml:"contact_groups" json:"contact_groups"`
+	Escalations   []string `yaml:"escalations" json:"escalations"`
 	ServiceGroups []string `yaml:"servicegroups" json:"servicegroups"`
 	Register      *bool    `yaml:"register" json:"register"` 
 	InDowntime    bool     `json:"in_downtime"`
+	CheckInterval  int     `yaml:"check_interval" json:"check_interval"`
+	NormalInterval int     `yaml:"normal_interval" json:"normal_interval"`
+	MaxAttempts   int      `yaml:"max_attempts" json:"max_attempts"`
+	RetryInterval int      `yaml:"retry_interval" json:"retry_interval"`
+	FlapDetectionEnabled *bool   `yaml:"flap_detection_enabled" json:"flap_detection_enabled"`
+	LowFlapThreshold     float64 `yaml:"low_flap_threshold" json:"low_flap_threshold"`
+	HighFlapThreshold    float64 `yaml:"high_flap_threshold" json:"high_flap_threshold"`
+	ActiveChecksEnabled  *bool   `yaml:"active_checks_enabled" json:"active_checks_enabled"`
+	PassiveChecksEnabled *bool   `yaml:"passive_checks_enabled" json:"passive_checks_enabled"`
+	CheckFreshness       bool    `yaml:"check_freshness" json:"check_freshness"`
+	FreshnessThreshold   int     `yaml:"freshness_threshold" json:"freshness_threshold"`
+	FreshnessCommand     string  `yaml:"freshness_command" json:"freshness_command"`
+	StaleState           int     `yaml:"stale_state" json:"stale_state"` // Exit code forced when stale, UNKNOWN (3) by default
+	NotificationInterval int     `yaml:"notification_interval" json:"notification_interval"` // Re-notification delay, 0 notifies once
+	EventHandler         string  `yaml:"event_handler" json:"event_handler"`
+	EventHandlerEnabled  *bool   `yaml:"event_handler_enabled" json:"event_handler_enabled"`
 	// Runtime State Fields
 	CurrentState  int       `json:"current_state"`
+	StateType     string    `json:"state_type"`
 	Attempts      int       `json:"attempts"`
-	MaxAttempts   int       `json:"max_attempts"`
 	NextCheck     time.Time `json:"next_check"`
+	LastCheck     time.Time `json:"last_check"`
 	Output        string    `json:"output"`
+	// Flap detection
+	StateHistory       []int     `json:"state_history"`
+	StateRecorded      time.Time `json:"state_recorded"` // Last addition to StateHistory
+	IsFlapping         bool    `json:"is_flapping"`
+	PercentStateChange float64 `json:"percent_state_change"`
+	NotificationNumber int       `json:"current_notification_number"`
+	LastNotification   time.Time `json:"last_notification"`
+	NotifiedSince      time.Time `json:"notified_since"` // First notification of the current problem
+	Acknowledged       bool      `json:"problem_has_been_acknowledged"` // Mirrored from the Reactionner
+	LongOutput         string       `json:"long_output,omitempty"`
+	Perfdata           []PerfMetric `json:"perfdata,omitempty"`
+	// Check performance of the last active check
+	Latency       float64 `json:"latency"`        // Seconds between the scheduled time and the dispatch
+	ExecutionTime float64 `json:"execution_time"` // Seconds spent running the plugin
+	LastPoller    string  `json:"last_poller,omitempty"`
+	// Settings changed at runtime through the Scheduler API
+	Overrides Overrides `json:"overrides"`
+}
+
+// Overrides are runtime settings of an object changed through the Scheduler API.
+// A set value takes precedence over the configuration and survives reloads.
+type Overrides struct {
+	ActiveChecks  *bool `json:"active_checks_enabled,omitempty"`
+	PassiveChecks *bool `json:"passive_checks_enabled,omitempty"`
+	Notifications *bool `json:"notifications_enabled,omitempty"`
+	EventHandlers *bool `json:"event_handler_enabled,omitempty"`
+	FlapDetection *bool `json:"flap_detection_enabled,omitempty"`
+}
+
+// Key returns the unique identity of a service across hosts ("host_name/id").
+// It is used as the scheduler map key, the check task ID and the notification entity.
+func (s Service) Key() string {
+	return ServiceKey(s.HostName, s.ID)
+}
+
+// ServiceKey builds the unique identity of a service attached to a host
+func ServiceKey(hostName, serviceID string) string {
+	return hostName + "/" + serviceID
+}
+
+// ParseServiceKey splits a service identity into its host name and service ID
+func ParseServiceKey(key string) (string, string, bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
 }
 
 // CheckResult is sent by Pollers to the Scheduler
 type CheckResult struct {
-	ID     string `json:"id"`
-	Status int    `json:"status"`
-	Output string `json:"output"`
+	ID      string `json:"id"`
+	Status  int    `json:"status"`
+	Output  string `json:"output"`
+	Passive bool   `json:"passive,omitempty"` // Submitted through the passive results API
+	Seq     uint64 `json:"seq,omitempty"`     // Sequence of the task this result answers
+	// Output split by the perfdata parser
+	ShortOutput string       `json:"short_output,omitempty"`
+	LongOutput  string       `json:"long_output,omitempty"`
+	Perfdata    []PerfMetric `json:"perfdata,omitempty"`
+	// Execution details reported by the Poller
+	PollerID      string    `json:"poller_id,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	ExecutionTime float64   `json:"execution_time,omitempty"` // Seconds
+	ExitSignal    int       `json:"exit_signal,omitempty"`    // Signal that terminated the plugin
+}
+
+// PushResultsReply answers a batch of results pushed by a Poller
+type PushResultsReply struct {
+	Accepted int   `json:"accepted"`
+	Rejected []int `json:"rejected,omitempty"` // Positions in the batch of the results to push again
+}
+
+// PerfMetric is one item of plugin performance data: 'label'=value[UOM];[warn];[crit];[min];[max]
+type PerfMetric struct {
+	Label string   `json:"label"`
+	Value float64  `json:"value"`
+	UOM   string   `json:"uom,omitempty"`
+	Warn  string   `json:"warn,omitempty"` // Threshold range such as "10", "5:20" or "@10:20"
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// PassiveResult is submitted by external systems for a host (no service_id) or a service
+type PassiveResult struct {
+	HostName  string `json:"host_name"`
+	ServiceID string `json:"service_id,omitempty"`
+	Status    int    `json:"status"`
+	Output    string `json:"output"`
 }
 
+// Notification types
+const (
+	NotificationProblem         = "PROBLEM"
+	NotificationRecovery        = "RECOVERY"
+	NotificationAcknowledgement = "ACKNOWLEDGEMENT"
+	NotificationFlappingStart   = "FLAPPINGSTART"
+	NotificationFlappingStop    = "FLAPPINGSTOP"
+	NotificationDowntimeStart   = "DOWNTIMESTART"
+	NotificationDowntimeEnd     = "DOWNTIMEEND"
+)
+
 // NotificationRequest is sent to the Reactionner
 type NotificationRequest struct {
-	EntityID  string    `json:"entity_id"`
-	Type      string    `json:"type"`
-	State     int       `json:"state"`
-	Output    string    `json:"output"`
-	Timestamp time.Time `json:"timestamp"`
+	EntityID           string    `json:"entity_id"`
+	Type               string    `json:"type"`
+	HostName           string    `json:"host_name"`
+	ServiceID          string    `json:"service_id,omitempty"`
+	State              int       `json:"state"`
+	StateName          string    `json:"state_name"`
+	PreviousState      int       `json:"previous_state"`
+	PreviousStateName  string    `json:"previous_state_name"`
+	NotificationNumber int       `json:"notification_number"`
+	Contacts           []Contact `json:"contacts,omitempty"` // Recipients, escalations applied
+	Output             string    `json:"output"`
+	Author             string    `json:"author,omitempty"`
+	Comment            string    `json:"comment,omitempty"`
+	Timestamp          time.Time `json:"timestamp"`
 }
 
 // Host group
@@ -82,6 +268,30 @@ type ServiceGroup struct {
 	Members []string `yaml:"members" json:"members"`
 }
 
+// ContactGroup gathers contacts notified together
+type ContactGroup struct {
+	ID      string   `yaml:"id" json:"id"`
+	Alias   string   `yaml:"alias" json:"alias"`
+	Members []string `yaml:"members" json:"members"`
+}
+
+// Escalation replaces the recipients of a problem once it reaches a notification
+// number (first_notification..last_notification) or has been notified for some time
+// (first_notification_time..last_notification_time). A zero last bound is open-ended.
+// Times and interval are in the scheduler interval_unit, like the object intervals.
+// Objects reference escalations by ID in their 'escalations' list.
+type Escalation struct {
+	ID                    string   `yaml:"id" json:"id"`
+	FirstNotification     int      `yaml:"first_notification" json:"first_notification"`
+	LastNotification      int      `yaml:"last_notification" json:"last_notification"`
+	FirstNotificationTime int      `yaml:"first_notification_time" json:"first_notification_time"`
+	LastNotificationTime  int      `yaml:"last_notification_time" json:"last_notification_time"`
+	NotificationInterval  int      `yaml:"notification_interval" json:"notification_interval"` // Overrides the object interval when set
+	EscalationPeriod      string   `yaml:"escalation_period" json:"escalation_period"`
+	Contacts              []string `yaml:"contacts" json:"contacts"`
+	ContactGroups         []string `yaml:"contact_groups" json:"contact_groups"`
+}
+
 // Command defines the check execution string
 type Command struct {
 	ID          string `yaml:"id" json:"id"`
@@ -95,10 +305,16 @@ type Contact struct {
 	Email    string `yaml:"email" json:"email"`
 }
 
-// TimePeriod defines weekly time ranges
+// TimePeriod defines weekly time ranges ("HH:MM-HH:MM"), date exceptions and exclusions.
+// Exception keys use Nagios date syntax: "2026-12-25", "december 25", "day -1",
+// "monday 3" or "monday 1 january"; they replace the weekday ranges for that day.
 type TimePeriod struct {
 	ID        string   `yaml:"id" json:"id"`
+	Alias     string   `yaml:"alias" json:"alias"`
 	Register  *bool    `yaml:"register" json:"register"` 
+	Timezone   string              `yaml:"timezone" json:"timezone"`
+	Exceptions map[string][]string `yaml:"exceptions" json:"exceptions"`
+	Exclude    []string            `yaml:"exclude" json:"exclude"`
 	Monday    []string `yaml:"monday" json:"monday"`
 	Tuesday   []string `yaml:"tuesday" json:"tuesday"`
 	Wednesday []string `yaml:"wednesday" json:"wednesday"`
@@ -108,19 +324,70 @@ type TimePeriod struct {
 	Sunday    []string `yaml:"sunday" json:"sunday"`
 }
 
-// Downtime represents a scheduled maintenance period
+// Downtime represents a scheduled maintenance period.
+// A fixed downtime covers [StartTime, EndTime). A flexible downtime starts when the
+// object goes non-OK within that window and lasts Duration minutes. A recurring
+// downtime follows a cron Schedule (lasting Duration minutes) or a TimePeriod.
+// HostGroup and ServiceGroup downtimes are expanded to their members by the Arbiter.
 type Downtime struct {
-	ID        string    `json:"id"`
-	HostName  string    `json:"host_name"`
-	ServiceID string    `json:"service_id,omitempty"` 
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	Author    string    `json:"author"`
-	Comment   string    `json:"comment"`
+	ID           string    `yaml:"id" json:"id"`
+	HostName     string    `yaml:"host_name" json:"host_name"`
+	ServiceID    string    `yaml:"service_id" json:"service_id,omitempty"` 
+	HostGroup    string    `yaml:"hostgroup_name" json:"hostgroup_name,omitempty"`
+	ServiceGroup string    `yaml:"servicegroup_name" json:"servicegroup_name,omitempty"`
+	StartTime    time.Time `yaml:"start_time" json:"start_time"`
+	EndTime      time.Time `yaml:"end_time" json:"end_time"`
+	Flexible     bool      `yaml:"flexible" json:"flexible,omitempty"`
+	Duration     int       `yaml:"duration" json:"duration,omitempty"` // Minutes
+	Schedule     string    `yaml:"schedule" json:"schedule,omitempty"` // e.g. "0 2 * * sun"
+	TimePeriod   string    `yaml:"timeperiod" json:"timeperiod,omitempty"`
+	Author       string    `yaml:"author" json:"author"`
+	Comment      string    `yaml:"comment" json:"comment"`
+	// Runtime (Scheduler): when a flexible downtime was triggered
+	TriggerTime time.Time `json:"trigger_time,omitempty"`
+}
+
+// Recurring reports whether the downtime follows a schedule instead of fixed dates
+func (d Downtime) Recurring() bool {
+	return d.Schedule != "" || d.TimePeriod != ""
+}
+
+// Expired reports whether a one-off downtime can no longer apply at time t
+func (d Downtime) Expired(t time.Time) bool {
+	if d.Recurring() {
+		return !d.EndTime.IsZero() && t.After(d.EndTime)
+	}
+	end := d.EndTime
+	if d.Flexible {
+		end = end.Add(time.Duration(d.Duration) * time.Minute)
+	}
+	return t.After(end)
+}
+
+// ServiceDependency makes a dependent service rely on a master service.
+// Failure criteria are Nagios-style letters: o (OK), w (WARNING), c (CRITICAL),
+// u (UNKNOWN), n (none), e.g. "w,c,u".
+type ServiceDependency struct {
+	HostName                    string `yaml:"host_name" json:"host_name"`
+	ServiceID                   string `yaml:"service_id" json:"service_id"`
+	DependentHostName           string `yaml:"dependent_host_name" json:"dependent_host_name"`
+	DependentServiceID          string `yaml:"dependent_service_id" json:"dependent_service_id"`
+	ExecutionFailureCriteria    string `yaml:"execution_failure_criteria" json:"execution_failure_criteria"`
+	NotificationFailureCriteria string `yaml:"notification_failure_criteria" json:"notification_failure_criteria"`
+}
+
+// HostDependency makes a dependent host rely on a master host.
+// Failure criteria letters: o (UP), d (DOWN), u (UNREACHABLE), n (none).
+type HostDependency struct {
+	HostName                    string `yaml:"host_name" json:"host_name"`
+	DependentHostName           string `yaml:"dependent_host_name" json:"dependent_host_name"`
+	ExecutionFailureCriteria    string `yaml:"execution_failure_criteria" json:"execution_failure_criteria"`
+	NotificationFailureCriteria string `yaml:"notification_failure_criteria" json:"notification_failure_criteria"`
 }
 
 // CheckTask represents a single execution job for a Poller
 type CheckTask struct {
 	ID      string `json:"id"`      
 	Command string `json:"command"` 
+	Seq     uint64 `json:"seq"`     // Dispatch sequence, echoed back in the CheckResult
 }
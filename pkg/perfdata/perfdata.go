@@ -0,0 +1,203 @@
+package perfdata
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"shinsakuto/pkg/models"
+)
+
+// Parse splits plugin output into its short output (first line), long output
+// (following lines) and performance data, following the Nagios plugin format:
+//
+//	SHORT OUTPUT | PERFDATA
+//	LONG OUTPUT LINE 1
+//	LONG OUTPUT LINE 2 | PERFDATA
+//	PERFDATA
+//
+// Malformed perfdata items are skipped.
+func Parse(output string) (short, long string, metrics []models.PerfMetric) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+
+	var perf []string
+	short, first := cut(lines[0])
+	perf = append(perf, first)
+
+	var longLines []string
+	for i := 1; i < len(lines); i++ {
+		if !strings.Contains(lines[i], "|") {
+			longLines = append(longLines, lines[i])
+			continue
+		}
+		// Everything after the first pipe of the long output is perfdata
+		text, data := cut(lines[i])
+		longLines = append(longLines, text)
+		perf = append(perf, data)
+		perf = append(perf, lines[i+1:]...)
+		break
+	}
+
+	long = strings.TrimSpace(strings.Join(longLines, "\n"))
+	for _, p := range perf {
+		metrics = append(metrics, parseItems(p)...)
+	}
+	return strings.TrimSpace(short), long, metrics
+}
+
+// Split fills the short output, long output and perfdata of a result from its raw output
+func Split(res *models.CheckResult) {
+	res.ShortOutput, res.LongOutput, res.Perfdata = Parse(res.Output)
+}
+
+// cut separates the text of a line from the perfdata following its first pipe
+func cut(line string) (string, string) {
+	if i := strings.Index(line, "|"); i >= 0 {
+		return strings.TrimRight(line[:i], " \t\r"), strings.TrimSpace(line[i+1:])
+	}
+	return strings.TrimRight(line, "\r"), ""
+}
+
+// parseItems reads space separated items; labels containing spaces are single-quoted
+func parseItems(s string) []models.PerfMetric {
+	var metrics []models.PerfMetric
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var label string
+		if s[0] == '\'' {
+			// A doubled quote stands for a literal quote inside the label
+			var b strings.Builder
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			label, s = b.String(), s[min(i+1, len(s)):]
+			if !strings.HasPrefix(s, "=") {
+				s = skipItem(s)
+				continue
+			}
+			s = s[1:]
+		} else {
+			eq := strings.IndexByte(s, '=')
+			sp := strings.IndexAny(s, " \t")
+			if eq <= 0 || (sp >= 0 && sp < eq) {
+				s = skipItem(s)
+				continue
+			}
+			label, s = s[:eq], s[eq+1:]
+		}
+
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		if m, ok := parseValue(label, s[:end]); ok {
+			metrics = append(metrics, m)
+		}
+		s = s[end:]
+	}
+	return metrics
+}
+
+// skipItem drops the remainder of a malformed item
+func skipItem(s string) string {
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[i:]
+	}
+	return ""
+}
+
+// parseValue reads "value[UOM];[warn];[crit];[min];[max]"
+func parseValue(label, s string) (models.PerfMetric, bool) {
+	fields := strings.Split(s, ";")
+	m := models.PerfMetric{Label: label}
+
+	// The value is the numeric prefix, the unit of measurement follows it
+	raw := fields[0]
+	i := 0
+	for i < len(raw) && strings.ContainsRune("0123456789.,-+eE", rune(raw[i])) {
+		// An "e" or "E" only belongs to the number when followed by an exponent
+		if (raw[i] == 'e' || raw[i] == 'E') && (i+1 >= len(raw) || !strings.ContainsRune("0123456789+-", rune(raw[i+1]))) {
+			break
+		}
+		i++
+	}
+	v, ok := number(raw[:i])
+	if !ok {
+		return m, false // "U" (undetermined) and garbage carry no value
+	}
+	m.Value, m.UOM = v, raw[i:]
+
+	if len(fields) > 1 {
+		m.Warn = strings.TrimSpace(fields[1])
+	}
+	if len(fields) > 2 {
+		m.Crit = strings.TrimSpace(fields[2])
+	}
+	if len(fields) > 3 {
+		if v, ok := number(fields[3]); ok {
+			m.Min = &v
+		}
+	}
+	if len(fields) > 4 {
+		if v, ok := number(fields[4]); ok {
+			m.Max = &v
+		}
+	}
+	return m, true
+}
+
+// number parses a finite float, accepting a comma as decimal separator.
+// NaN and infinities are refused: the time series stores cannot hold them.
+func number(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.Replace(s, ",", ".", 1))
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// Threshold returns the value of a threshold given as a plain number.
+// Ranges such as "10:20", "~:10" or "@5:10" have no single value.
+func Threshold(s string) (float64, bool) {
+	if strings.ContainsAny(s, ":@~") {
+		return 0, false
+	}
+	return number(s)
+}
+
+// Format writes metrics back in the plugin perfdata syntax, quoting labels when needed
+func Format(metrics []models.PerfMetric) string {
+	items := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		label := m.Label
+		if strings.ContainsAny(label, " \t'=") {
+			label = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+		}
+		fields := []string{strconv.FormatFloat(m.Value, 'f', -1, 64) + m.UOM, m.Warn, m.Crit, "", ""}
+		if m.Min != nil {
+			fields[3] = strconv.FormatFloat(*m.Min, 'f', -1, 64)
+		}
+		if m.Max != nil {
+			fields[4] = strconv.FormatFloat(*m.Max, 'f', -1, 64)
+		}
+		// Trailing empty fields are dropped
+		n := len(fields)
+		for n > 1 && fields[n-1] == "" {
+			n--
+		}
+		items = append(items, label+"="+strings.Join(fields[:n], ";"))
+	}
+	return strings.Join(items, " ")
+}
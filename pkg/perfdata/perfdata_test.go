@@ -0,0 +1,173 @@
+package perfdata
+
+import (
+	"reflect"
+	"testing"
+
+	"shinsakuto/pkg/models"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, output string
+		short, long  string
+		metrics      []models.PerfMetric
+	}{
+		{
+			name:   "no perfdata",
+			output: "OK - all good",
+			short:  "OK - all good",
+		},
+		{
+			name:   "full item",
+			output: "PING OK - rta 0.5ms | rta=0.5ms;100;500;0;1000",
+			short:  "PING OK - rta 0.5ms",
+			metrics: []models.PerfMetric{
+				{Label: "rta", Value: 0.5, UOM: "ms", Warn: "100", Crit: "500", Min: f(0), Max: f(1000)},
+			},
+		},
+		{
+			name:   "units and empty thresholds",
+			output: "DISK OK | /=2643MB;;;0;5000 used=45%;80;90 in=12.5KB;;;; count=3 c=12c",
+			short:  "DISK OK",
+			metrics: []models.PerfMetric{
+				{Label: "/", Value: 2643, UOM: "MB", Min: f(0), Max: f(5000)},
+				{Label: "used", Value: 45, UOM: "%", Warn: "80", Crit: "90"},
+				{Label: "in", Value: 12.5, UOM: "KB"},
+				{Label: "count", Value: 3},
+				{Label: "c", Value: 12, UOM: "c"},
+			},
+		},
+		{
+			name:   "range thresholds are kept as text",
+			output: "OK | load=1.5;@5:10;~:20",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "load", Value: 1.5, Warn: "@5:10", Crit: "~:20"},
+			},
+		},
+		{
+			name:   "quoted labels",
+			output: "OK | 'free space'=10GB 'it''s'=1 'a=b'=2",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "free space", Value: 10, UOM: "GB"},
+				{Label: "it's", Value: 1},
+				{Label: "a=b", Value: 2},
+			},
+		},
+		{
+			name:   "numbers",
+			output: "OK | neg=-1.5 exp=1e3 comma=0,25 plus=+2 unit_e=5e",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "neg", Value: -1.5},
+				{Label: "exp", Value: 1000},
+				{Label: "comma", Value: 0.25},
+				{Label: "plus", Value: 2},
+				{Label: "unit_e", Value: 5, UOM: "e"},
+			},
+		},
+		{
+			name:   "malformed items are skipped",
+			output: "OK | undetermined=U noequals =5 'x'y=1 bad=abc good=1 empty=",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "good", Value: 1},
+			},
+		},
+		{
+			name:   "non-finite numbers are dropped",
+			output: "OK | huge=1e999 nan=NaN inf=Inf bounds=5;;;NaN;+Inf",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "bounds", Value: 5},
+			},
+		},
+		{
+			name:   "unterminated quote swallows the rest",
+			output: "OK | a=1 'b=2 c=3",
+			short:  "OK",
+			metrics: []models.PerfMetric{
+				{Label: "a", Value: 1},
+			},
+		},
+		{
+			name:    "multi-line long output",
+			output:  "DISK WARNING | /=90%\n/var 95% used\n/home 20% used | /var=95%;90\n/home=20%;90",
+			short:   "DISK WARNING",
+			long:    "/var 95% used\n/home 20% used",
+			metrics: []models.PerfMetric{{Label: "/", Value: 90, UOM: "%"}, {Label: "/var", Value: 95, UOM: "%", Warn: "90"}, {Label: "/home", Value: 20, UOM: "%", Warn: "90"}},
+		},
+		{
+			name:   "long output without perfdata",
+			output: "CRITICAL - 2 errors\r\nerror one\r\nerror two\r\n",
+			short:  "CRITICAL - 2 errors",
+			long:   "error one\r\nerror two",
+		},
+		{
+			name:   "pipe only on a long output line",
+			output: "OK\ndetails | x=1",
+			short:  "OK",
+			long:   "details",
+			metrics: []models.PerfMetric{
+				{Label: "x", Value: 1},
+			},
+		},
+		{
+			name: "empty output",
+		},
+	}
+	for _, tt := range tests {
+		short, long, metrics := Parse(tt.output)
+		if short != tt.short || long != tt.long {
+			t.Errorf("%s: output = %q, %q, want %q, %q", tt.name, short, long, tt.short, tt.long)
+		}
+		if !reflect.DeepEqual(metrics, tt.metrics) {
+			t.Errorf("%s: metrics = %+v, want %+v", tt.name, metrics, tt.metrics)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"80", 80, true},
+		{"0,5", 0.5, true},
+		{"-3", -3, true},
+		{"", 0, false},
+		{"10:20", 0, false},
+		{"~:10", 0, false},
+		{"@5:10", 0, false},
+		{"high", 0, false},
+		{"NaN", 0, false},
+		{"-Inf", 0, false},
+		{"1e400", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Threshold(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Threshold(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	metrics := []models.PerfMetric{
+		{Label: "rta", Value: 0.5, UOM: "ms", Warn: "100", Crit: "500", Min: f(0)},
+		{Label: "free space", Value: 10, UOM: "GB"},
+		{Label: "it's", Value: 1, Max: f(2)},
+	}
+	out := Format(metrics)
+	if want := "rta=0.5ms;100;500;0 'free space'=10GB 'it''s'=1;;;;2"; out != want {
+		t.Errorf("Format = %q, want %q", out, want)
+	}
+	if _, _, got := Parse("OK | " + out); !reflect.DeepEqual(got, metrics) {
+		t.Errorf("Parse(Format) = %+v, want %+v", got, metrics)
+	}
+}
@@ -0,0 +1,364 @@
+package timeperiod
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+// searchDays bounds the look-ahead of NextValid; yearly exceptions need a full year
+const searchDays = 367
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March, "april": time.April,
+	"may": time.May, "june": time.June, "july": time.July, "august": time.August,
+	"september": time.September, "october": time.October, "november": time.November, "december": time.December,
+}
+
+// span is a time range within a day, in minutes since midnight [start, end)
+type span struct{ start, end int }
+
+// exception is a compiled date exception; the most specific matching exception wins
+type exception struct {
+	priority int
+	matches  func(y int, m time.Month, d int, wd time.Weekday) bool
+	spans    []span
+}
+
+// period is a compiled TimePeriod
+type period struct {
+	id         string
+	loc        *time.Location
+	week       [7][]span
+	exceptions []exception
+	exclude    []string
+}
+
+// Set evaluates a collection of time periods by ID
+type Set struct {
+	periods map[string]*period
+}
+
+// NewSet compiles the given time periods. Invalid periods are skipped and reported.
+func NewSet(tps []models.TimePeriod) (*Set, []error) {
+	s := &Set{periods: make(map[string]*period)}
+	var errs []error
+	for _, tp := range tps {
+		p, err := compile(tp)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("timeperiod %s: %v", tp.ID, err))
+			continue
+		}
+		s.periods[tp.ID] = p
+	}
+	for id, p := range s.periods {
+		for _, ex := range p.exclude {
+			if _, ok := s.periods[ex]; !ok {
+				errs = append(errs, fmt.Errorf("timeperiod %s: unknown excluded period %s", id, ex))
+			}
+		}
+		if s.excludes(id, id, map[string]bool{}) {
+			errs = append(errs, fmt.Errorf("timeperiod %s: exclusion cycle", id))
+		}
+	}
+	return s, errs
+}
+
+// excludes reports whether period 'from' reaches 'target' through its exclusions
+func (s *Set) excludes(from, target string, seen map[string]bool) bool {
+	p, ok := s.periods[from]
+	if !ok || seen[from] {
+		return false
+	}
+	seen[from] = true
+	for _, ex := range p.exclude {
+		if ex == target || s.excludes(ex, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether a period is defined
+func (s *Set) Has(id string) bool {
+	_, ok := s.periods[id]
+	return ok
+}
+
+// In reports whether t falls inside the period. An empty or unknown
+// period ID is treated as always valid ("24x7").
+func (s *Set) In(id string, t time.Time) bool {
+	if s == nil || id == "" {
+		return true
+	}
+	if _, ok := s.periods[id]; !ok {
+		return true
+	}
+	return s.in(id, t, 0)
+}
+
+func (s *Set) in(id string, t time.Time, depth int) bool {
+	p, ok := s.periods[id]
+	if !ok || depth > len(s.periods) {
+		return false
+	}
+	lt := t.In(p.loc)
+	minute := lt.Hour()*60 + lt.Minute()
+	inside := false
+	for _, sp := range p.spansFor(lt) {
+		if minute >= sp.start && minute < sp.end {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return false
+	}
+	for _, ex := range p.exclude {
+		if s.in(ex, t, depth+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// NextValid returns the earliest time at or after t that falls inside the period.
+// The boolean is false when no valid time exists within the next year.
+func (s *Set) NextValid(id string, t time.Time) (time.Time, bool) {
+	if s.In(id, t) {
+		return t, true
+	}
+	p := s.periods[id]
+	related := s.closure(id)
+	lt := t.In(p.loc)
+	day := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, p.loc)
+
+	// Validity only changes on range boundaries, so only those need testing
+	for i := 0; i < searchDays; i++ {
+		start, end := day.AddDate(0, 0, i), day.AddDate(0, 0, i+1)
+		var candidates []time.Time
+		for _, rp := range related {
+			candidates = append(candidates, rp.boundaries(start, end)...)
+		}
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].Before(candidates[b]) })
+		for _, c := range candidates {
+			if c.After(t) && s.in(id, c, 0) {
+				return c, true
+			}
+		}
+	}
+	return t, false
+}
+
+// closure returns the period and every period it excludes, recursively
+func (s *Set) closure(id string) []*period {
+	var out []*period
+	seen := make(map[string]bool)
+	var walk func(string)
+	walk = func(pid string) {
+		p, ok := s.periods[pid]
+		if !ok || seen[pid] {
+			return
+		}
+		seen[pid] = true
+		out = append(out, p)
+		for _, ex := range p.exclude {
+			walk(ex)
+		}
+	}
+	walk(id)
+	return out
+}
+
+// boundaries lists the range starts and ends of the period within [from, to)
+func (p *period) boundaries(from, to time.Time) []time.Time {
+	var out []time.Time
+	lf := from.In(p.loc)
+	first := time.Date(lf.Year(), lf.Month(), lf.Day(), 0, 0, 0, 0, p.loc).AddDate(0, 0, -1)
+	for d := first; d.Before(to); d = d.AddDate(0, 0, 1) {
+		for _, sp := range p.spansFor(d) {
+			for _, m := range []int{sp.start, sp.end} {
+				b := d.Add(time.Duration(m) * time.Minute)
+				if !b.Before(from) && b.Before(to) {
+					out = append(out, b)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// spansFor returns the ranges applying to the day of lt (already in the period location)
+func (p *period) spansFor(lt time.Time) []span {
+	best := -1
+	var spans []span
+	for _, ex := range p.exceptions {
+		if ex.priority > best && ex.matches(lt.Year(), lt.Month(), lt.Day(), lt.Weekday()) {
+			best, spans = ex.priority, ex.spans
+		}
+	}
+	if best >= 0 {
+		return spans
+	}
+	return p.week[lt.Weekday()]
+}
+
+// compile parses a TimePeriod definition
+func compile(tp models.TimePeriod) (*period, error) {
+	p := &period{id: tp.ID, loc: time.Local, exclude: tp.Exclude}
+	if tp.Timezone != "" {
+		loc, err := time.LoadLocation(tp.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", tp.Timezone)
+		}
+		p.loc = loc
+	}
+
+	days := map[time.Weekday][]string{
+		time.Monday: tp.Monday, time.Tuesday: tp.Tuesday, time.Wednesday: tp.Wednesday,
+		time.Thursday: tp.Thursday, time.Friday: tp.Friday, time.Saturday: tp.Saturday, time.Sunday: tp.Sunday,
+	}
+	for wd, ranges := range days {
+		spans, err := parseRanges(ranges)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.ToLower(wd.String()), err)
+		}
+		p.week[wd] = spans
+	}
+
+	for spec, ranges := range tp.Exceptions {
+		ex, err := parseException(spec)
+		if err != nil {
+			return nil, err
+		}
+		if ex.spans, err = parseRanges(ranges); err != nil {
+			return nil, fmt.Errorf("%s: %v", spec, err)
+		}
+		p.exceptions = append(p.exceptions, ex)
+	}
+	return p, nil
+}
+
+// parseRanges parses "HH:MM-HH:MM" ranges; several ranges may share an entry separated by commas.
+// "00:00-00:00" denotes an empty range, useful to close a day through an exception.
+func parseRanges(ranges []string) ([]span, error) {
+	var spans []span
+	for _, entry := range ranges {
+		for _, r := range strings.Split(entry, ",") {
+			r = strings.TrimSpace(r)
+			if r == "" {
+				continue
+			}
+			parts := strings.Split(r, "-")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid range %q", r)
+			}
+			start, err1 := parseClock(parts[0])
+			end, err2 := parseClock(parts[1])
+			if err1 != nil || err2 != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q", r)
+			}
+			if end > start {
+				spans = append(spans, span{start, end})
+			}
+		}
+	}
+	return spans, nil
+}
+
+// parseClock converts "HH:MM" (up to "24:00") into minutes since midnight
+func parseClock(s string) (int, error) {
+	hm := strings.Split(strings.TrimSpace(s), ":")
+	if len(hm) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.Atoi(hm[0])
+	m, err2 := strconv.Atoi(hm[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// parseException compiles a Nagios-style date specification
+func parseException(spec string) (exception, error) {
+	f := strings.Fields(strings.ToLower(spec))
+	bad := fmt.Errorf("invalid date exception %q", spec)
+
+	// 2026-12-25
+	if len(f) == 1 {
+		d, err := time.Parse("2006-01-02", f[0])
+		if err != nil {
+			return exception{}, bad
+		}
+		return exception{priority: 5, matches: func(y int, m time.Month, day int, _ time.Weekday) bool {
+			return y == d.Year() && m == d.Month() && day == d.Day()
+		}}, nil
+	}
+	if len(f) < 2 || len(f) > 3 {
+		return exception{}, bad
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n == 0 {
+		return exception{}, bad
+	}
+
+	// december 25, december -1
+	if month, ok := months[f[0]]; ok && len(f) == 2 {
+		return exception{priority: 4, matches: func(y int, m time.Month, day int, _ time.Weekday) bool {
+			return m == month && day == dayOfMonth(y, m, n)
+		}}, nil
+	}
+
+	// day 1, day -1
+	if f[0] == "day" && len(f) == 2 {
+		return exception{priority: 3, matches: func(y int, m time.Month, day int, _ time.Weekday) bool {
+			return day == dayOfMonth(y, m, n)
+		}}, nil
+	}
+
+	// monday 3, monday 1 january, friday -1 december
+	wd, ok := weekdays[f[0]]
+	if !ok {
+		return exception{}, bad
+	}
+	if len(f) == 3 {
+		month, ok := months[f[2]]
+		if !ok {
+			return exception{}, bad
+		}
+		return exception{priority: 2, matches: func(y int, m time.Month, day int, w time.Weekday) bool {
+			return m == month && w == wd && nthWeekday(y, m, day, n)
+		}}, nil
+	}
+	return exception{priority: 1, matches: func(y int, m time.Month, day int, w time.Weekday) bool {
+		return w == wd && nthWeekday(y, m, day, n)
+	}}, nil
+}
+
+// dayOfMonth resolves a day number, negative values counting back from the month end
+func dayOfMonth(y int, m time.Month, n int) int {
+	if n > 0 {
+		return n
+	}
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return last + n + 1
+}
+
+// nthWeekday reports whether a day is the n-th (or n-th last) occurrence of its weekday in the month
+func nthWeekday(y int, m time.Month, day, n int) bool {
+	if n > 0 {
+		return (day-1)/7+1 == n
+	}
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return (last-day)/7+1 == -n
+}
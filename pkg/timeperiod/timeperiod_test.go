@@ -0,0 +1,157 @@
+package timeperiod
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"shinsakuto/pkg/models"
+)
+
+var weekdaysOnly = []string{"09:00-17:00"}
+
+// testSet compiles the periods used by the tests; test times are given in UTC
+func testSet(t *testing.T) *Set {
+	t.Helper()
+	s, errs := NewSet([]models.TimePeriod{
+		{
+			ID: "workhours", Timezone: "UTC",
+			Monday: weekdaysOnly, Tuesday: weekdaysOnly, Wednesday: weekdaysOnly, Thursday: weekdaysOnly, Friday: weekdaysOnly,
+			Exceptions: map[string][]string{
+				"2026-12-25":        {"00:00-00:00"}, // Closed on a fixed date
+				"day -1":            {"09:00-12:00"}, // Half day at the end of every month
+				"friday -1 october": {"00:00-00:00"}, // Closed on the last Friday of October
+				"monday 1 january":  {"10:00-11:00"},
+			},
+		},
+		{ID: "split", Timezone: "UTC", Saturday: []string{"08:00-10:00, 14:00-16:00"}},
+		{ID: "24x7", Timezone: "UTC",
+			Monday: []string{"00:00-24:00"}, Tuesday: []string{"00:00-24:00"}, Wednesday: []string{"00:00-24:00"},
+			Thursday: []string{"00:00-24:00"}, Friday: []string{"00:00-24:00"}, Saturday: []string{"00:00-24:00"},
+			Sunday: []string{"00:00-24:00"}},
+		{ID: "lunch", Timezone: "UTC", Monday: []string{"12:00-13:00"}, Friday: []string{"12:00-13:00"}},
+		{ID: "24x7-but-lunch", Timezone: "UTC", Exclude: []string{"lunch"},
+			Monday: []string{"00:00-24:00"}, Friday: []string{"00:00-24:00"}},
+		{ID: "paris", Timezone: "Europe/Paris", Friday: []string{"09:00-10:00"}},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("NewSet: %v", errs)
+	}
+	return s
+}
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestIn(t *testing.T) {
+	s := testSet(t)
+	tests := []struct {
+		period, time string
+		want         bool
+	}{
+		{"workhours", "2026-10-16 09:00", true}, // Friday, range start is included
+		{"workhours", "2026-10-16 16:59", true},
+		{"workhours", "2026-10-16 17:00", false}, // Range end is excluded
+		{"workhours", "2026-10-16 08:59", false},
+		{"workhours", "2026-10-17 10:00", false}, // Saturday
+		{"workhours", "2026-12-25 10:00", false}, // Fixed date exception
+		{"workhours", "2026-12-24 10:00", true},
+		{"workhours", "2026-09-30 11:00", true}, // day -1
+		{"workhours", "2026-09-30 13:00", false},
+		{"workhours", "2026-02-28 11:00", true},  // day -1 applies to a Saturday too
+		{"workhours", "2026-10-30 10:00", false}, // Last Friday of October
+		{"workhours", "2026-10-23 10:00", true},
+		{"workhours", "2026-01-05 10:30", true}, // First Monday of January
+		{"workhours", "2026-01-05 09:30", false},
+		{"split", "2026-10-17 09:00", true},
+		{"split", "2026-10-17 12:00", false},
+		{"split", "2026-10-17 15:59", true},
+		{"24x7", "2026-10-18 23:59", true},
+		{"24x7-but-lunch", "2026-10-19 11:59", true},
+		{"24x7-but-lunch", "2026-10-19 12:30", false}, // Excluded period
+		{"24x7-but-lunch", "2026-10-20 12:30", false}, // Tuesday is not in the period
+		{"paris", "2026-10-16 07:30", true},           // 09:30 in Paris (UTC+2)
+		{"paris", "2026-10-16 09:30", false},
+		{"", "2026-10-18 03:00", true}, // No period: always valid
+		{"unknown", "2026-10-18 03:00", true},
+	}
+	for _, tt := range tests {
+		if got := s.In(tt.period, at(tt.time)); got != tt.want {
+			t.Errorf("In(%s, %s) = %v, want %v", tt.period, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestNextValid(t *testing.T) {
+	s := testSet(t)
+	tests := []struct {
+		period, from, want string
+	}{
+		{"workhours", "2026-10-16 10:00", "2026-10-16 10:00"}, // Already valid
+		{"workhours", "2026-10-16 17:30", "2026-10-19 09:00"}, // Over the weekend
+		{"workhours", "2026-10-29 18:00", "2026-10-31 09:00"}, // Closed Friday, then the month-end half day
+		{"workhours", "2026-12-24 18:00", "2026-12-28 09:00"}, // Skips Christmas and the weekend
+		{"split", "2026-10-17 11:00", "2026-10-17 14:00"},
+		{"24x7-but-lunch", "2026-10-19 12:15", "2026-10-19 13:00"}, // End of the excluded period
+		{"paris", "2026-10-16 08:30", "2026-10-23 07:00"},
+	}
+	for _, tt := range tests {
+		got, ok := s.NextValid(tt.period, at(tt.from))
+		if !ok || !got.Equal(at(tt.want)) {
+			t.Errorf("NextValid(%s, %s) = %v, %v, want %s", tt.period, tt.from, got.UTC(), ok, tt.want)
+		}
+	}
+}
+
+func TestNextValidNever(t *testing.T) {
+	s, errs := NewSet([]models.TimePeriod{{ID: "never", Timezone: "UTC"}})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got, ok := s.NextValid("never", at("2026-10-16 10:00")); ok {
+		t.Errorf("NextValid(never) = %v, want no valid time", got)
+	}
+}
+
+func TestNewSetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		tp   models.TimePeriod
+		err  string
+	}{
+		{"bad range", models.TimePeriod{ID: "p", Monday: []string{"09:00"}}, "invalid range"},
+		{"reversed range", models.TimePeriod{ID: "p", Monday: []string{"17:00-09:00"}}, "invalid range"},
+		{"bad clock", models.TimePeriod{ID: "p", Monday: []string{"09:60-10:00"}}, "invalid range"},
+		{"past midnight", models.TimePeriod{ID: "p", Monday: []string{"09:00-24:01"}}, "invalid range"},
+		{"bad timezone", models.TimePeriod{ID: "p", Timezone: "Mars/Olympus"}, "invalid timezone"},
+		{"bad date", models.TimePeriod{ID: "p", Exceptions: map[string][]string{"2026-13-01": {"09:00-10:00"}}}, "invalid date exception"},
+		{"day zero", models.TimePeriod{ID: "p", Exceptions: map[string][]string{"day 0": {"09:00-10:00"}}}, "invalid date exception"},
+		{"unknown weekday", models.TimePeriod{ID: "p", Exceptions: map[string][]string{"funday 1": {"09:00-10:00"}}}, "invalid date exception"},
+		{"unknown month", models.TimePeriod{ID: "p", Exceptions: map[string][]string{"monday 1 smarch": {"09:00-10:00"}}}, "invalid date exception"},
+		{"exception range", models.TimePeriod{ID: "p", Exceptions: map[string][]string{"day 1": {"late"}}}, "invalid range"},
+		{"unknown exclude", models.TimePeriod{ID: "p", Exclude: []string{"missing"}}, "unknown excluded period"},
+	}
+	for _, tt := range tests {
+		_, errs := NewSet([]models.TimePeriod{tt.tp})
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.err) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.err)
+		}
+	}
+}
+
+func TestNewSetExclusionCycle(t *testing.T) {
+	s, errs := NewSet([]models.TimePeriod{
+		{ID: "a", Exclude: []string{"b"}, Monday: []string{"00:00-24:00"}},
+		{ID: "b", Exclude: []string{"a"}, Monday: []string{"00:00-24:00"}},
+	})
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want a cycle reported for both periods", errs)
+	}
+	// Evaluation still terminates
+	s.In("a", at("2026-10-19 10:00"))
+}